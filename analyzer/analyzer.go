@@ -0,0 +1,103 @@
+// Package analyzer provides analyzers that report benchmark loops
+// that have not been unrolled and common mistakes in benchmarks.
+//
+// To bundle them into a multichecker or vet tool alongside other analyzers,
+// add Analyzers to its list. Each analyzer's flags are namespaced
+// by the driver under its name, such as -unrollbench.factor.
+// The analyzers work on syntax alone and neither export nor import facts,
+// so they add no work for a package's dependencies.
+package analyzer
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"strconv"
+
+	"github.com/josharian/unrollbench/unroll"
+	"golang.org/x/tools/go/analysis"
+)
+
+const doc = `report benchmark loops that have not been unrolled
+
+The unrollbench analyzer reports top level loops of the form
+
+	for i := 0; i < b.N; i++ {
+		// body
+	}
+
+in benchmarks, and suggests replacing them with an unrolled loop
+that amortizes the loop overhead over several copies of the body.`
+
+// Analyzers are all the analyzers in this package.
+var Analyzers = []*analysis.Analyzer{Analyzer, Lint}
+
+// Analyzer reports benchmark loops that have not been unrolled.
+var Analyzer = &analysis.Analyzer{
+	Name: "unrollbench",
+	Doc:  doc,
+	URL:  "https://github.com/josharian/unrollbench",
+	Run:  run,
+}
+
+var factor = factorFlag(unroll.DefaultFactor)
+
+func init() {
+	Analyzer.Flags.Var(&factor, "factor", "suggest unrolling loops into `n` copies of their body, at least 2")
+}
+
+// A factorFlag is the value of -factor, which rejects factors
+// that would not unroll anything.
+type factorFlag int
+
+func (f *factorFlag) String() string { return strconv.Itoa(int(*f)) }
+
+func (f *factorFlag) Set(s string) error {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return errors.New("want a number")
+	}
+	if n < 2 {
+		return fmt.Errorf("factor %d would not unroll anything; want at least 2", n)
+	}
+	*f = factorFlag(n)
+	return nil
+}
+
+func run(pass *analysis.Pass) (interface{}, error) {
+	for _, f := range pass.Files {
+		for _, d := range f.Decls {
+			fn, ok := d.(*ast.FuncDecl)
+			if !ok || !unroll.IsBench(fn) {
+				continue
+			}
+			for _, s := range fn.Body.List {
+				if unroll.HasGenerated(s) {
+					continue
+				}
+				n, ok := unroll.Unroll(s, int(factor))
+				if !ok {
+					continue
+				}
+				text, err := unroll.Format(pass.Fset, f, s, n)
+				if err != nil {
+					return nil, err
+				}
+				pass.Report(analysis.Diagnostic{
+					Pos:     s.Pos(),
+					End:     s.End(),
+					Message: "benchmark loop in " + fn.Name.Name + " is not unrolled",
+					SuggestedFixes: []analysis.SuggestedFix{{
+						Message: "Unroll benchmark loop",
+						TextEdits: []analysis.TextEdit{{
+							Pos:     s.Pos(),
+							End:     s.End(),
+							NewText: text,
+						}},
+					}},
+				})
+			}
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,29 @@
+package analyzer_test
+
+import (
+	"testing"
+
+	"github.com/josharian/unrollbench/analyzer"
+	"golang.org/x/tools/go/analysis/analysistest"
+)
+
+func TestAnalyzer(t *testing.T) {
+	analysistest.RunWithSuggestedFixes(t, analysistest.TestData(), analyzer.Analyzer, "a")
+}
+
+func TestFactorFlag(t *testing.T) {
+	f := analyzer.Analyzer.Flags.Lookup("factor")
+	defer f.Value.Set(f.DefValue)
+	for _, bad := range []string{"-1", "0", "1", "x"} {
+		if err := f.Value.Set(bad); err == nil {
+			t.Errorf("-factor=%s accepted", bad)
+		}
+	}
+	if err := f.Value.Set("2"); err != nil {
+		t.Errorf("-factor=2: %v", err)
+	}
+}
+
+func TestLint(t *testing.T) {
+	analysistest.Run(t, analysistest.TestData(), analyzer.Lint, "b")
+}
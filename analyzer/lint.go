@@ -0,0 +1,56 @@
+package analyzer
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/josharian/unrollbench/lint"
+	"golang.org/x/tools/go/analysis"
+)
+
+// lintDoc is completed with the list of rules in init.
+const lintDoc = `report common mistakes in benchmarks
+
+The benchlint analyzer reports the findings of the rules of unrollbench lint,
+which are listed below. Use unrollbench lint -fix to fix them.
+`
+
+// Lint reports the findings of the rules in package lint.
+var Lint = &analysis.Analyzer{
+	Name: "benchlint",
+	Doc:  lintDoc,
+	URL:  "https://github.com/josharian/unrollbench",
+	Run:  runLint,
+}
+
+var disabled string
+
+func init() {
+	for _, r := range lint.Rules {
+		Lint.Doc += fmt.Sprintf("\n\t%s: %s", r.Name, r.Doc)
+	}
+	Lint.Flags.StringVar(&disabled, "disable", "", "skip the rules in the comma-separated `list`")
+}
+
+func runLint(pass *analysis.Pass) (interface{}, error) {
+	off := make(map[string]bool)
+	for _, name := range strings.Split(disabled, ",") {
+		off[strings.TrimSpace(name)] = true
+	}
+	var rules []*lint.Rule
+	for _, r := range lint.Rules {
+		if !off[r.Name] {
+			rules = append(rules, r)
+		}
+	}
+	for _, f := range pass.Files {
+		for _, finding := range lint.Check(pass.Fset, f, rules) {
+			pass.Report(analysis.Diagnostic{
+				Pos:      finding.Pos,
+				Category: finding.Rule,
+				Message:  fmt.Sprintf("%s: %s", finding.Func, finding.Message),
+			})
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,48 @@
+package a
+
+import "testing"
+
+func BenchmarkLoop(b *testing.B) {
+	x := 0
+	for i := 0; i < b.N; i++ { // want `benchmark loop in BenchmarkLoop is not unrolled`
+		x++
+	}
+	_ = x
+}
+
+func benchmarkHelper(b *testing.B, n int) {
+	for i := 0; i < b.N; i++ { // want `benchmark loop in benchmarkHelper is not unrolled`
+		_ = n * n
+	}
+}
+
+func BenchmarkNested(b *testing.B) {
+	if b.N > 0 {
+		for i := 0; i < b.N; i++ {
+			println()
+		}
+	}
+}
+
+func BenchmarkUnrolled(b *testing.B) {
+	if b.N < 10 {
+		for i := 0; i < b.N; i++ {
+			println()
+		}
+	} else {
+		for i, bNUnroll := 0, b.N/10; i < bNUnroll; i++ {
+			{
+				println()
+			}
+			{
+				println()
+			}
+		}
+	}
+}
+
+func notBench(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		println()
+	}
+}
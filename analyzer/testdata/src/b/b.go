@@ -0,0 +1,20 @@
+package b
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func BenchmarkMatch(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		re := regexp.MustCompile(`a+b`) // want `BenchmarkMatch: regexp.MustCompile with constant arguments runs on every iteration`
+		re.MatchString("aab")
+	}
+}
+
+func BenchmarkUnused(b *testing.B) {
+	for i := 0; i < b.N; i++ { // want `BenchmarkUnused: the loop body only computes results it discards`
+		strings.ToUpper("x")
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+)
+
+var asmCmd = newCommand("asm", "[packages]", "show how unrolling changes the assembly of each benchmark", runAsm)
+
+func runAsm(c *command, args []string) {
+	if len(args) < 1 {
+		c.flags.Usage()
+	}
+	if factor < 2 {
+		fatal("-factor must be at least 2")
+	}
+	pkgs := loadPackages(args)
+
+	tmp, err := os.CreateTemp("", "unrollbench-*.json")
+	if err != nil {
+		fatal(err)
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+	overlayFile = tmp.Name()
+	rewrite(pkgs, false, unrollFile)
+
+	for _, pkg := range pkgs {
+		old := compileAsm(pkg.ImportPath, "")
+		new := compileAsm(pkg.ImportPath, overlayFile)
+		var names []string
+		for name := range new {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if slices.Equal(old[name], new[name]) {
+				continue
+			}
+			printDiffLine("--- " + name)
+			printDiffLine(fmt.Sprintf("+++ %s (unrolled %s)", name, factorDesc()))
+			for _, line := range diffLines(old[name], new[name]) {
+				printDiffLine(line)
+			}
+		}
+	}
+}
+
+// asmInstr matches an instruction in compiler -S output,
+// capturing the instruction without its offset and position.
+var asmInstr = regexp.MustCompile(`^\t0x[0-9a-f]+ \d+ \(.*\)\t(.*)$`)
+
+// compileAsm compiles the tests of the package with import path path,
+// with overlay if not empty, and returns the instructions of its benchmarks,
+// keyed by symbol name.
+func compileAsm(path, overlay string) map[string][]string {
+	args := append([]string{"test", "-c", "-o", os.DevNull}, buildFlagsWith(path, "-S")...)
+	if overlay != "" {
+		args = append(args, "-overlay="+overlay)
+	}
+	args = append(args, path)
+	out, err := exec.CommandContext(ctx, "go", args...).CombinedOutput()
+	if err != nil {
+		os.Stdout.Write(out)
+		fatal(fmt.Sprintf("go %v: %v", args, err))
+	}
+	funcs := make(map[string][]string)
+	var name string // current benchmark, if any
+	s := bufio.NewScanner(bytes.NewReader(out))
+	for s.Scan() {
+		line := s.Text()
+		if !strings.HasPrefix(line, "\t") {
+			// A symbol header, such as "p.BenchmarkX STEXT size=17 ...".
+			name = ""
+			sym, kind, _ := strings.Cut(line, " ")
+			_, fn, _ := strings.Cut(sym, ".")
+			if strings.HasPrefix(kind, "STEXT") && strings.HasPrefix(fn, "Benchmark") {
+				name = sym
+			}
+			continue
+		}
+		if m := asmInstr.FindStringSubmatch(line); name != "" && m != nil {
+			funcs[name] = append(funcs[name], m[1])
+		}
+	}
+	return funcs
+}
+
+// diffLines returns a line diff of old and new, with each line
+// prefixed by "-" if only in old, "+" if only in new, and " " otherwise.
+func diffLines(old, new []string) []string {
+	// lcs[i][j] is the length of the longest common subsequence of old[i:] and new[j:].
+	lcs := make([][]int, len(old)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(new)+1)
+	}
+	for i := len(old) - 1; i >= 0; i-- {
+		for j := len(new) - 1; j >= 0; j-- {
+			if old[i] == new[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	var d []string
+	i, j := 0, 0
+	for i < len(old) || j < len(new) {
+		switch {
+		case i < len(old) && j < len(new) && old[i] == new[j]:
+			d = append(d, " "+old[i])
+			i++
+			j++
+		case j == len(new) || i < len(old) && lcs[i+1][j] >= lcs[i][j+1]:
+			d = append(d, "-"+old[i])
+			i++
+		default:
+			d = append(d, "+"+new[j])
+			j++
+		}
+	}
+	return d
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Some packages' tests are tightly coupled to assembly or to tables
+// generated by scripts like syscall's mkerrors.sh, and unrolling their
+// benchmarks breaks them or means nothing. loadPackages skips them,
+// unless -asm-coupled is set.
+
+// generators are patterns for the names of scripts
+// that generate a package's assembly stubs and tables.
+var generators = []string{"mkall.sh", "mkerrors*.sh", "mksyscall*", "mksysnum*"}
+
+// asmCoupled returns why pkg's tests look coupled to assembly
+// or generated tables, or "" if they do not.
+func asmCoupled(pkg *build.Package) string {
+	for _, pattern := range generators {
+		if m, _ := filepath.Glob(filepath.Join(pkg.Dir, pattern)); len(m) > 0 {
+			sort.Strings(m)
+			return "generated by " + filepath.Base(m[0])
+		}
+	}
+	// Test files in the package can declare functions
+	// implemented in the package's assembly.
+	var asm []byte
+	for _, name := range pkg.SFiles {
+		data, err := os.ReadFile(filepath.Join(pkg.Dir, name))
+		if err != nil {
+			continue
+		}
+		asm = append(asm, data...)
+	}
+	if len(asm) == 0 {
+		return ""
+	}
+	for _, name := range pkg.TestGoFiles {
+		f, err := parser.ParseFile(token.NewFileSet(), filepath.Join(pkg.Dir, name), nil, parser.SkipObjectResolution)
+		if err != nil {
+			continue
+		}
+		for _, d := range f.Decls {
+			if fn, ok := d.(*ast.FuncDecl); ok && fn.Body == nil && bytes.Contains(asm, []byte("TEXT ·"+fn.Name.Name+"(")) {
+				return name + " declares " + fn.Name.Name + " in assembly"
+			}
+		}
+	}
+	return ""
+}
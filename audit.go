@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"os"
+	"os/user"
+	"path/filepath"
+	"time"
+
+	"github.com/josharian/unrollbench/unroll"
+)
+
+// auditFile is the append-only log of the files that unrollbench
+// has modified in a module, or a git repository outside modules,
+// relative to its root. Each line is an auditEntry in JSON.
+const auditFile = ".unrollbench/audit.log"
+
+// An auditEntry records a change to a benchmark, or to a file
+// if no benchmark changed.
+type auditEntry struct {
+	Time    time.Time
+	User    string
+	Command string
+	File    string
+	Func    string `json:",omitempty"`
+	Factor  int    `json:",omitempty"` // the largest factor of its loops afterward, 1 if not unrolled
+	Before  string // SHA-256 of the file
+	After   string
+}
+
+// audit appends entries to the audit log for pkg, recording that
+// file, in which funcs changed, was written with out in place of src.
+func audit(pkg *build.Package, file string, funcs []string, src, out []byte) error {
+	u := os.Getenv("USER")
+	if cur, err := user.Current(); err == nil {
+		u = cur.Username
+	}
+	e := auditEntry{
+		Time:    time.Now().UTC(),
+		User:    u,
+		Command: os.Args[1],
+		File:    file,
+		Before:  hash(src),
+		After:   hash(out),
+	}
+	var entries []auditEntry
+	factors := loopFactors(out)
+	for _, fn := range funcs {
+		e.Func, e.Factor = fn, max(factors[fn], 1)
+		entries = append(entries, e)
+	}
+	if len(entries) == 0 {
+		entries = append(entries, e)
+	}
+
+	root := repoRoot(pkg.Dir)
+	if rel, err := filepath.Rel(root, file); err == nil {
+		for i := range entries {
+			entries[i].File = filepath.ToSlash(rel)
+		}
+	}
+	log := filepath.Join(root, auditFile)
+	if err := os.MkdirAll(filepath.Dir(log), 0777); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(log, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	for _, e := range entries {
+		if err := enc.Encode(e); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	return f.Close()
+}
+
+// loopFactors returns the largest factor of the unrolled loops
+// in each benchmark in src, by name.
+func loopFactors(src []byte) map[string]int {
+	factors := make(map[string]int)
+	f, err := parser.ParseFile(token.NewFileSet(), "", src, parser.ParseComments)
+	if err != nil {
+		return factors
+	}
+	for name, stmts := range benchStmts(f) {
+		for _, s := range stmts {
+			if _, ok := unroll.Rerolled(s); ok {
+				factors[name] = max(factors[name], unroll.Factor(s))
+			}
+		}
+	}
+	return factors
+}
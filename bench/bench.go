@@ -0,0 +1,256 @@
+// Package bench parses and summarizes the output of go test -bench.
+package bench
+
+import (
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+
+	"golang.org/x/perf/benchfmt"
+)
+
+// A Result is a single benchmark result line.
+type Result struct {
+	Pkg    string // from the preceding "pkg:" line, if any
+	Name   string // including any -GOMAXPROCS suffix
+	N      int
+	Values map[string]float64 // by unit, such as "ns/op"
+	Config map[string]string  `json:",omitempty"` // other configuration lines in effect, such as goos
+}
+
+// Parse parses the benchmark results in r, which is in the Go benchmark
+// format, ignoring any lines that are not results or configuration.
+func Parse(r io.Reader) ([]*Result, error) {
+	var results []*Result
+	rd := benchfmt.NewReader(r, "")
+	for rd.Scan() {
+		if res, ok := rd.Result().(*benchfmt.Result); ok {
+			results = append(results, fromBenchfmt(res))
+		}
+	}
+	return results, rd.Err()
+}
+
+// ParseLine parses a single benchmark result line.
+// It returns nil if line is not a result.
+func ParseLine(line string) *Result {
+	return new(Stream).Line(line)
+}
+
+// A Stream parses benchmark output that arrives a line at a time,
+// such as from go test -json, keeping track of the configuration lines.
+type Stream struct {
+	config []string // alternating keys and values, in order
+}
+
+// Line parses the next line of output.
+// It returns nil if line is not a result.
+func (s *Stream) Line(line string) *Result {
+	if key, value, ok := configLine(line); ok {
+		for i := 0; i < len(s.config); i += 2 {
+			if s.config[i] == key {
+				s.config = append(s.config[:i], s.config[i+2:]...)
+				break
+			}
+		}
+		if value != "" {
+			s.config = append(s.config, key, value)
+		}
+		return nil
+	}
+	if !strings.HasPrefix(line, "Benchmark") {
+		return nil
+	}
+	rd := new(benchfmt.Reader)
+	rd.Reset(strings.NewReader(line), "", s.config...)
+	for rd.Scan() {
+		if res, ok := rd.Result().(*benchfmt.Result); ok {
+			return fromBenchfmt(res)
+		}
+	}
+	return nil
+}
+
+// configLine splits line into its key and value
+// if it is a configuration line, such as "goos: linux".
+// As in the benchmark format, a key starts with a lower-case letter
+// and has no spaces or upper-case letters, and an empty value
+// removes the key.
+func configLine(line string) (key, value string, ok bool) {
+	key, value, ok = strings.Cut(strings.TrimRight(line, "\r\n"), ":")
+	if !ok || key == "" {
+		return "", "", false
+	}
+	for i, r := range key {
+		if i == 0 && !unicode.IsLower(r) || unicode.IsSpace(r) || unicode.IsUpper(r) {
+			return "", "", false
+		}
+	}
+	if value != "" && value[0] != ' ' && value[0] != '\t' {
+		return "", "", false
+	}
+	return key, strings.TrimSpace(value), true
+}
+
+// fromBenchfmt converts res, keeping the units and values
+// as they were written rather than benchfmt's tidied ones.
+func fromBenchfmt(res *benchfmt.Result) *Result {
+	r := &Result{
+		Pkg:    res.GetConfig("pkg"),
+		Name:   "Benchmark" + string(res.Name),
+		N:      res.Iters,
+		Values: make(map[string]float64),
+	}
+	for _, v := range res.Values {
+		if v.OrigUnit != "" {
+			r.Values[v.OrigUnit] = v.OrigValue
+		} else {
+			r.Values[v.Unit] = v.Value
+		}
+	}
+	for _, c := range res.Config {
+		if c.Key == "pkg" {
+			continue
+		}
+		if r.Config == nil {
+			r.Config = make(map[string]string)
+		}
+		r.Config[c.Key] = string(c.Value)
+	}
+	return r
+}
+
+// A Writer writes results in the Go benchmark format,
+// writing configuration lines only when they change.
+type Writer struct {
+	w *benchfmt.Writer
+}
+
+// NewWriter returns a Writer that writes to w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{benchfmt.NewWriter(w)}
+}
+
+// Write writes r, preceded by any configuration lines that changed.
+func (w *Writer) Write(r *Result) error {
+	res := &benchfmt.Result{
+		Name:  benchfmt.Name(strings.TrimPrefix(r.Name, "Benchmark")),
+		Iters: r.N,
+	}
+	for _, k := range configOrder(r) {
+		v := r.Config[k]
+		if k == "pkg" {
+			v = r.Pkg
+		}
+		res.Config = append(res.Config, benchfmt.Config{Key: k, Value: []byte(v), File: true})
+	}
+	for _, unit := range unitOrder(r.Values) {
+		res.Values = append(res.Values, benchfmt.Value{Value: r.Values[unit], Unit: unit})
+	}
+	return w.w.Write(res)
+}
+
+// configOrder returns the configuration keys of r in the order go test
+// prints them, followed by any others, such as labels, sorted.
+func configOrder(r *Result) []string {
+	var keys, rest []string
+	for _, k := range []string{"goos", "goarch", "pkg", "cpu"} {
+		if k == "pkg" && r.Pkg != "" || r.Config[k] != "" {
+			keys = append(keys, k)
+		}
+	}
+	for k, v := range r.Config {
+		switch k {
+		case "goos", "goarch", "pkg", "cpu":
+			continue
+		}
+		if v != "" {
+			rest = append(rest, k)
+		}
+	}
+	sort.Strings(rest)
+	return append(keys, rest...)
+}
+
+// unitOrder returns the units in values in the order go test prints them:
+// ns/op first, B/op and allocs/op last, and any others sorted between.
+func unitOrder(values map[string]float64) []string {
+	rank := map[string]int{"ns/op": -1, "B/op": 1, "allocs/op": 2}
+	var units []string
+	for unit := range values {
+		units = append(units, unit)
+	}
+	sort.Slice(units, func(i, j int) bool {
+		if ri, rj := rank[units[i]], rank[units[j]]; ri != rj {
+			return ri < rj
+		}
+		return units[i] < units[j]
+	})
+	return units
+}
+
+// A Key identifies a benchmark.
+type Key struct {
+	Pkg  string
+	Name string
+}
+
+func (k Key) String() string {
+	if k.Pkg == "" {
+		return k.Name
+	}
+	return k.Pkg + "." + k.Name
+}
+
+// A Sample is the values of a metric for one benchmark across runs.
+type Sample []float64
+
+// Group collects the values of unit in results by benchmark.
+// It returns the benchmarks sorted by package, and within each package
+// in the order they first appear, so that the order does not depend on
+// the order in which go test finished the packages.
+func Group(results []*Result, unit string) ([]Key, map[Key]Sample) {
+	var keys []Key
+	samples := make(map[Key]Sample)
+	for _, r := range results {
+		v, ok := r.Values[unit]
+		if !ok {
+			continue
+		}
+		k := Key{r.Pkg, r.Name}
+		if _, ok := samples[k]; !ok {
+			keys = append(keys, k)
+		}
+		samples[k] = append(samples[k], v)
+	}
+	sort.SliceStable(keys, func(i, j int) bool { return keys[i].Pkg < keys[j].Pkg })
+	return keys, samples
+}
+
+// Mean returns the mean of s.
+func (s Sample) Mean() float64 {
+	if len(s) == 0 {
+		return math.NaN()
+	}
+	sum := 0.0
+	for _, v := range s {
+		sum += v
+	}
+	return sum / float64(len(s))
+}
+
+// Spread returns the largest deviation of s from its mean,
+// as a fraction of the mean, like benchstat's "±".
+func (s Sample) Spread() float64 {
+	mean := s.Mean()
+	max := 0.0
+	for _, v := range s {
+		max = math.Max(max, math.Abs(v-mean))
+	}
+	if mean == 0 {
+		return 0
+	}
+	return max / mean
+}
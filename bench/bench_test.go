@@ -0,0 +1,111 @@
+package bench
+
+import (
+	"strings"
+	"testing"
+)
+
+const output = `goos: linux
+goarch: amd64
+pkg: strings
+cpu: Intel(R) Xeon(R) Processor
+BenchmarkIndex-8   	1000000	      1002 ns/op	  16 B/op	       1 allocs/op
+BenchmarkIndex-8   	1000000	       998 ns/op	  16 B/op	       1 allocs/op
+--- FAIL: BenchmarkBroken
+BenchmarkIndex
+PASS
+ok  	strings	2.001s
+pkg: bytes
+BenchmarkIndex-8   	2000000	       500 ns/op
+`
+
+func TestParse(t *testing.T) {
+	results, err := Parse(strings.NewReader(output))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("got %d results, want 3", len(results))
+	}
+	r := results[0]
+	if r.Pkg != "strings" || r.Name != "BenchmarkIndex-8" || r.N != 1000000 ||
+		r.Values["ns/op"] != 1002 || r.Values["B/op"] != 16 || r.Values["allocs/op"] != 1 {
+		t.Errorf("results[0] = %+v", r)
+	}
+	if results[2].Pkg != "bytes" {
+		t.Errorf("results[2].Pkg = %q, want bytes", results[2].Pkg)
+	}
+
+	keys, samples := Group(results, "ns/op")
+	if len(keys) != 2 {
+		t.Fatalf("got keys %v, want 2", keys)
+	}
+	// Sorted by package.
+	if keys[0].Pkg != "bytes" || keys[1].Pkg != "strings" {
+		t.Errorf("got keys %v, want bytes before strings", keys)
+	}
+	s := samples[keys[1]]
+	if mean := s.Mean(); mean != 1000 {
+		t.Errorf("mean = %v, want 1000", mean)
+	}
+	if spread := s.Spread(); spread != 0.002 {
+		t.Errorf("spread = %v, want 0.002", spread)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	results, err := Parse(strings.NewReader(output))
+	if err != nil {
+		t.Fatal(err)
+	}
+	results[2].Config = map[string]string{"unroll": "×4"}
+	var buf strings.Builder
+	w := NewWriter(&buf)
+	for _, r := range results {
+		if err := w.Write(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	const want = `goos: linux
+goarch: amd64
+pkg: strings
+cpu: Intel(R) Xeon(R) Processor
+
+BenchmarkIndex-8 1000000 1002 ns/op 16 B/op 1 allocs/op
+BenchmarkIndex-8 1000000 998 ns/op 16 B/op 1 allocs/op
+
+goos:
+goarch:
+pkg: bytes
+cpu:
+unroll: ×4
+
+BenchmarkIndex-8 2000000 500 ns/op
+`
+	if got := buf.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+	again, err := Parse(strings.NewReader(buf.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(again) != 3 || again[2].Pkg != "bytes" || again[2].Config["unroll"] != "×4" || again[0].Config["goos"] != "linux" {
+		t.Errorf("round trip = %+v", again)
+	}
+}
+
+func TestStream(t *testing.T) {
+	var s Stream
+	var results []*Result
+	for _, line := range strings.SplitAfter(output, "\n") {
+		if r := s.Line(line); r != nil {
+			results = append(results, r)
+		}
+	}
+	if len(results) != 3 {
+		t.Fatalf("got %d results, want 3", len(results))
+	}
+	if r := results[2]; r.Pkg != "bytes" || r.Config["goarch"] != "amd64" || r.Values["ns/op"] != 500 {
+		t.Errorf("results[2] = %+v", r)
+	}
+}
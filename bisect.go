@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/josharian/unrollbench/bench"
+	"golang.org/x/perf/benchmath"
+)
+
+var bisectCmd = newCommand("bisect", "-bench regexp [packages]", "binary search for the smallest unroll factor past which each benchmark stops getting faster", runBisect)
+
+var bisectMax int
+
+func init() {
+	bisectCmd.flags.IntVar(&bisectMax, "max", 64, "search factors up to `n`")
+	bisectCmd.flags.Float64Var(&kneeFraction, "threshold", 0.02, "treat times within `fraction` of the time at -max as unchanged, even if the difference is significant")
+}
+
+// runBisect measures the benchmarks at -max, and then binary searches
+// each benchmark function for the smallest factor whose times are not
+// significantly slower than at -max. Each round runs every benchmark
+// still being searched at the midpoint of its range.
+func runBisect(c *command, args []string) {
+	if len(args) < 1 {
+		c.flags.Usage()
+	}
+	if bisectMax < 2 {
+		fatal("-max must be at least 2")
+	}
+	pkgs := loadPackages(args)
+	openOutputs(c)
+
+	tmp, err := os.CreateTemp("", "unrollbench-*.json")
+	if err != nil {
+		fatal(err)
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+	overlayFile = tmp.Name()
+
+	// run runs the benchmarks unrolled by the factors in t, or by -max.
+	run := func(t factorTable, label string) ([]bench.Key, map[bench.Key]bench.Sample) {
+		benchFactors = t.byDir(pkgs)
+		rewrite(pkgs, false, unrollFile)
+		return bench.Group(goTestBench(args, overlayFile, label), "ns/op")
+	}
+	factor = bisectMax
+	fmt.Printf("Running benchmarks unrolled %d times\n", bisectMax)
+	keys, ref := run(nil, fmt.Sprintf("×%d", bisectMax))
+
+	// Sub-benchmarks share their function's loops, so search by function,
+	// and count a factor as enough only if it is enough for all of them.
+	var funcs []factorKey
+	subs := make(map[factorKey][]bench.Key)
+	lo, hi := make(factorTable), make(factorTable)
+	for _, k := range keys {
+		fk := factorKey{k.Pkg, benchFunc(k.Name)}
+		if subs[fk] == nil {
+			funcs = append(funcs, fk)
+			lo[fk], hi[fk] = 1, bisectMax
+		}
+		subs[fk] = append(subs[fk], k)
+	}
+	means := make(map[bench.Key]map[int]float64) // by factor
+	for _, k := range keys {
+		means[k] = map[int]float64{bisectMax: ref[k].Mean()}
+	}
+
+	for round := 1; ; round++ {
+		t := make(factorTable)
+		for _, fk := range funcs {
+			if lo[fk] < hi[fk] {
+				t[fk] = (lo[fk] + hi[fk]) / 2
+			}
+		}
+		if len(t) == 0 {
+			break
+		}
+		fmt.Printf("Round %d: running %d benchmarks at the middle of their ranges\n", round, len(t))
+		_, samples := run(t, fmt.Sprintf("round %d", round))
+		for fk, mid := range t {
+			enough := true
+			for _, k := range subs[fk] {
+				s := samples[k]
+				if len(s) == 0 {
+					// Missing from the run; assume the worst.
+					enough = false
+					continue
+				}
+				means[k][mid] = s.Mean()
+				if !unchanged(s, ref[k]) {
+					enough = false
+				}
+			}
+			if enough {
+				hi[fk] = mid
+			} else {
+				lo[fk] = mid + 1
+			}
+		}
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "\nname\tknee\ttime/op at knee\ttime/op at ×%d\n", bisectMax)
+	for _, fk := range funcs {
+		f := hi[fk]
+		for _, k := range subs[fk] {
+			fmt.Fprintf(tw, "%s\t%d\t%s\t%s\n", k, f, formatValue(means[k][f], "ns/op"), formatValue(ref[k].Mean(), "ns/op"))
+		}
+	}
+	tw.Flush()
+}
+
+// unchanged reports whether s is not significantly slower than ref,
+// or is within kneeFraction of it.
+func unchanged(s, ref bench.Sample) bool {
+	if s.Mean() <= ref.Mean()*(1+kneeFraction) {
+		return true
+	}
+	a := benchmath.NewSample(s, thresholds())
+	b := benchmath.NewSample(ref, thresholds())
+	c := benchmath.AssumeNothing.Compare(a, b)
+	return c.P >= c.Alpha
+}
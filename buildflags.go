@@ -0,0 +1,63 @@
+package main
+
+import "strings"
+
+// Flags passed to the go command's builds.
+var (
+	gcflags string
+	ldflags string
+)
+
+func init() {
+	for _, c := range []*command{runCmd, rankCmd, testCmd, factorsCmd, tuneCmd, bisectCmd, asmCmd, inlineCmd, sizeCmd} {
+		c.flags.StringVar(&gcflags, "gcflags", "", "pass `[pattern=]flags` to the compiler in each build, as in go build -gcflags")
+		c.flags.StringVar(&ldflags, "ldflags", "", "pass `[pattern=]flags` to the linker in each build, as in go build -ldflags")
+	}
+}
+
+// buildFlags returns the go command flags for -gcflags and -ldflags.
+func buildFlags() []string {
+	var args []string
+	if gcflags != "" {
+		args = append(args, "-gcflags="+gcflags)
+	}
+	if ldflags != "" {
+		args = append(args, "-ldflags="+ldflags)
+	}
+	return args
+}
+
+// buildFlagsWith returns the go command flags for -gcflags and -ldflags,
+// with the compiler flag extra, such as -S, added for the package
+// with import path path. Since the last -gcflags matching a package wins,
+// -gcflags' own flags are repeated for path, even if its pattern
+// does not match it.
+func buildFlagsWith(path, extra string) []string {
+	var args []string
+	switch pattern, flags := splitPattern(gcflags); {
+	case gcflags == "":
+		args = append(args, "-gcflags="+extra)
+	case pattern == "":
+		args = append(args, "-gcflags="+flags+" "+extra)
+	default:
+		args = append(args, "-gcflags="+gcflags, "-gcflags="+path+"="+flags+" "+extra)
+	}
+	if ldflags != "" {
+		args = append(args, "-ldflags="+ldflags)
+	}
+	return args
+}
+
+// splitPattern splits the value of a flag like -gcflags
+// into its package pattern, if any, and its flags.
+// As in the go command, a value starting with - has no pattern.
+func splitPattern(v string) (pattern, flags string) {
+	if strings.HasPrefix(v, "-") {
+		return "", v
+	}
+	pattern, flags, ok := strings.Cut(v, "=")
+	if !ok {
+		return "", v
+	}
+	return pattern, flags
+}
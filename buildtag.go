@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/build/constraint"
+	"go/printer"
+	"go/token"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/josharian/unrollbench/unroll"
+)
+
+// tagged returns the outputs for -tag:
+// the unrolled file, out, becomes the new file name_unrolled_test.go,
+// built only with tag, and the original file, src, is built only without tag.
+func tagged(tag, file string, src, out []byte) ([]output, error) {
+	unrolled := strings.TrimSuffix(file, "_test.go") + "_unrolled_test.go"
+	without, err := constrain(src, tag, false)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", rel(file), err)
+	}
+	with, err := constrain(out, tag, true)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", rel(file), err)
+	}
+	header := []byte("// Code generated by unrollbench from " + filepath.Base(file) + ". DO NOT EDIT.\n\n")
+	return []output{
+		{file, without},
+		{unrolled, append(header, with...)},
+	}, nil
+}
+
+// An archFactor is an entry in -arch.
+type archFactor struct {
+	arch   string
+	factor int
+}
+
+// archFactors are the architectures and factors from -arch, if set.
+var archFactors []archFactor
+
+// parseArchFactors parses -arch, a comma-separated list of arch=factor.
+func parseArchFactors(s string) ([]archFactor, error) {
+	var list []archFactor
+	for _, entry := range strings.Split(s, ",") {
+		arch, n, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		f, err := strconv.Atoi(n)
+		if !ok || err != nil || f < 1 || !token.IsIdentifier(arch) {
+			return nil, fmt.Errorf("bad -arch entry %q; want arch=factor", entry)
+		}
+		list = append(list, archFactor{arch, f})
+	}
+	return list, nil
+}
+
+// archOutputs returns the outputs for -arch:
+// for each architecture, the file unrolled by that architecture's factor
+// becomes the new file name_arch_test.go, and the original file, src,
+// is built only on the other architectures.
+func archOutputs(file string, src []byte) ([]output, error) {
+	header := []byte("// Code generated by unrollbench from " + filepath.Base(file) + ". DO NOT EDIT.\n\n")
+	orig := src
+	var outputs []output
+	for _, a := range archFactors {
+		var err error
+		if orig, err = constrain(orig, a.arch, false); err != nil {
+			return nil, fmt.Errorf("%s: %v", rel(file), err)
+		}
+		fset := token.NewFileSet()
+		f, err := parseFile(fset, file, src)
+		if err != nil {
+			return nil, err
+		}
+		unrollFileBy(fset, f, a.factor, false)
+		var buf bytes.Buffer
+		if err := printer.Fprint(&buf, fset, f); err != nil {
+			return nil, err
+		}
+		out, err := fixImports(file, buf.Bytes())
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", rel(file), err)
+		}
+		if copyComments {
+			if out, err = unroll.CopyComments(out); err != nil {
+				return nil, fmt.Errorf("%s: %v", rel(file), err)
+			}
+		}
+		if out, err = unroll.Checksums(out); err != nil {
+			return nil, fmt.Errorf("%s: %v", rel(file), err)
+		}
+		if lineDirs {
+			if out, err = unroll.LineDirectives(filepath.Base(file), src, out); err != nil {
+				return nil, fmt.Errorf("%s: %v", rel(file), err)
+			}
+		}
+		with, err := constrain(out, a.arch, true)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", rel(file), err)
+		}
+		name := strings.TrimSuffix(file, "_test.go") + "_" + a.arch + "_test.go"
+		outputs = append(outputs, output{name, append(header, with...)})
+	}
+	return append([]output{{file, orig}}, outputs...), nil
+}
+
+// constrain returns src, a Go source file, with its build constraint
+// requiring tag to be set (if on) or unset (if !on).
+func constrain(src []byte, tag string, on bool) ([]byte, error) {
+	var (
+		out   [][]byte
+		found bool
+	)
+	lines := bytes.SplitAfter(src, []byte("\n"))
+	for i, line := range lines {
+		text := string(bytes.TrimSpace(line))
+		if strings.HasPrefix(text, "package ") {
+			out = append(out, lines[i:]...)
+			break
+		}
+		switch {
+		case constraint.IsGoBuild(text):
+			x, err := constraint.Parse(text)
+			if err != nil {
+				return nil, err
+			}
+			if mentions(x, tag) {
+				x = withTag(x, tag, on)
+			} else {
+				x = &constraint.AndExpr{X: tagExpr(tag, on), Y: x}
+			}
+			line = []byte("//go:build " + x.String() + "\n")
+			found = true
+		case constraint.IsPlusBuild(text):
+			// Stale now; gofmt derives // +build lines
+			// from //go:build when they are needed.
+			continue
+		}
+		out = append(out, line)
+	}
+	if !found {
+		out = append([][]byte{[]byte("//go:build " + tagExpr(tag, on).String() + "\n\n")}, out...)
+	}
+	return bytes.Join(out, nil), nil
+}
+
+func tagExpr(tag string, on bool) constraint.Expr {
+	if on {
+		return &constraint.TagExpr{Tag: tag}
+	}
+	return &constraint.NotExpr{X: &constraint.TagExpr{Tag: tag}}
+}
+
+// mentions reports whether x mentions tag.
+func mentions(x constraint.Expr, tag string) bool {
+	found := false
+	x.Eval(func(t string) bool {
+		found = found || t == tag
+		return false
+	})
+	return found
+}
+
+// withTag returns x with each mention of tag, negated or not,
+// replaced by tagExpr(tag, on).
+func withTag(x constraint.Expr, tag string, on bool) constraint.Expr {
+	switch x := x.(type) {
+	case *constraint.TagExpr:
+		if x.Tag == tag {
+			return tagExpr(tag, on)
+		}
+	case *constraint.NotExpr:
+		if t, ok := x.X.(*constraint.TagExpr); ok && t.Tag == tag {
+			return tagExpr(tag, on)
+		}
+		return &constraint.NotExpr{X: withTag(x.X, tag, on)}
+	case *constraint.AndExpr:
+		return &constraint.AndExpr{X: withTag(x.X, tag, on), Y: withTag(x.Y, tag, on)}
+	case *constraint.OrExpr:
+		return &constraint.OrExpr{X: withTag(x.X, tag, on), Y: withTag(x.Y, tag, on)}
+	}
+	return x
+}
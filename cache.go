@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// The cache holds rewrite results, and with -cache-results benchmark results,
+// in the user cache directory, keyed by the hash of their inputs:
+// file contents, options, and the unrollbench binary itself.
+
+var (
+	useCache     bool
+	cacheResults bool
+)
+
+func init() {
+	for _, c := range []*command{unrollCmd, rerollCmd, normalizeCmd, modernizeCmd, lintCmd} {
+		c.flags.BoolVar(&useCache, "cache", true, "reuse the results of rewriting files whose contents and options are unchanged")
+	}
+	for _, c := range []*command{runCmd, rankCmd, factorsCmd, tuneCmd, bisectCmd} {
+		c.flags.BoolVar(&cacheResults, "cache-results", false, "reuse benchmark results if no file in the packages has changed, instead of running the benchmarks again")
+	}
+}
+
+// binaryHash is the hash of the running executable,
+// so that a new unrollbench does not trust an old one's results.
+var binaryHash = sync.OnceValue(func() string {
+	exe, err := os.Executable()
+	if err != nil {
+		return ""
+	}
+	data, err := os.ReadFile(exe)
+	if err != nil {
+		return ""
+	}
+	return hash(data)
+})
+
+// cacheKey returns the cache key for parts, or "" if there is no cache.
+func cacheKey(kind string, parts ...string) string {
+	bin := binaryHash()
+	if bin == "" {
+		return ""
+	}
+	return kind + "-" + hash([]byte(bin+"\x00"+strings.Join(parts, "\x00")))
+}
+
+func cacheFile(key string) (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "unrollbench", "cache", key[len(key)-2:], key), nil
+}
+
+// cacheGet reads the entry for key into v, and reports whether it found one.
+func cacheGet(key string, v interface{}) bool {
+	file, err := cacheFile(key)
+	if err != nil {
+		return false
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return false
+	}
+	return json.Unmarshal(data, v) == nil
+}
+
+// cachePut records v as the entry for key.
+// The cache is only an optimization, so it ignores errors.
+func cachePut(key string, v interface{}) {
+	file, err := cacheFile(key)
+	if err != nil {
+		return
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0777); err != nil {
+		return
+	}
+	// Write and rename, so that concurrent readers never see a partial entry.
+	tmp := fmt.Sprintf("%s.%d", file, os.Getpid())
+	if err := os.WriteFile(tmp, data, 0666); err != nil {
+		return
+	}
+	if err := os.Rename(tmp, file); err != nil {
+		os.Remove(tmp)
+	}
+}
+
+// A rewriteEntry is a cached rewrite of a file.
+type rewriteEntry struct {
+	Changed bool
+	Out     []byte
+	Funcs   []string
+}
+
+// rewriteKey returns the cache key for rewriting file, containing src,
+// with fn and the current options, or "" if the rewrite cannot be cached.
+func rewriteKey(fn func(*token.FileSet, *ast.File) bool, file string, src []byte) string {
+	if !useCache || interactive {
+		return ""
+	}
+	return cacheKey("rewrite", rewriteOptions(fn), policyFor(filepath.Dir(file)).key(), file, hash(src))
+}
+
+// resultsKey returns the cache key for the results of running go test
+// with args, or "" if they should not be cached.
+// The key covers the contents of the packages' files and of the overlay,
+// but not of their dependencies, and whether the run was stabilized
+// or in a container.
+func resultsKey(args []string, paths []string, overlay string) string {
+	if !cacheResults {
+		return ""
+	}
+	parts := []string{"perflock=" + stabilization(), "container=" + containerImage}
+	for _, arg := range args {
+		// The overlay's name is often random; its contents are hashed below.
+		if !strings.HasPrefix(arg, "-overlay=") {
+			parts = append(parts, arg)
+		}
+	}
+	for _, pkg := range loadPackages(paths) {
+		names := [][]string{pkg.GoFiles, pkg.CgoFiles, pkg.SFiles, pkg.TestGoFiles, pkg.XTestGoFiles}
+		for _, list := range names {
+			for _, name := range list {
+				data, err := os.ReadFile(filepath.Join(pkg.Dir, name))
+				if err != nil {
+					return ""
+				}
+				parts = append(parts, name, hash(data))
+			}
+		}
+	}
+	if overlay != "" {
+		var o overlayJSON
+		data, err := os.ReadFile(overlay)
+		if err != nil || json.Unmarshal(data, &o) != nil {
+			return ""
+		}
+		files := make([]string, 0, len(o.Replace))
+		for file := range o.Replace {
+			files = append(files, file)
+		}
+		sort.Strings(files)
+		for _, file := range files {
+			data, err := os.ReadFile(o.Replace[file])
+			if err != nil {
+				return ""
+			}
+			parts = append(parts, file, hash(data))
+		}
+	}
+	return cacheKey("results", parts...)
+}
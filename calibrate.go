@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/josharian/unrollbench/bench"
+	"github.com/josharian/unrollbench/unroll"
+)
+
+var calibrateCmd = newCommand("calibrate", "", "measure loop overhead on this machine at several unroll factors", runCalibrate)
+
+// calibrationBodies are the loop bodies that calibrate measures,
+// from least to most work.
+var calibrationBodies = []struct{ name, body string }{
+	{"Empty", ""},
+	{"Inc", "sink++"},
+	{"Call", "nop()"},
+}
+
+func runCalibrate(c *command, args []string) {
+	if len(args) > 0 {
+		c.flags.Usage()
+	}
+	try := parseTry()
+
+	dir, err := os.MkdirTemp("", "unrollbench-calibrate-")
+	if err != nil {
+		fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	src, err := calibrationSource(try)
+	if err != nil {
+		fatal(err)
+	}
+	files := map[string]string{
+		"go.mod":            "module calibrate\n",
+		"calibrate_test.go": string(src),
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0666); err != nil {
+			fatal(err)
+		}
+	}
+	// Run go test in dir, so that it works with and without modules.
+	wd, err := os.Getwd()
+	if err != nil {
+		fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		fatal(err)
+	}
+	fmt.Println("Running calibration benchmarks")
+	benchRegexp = "."
+	results := goTestBench([]string{"."}, "", "calibrate")
+	os.Chdir(wd)
+
+	_, samples := bench.Group(results, "ns/op")
+	means := make(map[string]float64)
+	for k, s := range samples {
+		means[benchFunc(k.Name)] = s.Mean()
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprint(tw, "\nbody")
+	for _, f := range try {
+		fmt.Fprintf(tw, "\t×%d", f)
+	}
+	fmt.Fprintln(tw, "\toverhead/iter\tpicked")
+	suggest := 1
+	for _, b := range calibrationBodies {
+		fmt.Fprint(tw, b.name)
+		row := make([]float64, len(try))
+		for i, f := range try {
+			row[i] = means[calibrationName(b.name, f)]
+			fmt.Fprintf(tw, "\t%s", formatValue(row[i], "ns/op"))
+		}
+		// Unrolled n times, a loop pays its overhead once per n iterations,
+		// so t(n) = work + overhead/n. Solve using the extreme factors.
+		lo, hi := try[0], try[len(try)-1]
+		overhead := math.NaN()
+		if lo != hi {
+			overhead = (row[0] - row[len(row)-1]) / (1/float64(lo) - 1/float64(hi))
+		}
+		f := knee(try, row, kneeFraction)
+		suggest = max(suggest, f)
+		fmt.Fprintf(tw, "\t%s\t%d\n", formatValue(overhead, "ns/op"), f)
+	}
+	tw.Flush()
+	fmt.Printf("\nSuggested -factor: %d\n", suggest)
+}
+
+func calibrationName(body string, factor int) string {
+	return fmt.Sprintf("Benchmark%s%d", body, factor)
+}
+
+// calibrationSource returns a test file containing a benchmark
+// for each calibration body unrolled by each factor in try.
+func calibrationSource(try []int) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString("package calibrate\n\nimport \"testing\"\n\nvar sink int\n\n//go:noinline\nfunc nop() {}\n")
+	for _, b := range calibrationBodies {
+		for _, f := range try {
+			fmt.Fprintf(&buf, "\nfunc %s(b *testing.B) {\n\tfor i := 0; i < b.N; i++ {\n\t\t%s\n\t}\n}\n", calibrationName(b.name, f), b.body)
+		}
+	}
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "calibrate_test.go", buf.Bytes(), parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	c := unroll.Config{
+		Decide: func(fn *ast.FuncDecl, s ast.Stmt, factor int) int {
+			// Leave factor 1 as an ordinary loop, the baseline.
+			name := strings.TrimRight(fn.Name.Name, "0123456789")
+			n, _ := strconv.Atoi(fn.Name.Name[len(name):])
+			if n < 2 {
+				return 0
+			}
+			return n
+		},
+	}
+	c.File(fset, f)
+	buf.Reset()
+	if err := printer.Fprint(&buf, fset, f); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
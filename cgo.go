@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"go/ast"
+	"go/parser"
+	"go/token"
+)
+
+// Reprinting a file that imports "C" can move comments
+// into or out of its cgo preamble, the comment above the import,
+// so such files are skipped unless -cgo is set.
+
+var rewriteCgo bool
+
+func init() {
+	for _, c := range []*command{unrollCmd, rerollCmd, normalizeCmd, modernizeCmd, lintCmd} {
+		c.flags.BoolVar(&rewriteCgo, "cgo", false, "rewrite test files that import \"C\", keeping their cgo preambles byte for byte")
+	}
+}
+
+// cgoImport returns the declaration importing "C" in f, if any.
+func cgoImport(f *ast.File) *ast.GenDecl {
+	for _, d := range f.Decls {
+		gd, ok := d.(*ast.GenDecl)
+		if !ok || gd.Tok != token.IMPORT {
+			continue
+		}
+		for _, spec := range gd.Specs {
+			if spec.(*ast.ImportSpec).Path.Value == `"C"` {
+				return gd
+			}
+		}
+	}
+	return nil
+}
+
+// usesCgo reports whether src, the contents of file, imports "C".
+func usesCgo(file string, src []byte) bool {
+	f, err := parser.ParseFile(token.NewFileSet(), file, src, parser.ImportsOnly)
+	return err == nil && cgoImport(f) != nil
+}
+
+// keepPreamble returns out, a reprinting of src, with everything
+// up to and including the import of "C" restored from src.
+// Rewrites do not change imports, so the two agree about what is there.
+func keepPreamble(src, out []byte) ([]byte, error) {
+	srcEnd, err := cgoImportEnd(src)
+	if err != nil {
+		return nil, err
+	}
+	outEnd, err := cgoImportEnd(out)
+	if err != nil {
+		return nil, err
+	}
+	return append(bytes.Clone(src[:srcEnd]), out[outEnd:]...), nil
+}
+
+// cgoImportEnd returns the offset in src of the end of its import of "C".
+func cgoImportEnd(src []byte) (int, error) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "", src, parser.ImportsOnly|parser.ParseComments)
+	if err != nil {
+		return 0, err
+	}
+	gd := cgoImport(f)
+	if gd == nil {
+		return 0, errors.New(`lost import "C"`)
+	}
+	return fset.Position(gd.End()).Offset, nil
+}
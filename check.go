@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+
+	"github.com/josharian/unrollbench/unroll"
+)
+
+var checkCmd = newCommand("check", "[packages]", "report benchmark loops that are not unrolled", runCheck)
+
+func runCheck(c *command, args []string) {
+	if len(args) < 1 {
+		c.flags.Usage()
+	}
+	var junit junitSuites
+	found := false
+	for _, pkg := range loadPackages(args) {
+		for _, cand := range candidates(testFiles(pkg)) {
+			fset := token.NewFileSet()
+			f, err := parseFile(fset, cand.file, cand.src)
+			if err != nil {
+				fail(err)
+				junit.add(pkg.ImportPath, junitCase{Class: pkg.ImportPath, Name: rel(cand.file), Failure: &junitFailure{Message: "cannot parse", Text: err.Error()}})
+				continue
+			}
+			for _, d := range f.Decls {
+				fn, ok := d.(*ast.FuncDecl)
+				if !ok || !unroll.IsBench(fn) {
+					continue
+				}
+				tc := junitCase{Class: pkg.ImportPath, Name: fn.Name.Name, File: rel(cand.file), Line: fset.Position(fn.Pos()).Line}
+				for _, s := range fn.Body.List {
+					if _, ok := unroll.Unroll(s, unroll.DefaultFactor); ok {
+						msg := fmt.Sprintf("%v: benchmark loop in %s is not unrolled", fset.Position(s.Pos()), fn.Name.Name)
+						fmt.Println(msg)
+						found = true
+						if tc.Failure == nil {
+							tc.Failure = &junitFailure{Message: "benchmark loop is not unrolled"}
+						} else {
+							tc.Failure.Text += "\n"
+						}
+						tc.Failure.Text += msg
+					}
+				}
+				junit.add(pkg.ImportPath, tc)
+			}
+		}
+	}
+	if junitFile != "" {
+		junit.addSkipped()
+		if err := junit.write(junitFile); err != nil {
+			fatal(err)
+		}
+	}
+	if found {
+		exit(1)
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/token"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/josharian/unrollbench/unroll"
+)
+
+var checkStyleCmd = newCommand("check-style", "[-style s] [-fix] [packages]", "report, or with -fix rewrite, benchmark loops not written in the configured style", runCheckStyle)
+
+var (
+	styleFlag string
+	fixStyle  bool
+)
+
+func init() {
+	checkStyleCmd.flags.StringVar(&styleFlag, "style", "", "the loop `style`: classic (i < b.N), range (range b.N), loop (b.Loop), or unrolled=K; a style in the policy file takes precedence")
+	checkStyleCmd.flags.BoolVar(&fixStyle, "fix", false, "rewrite loops into the style in place, where that does not change what they measure")
+}
+
+// A loopStyle is a style of benchmark loop: one of the styles
+// reported by unroll.Style, and for unroll.StyleUnrolled, a factor.
+type loopStyle struct {
+	name   string
+	factor int
+}
+
+func parseStyle(s string) (loopStyle, error) {
+	name, k, unrolled := strings.Cut(s, "=")
+	switch {
+	case name == unroll.StyleUnrolled && unrolled:
+		n, err := strconv.Atoi(k)
+		if err != nil || n < 1 {
+			return loopStyle{}, fmt.Errorf("bad factor in style %q", s)
+		}
+		return loopStyle{name, n}, nil
+	case !unrolled && (name == unroll.StyleClassic || name == unroll.StyleRange || name == unroll.StyleLoop):
+		return loopStyle{name: name}, nil
+	}
+	return loopStyle{}, fmt.Errorf("bad style %q; want classic, range, loop, or unrolled=K", s)
+}
+
+func (s loopStyle) String() string {
+	if s.name == unroll.StyleUnrolled {
+		return fmt.Sprintf("%s=%d", s.name, s.factor)
+	}
+	return s.name
+}
+
+// lang returns the Go version that added the syntax of s, if after go1.21.
+func (s loopStyle) lang() string {
+	switch s.name {
+	case unroll.StyleRange:
+		return langRangeInt
+	case unroll.StyleLoop:
+		return langBLoop
+	}
+	return ""
+}
+
+// styleFor returns the loop style for the package in dir:
+// its policy's, or failing that, -style's.
+func styleFor(dir string) loopStyle {
+	if p := policyFor(dir); p.style.name != "" {
+		return p.style
+	}
+	if styleFlag == "" {
+		return loopStyle{}
+	}
+	s, err := parseStyle(styleFlag)
+	if err != nil {
+		fatal(err)
+	}
+	return s
+}
+
+func runCheckStyle(c *command, args []string) {
+	if len(args) < 1 {
+		c.flags.Usage()
+	}
+	if styleFlag != "" {
+		if _, err := parseStyle(styleFlag); err != nil {
+			fatal(err)
+		}
+	}
+	pkgs := loadPackages(args)
+	record := false
+	for _, pkg := range pkgs {
+		s := styleFor(pkg.Dir)
+		if s.name == "" {
+			fatal(fmt.Sprintf("no loop style for %s; set -style, or style in %s", pkg.ImportPath, policyFile))
+		}
+		if need := s.lang(); fixStyle && need != "" && !langAllows(pkg.Dir, need) {
+			fatal(fmt.Sprintf("style %v needs %s, above the language version of %s, %s", s, need, rel(pkg.Dir), langFor(pkg.Dir)))
+		}
+		// Unrolled loops are recorded for revert, like unroll's.
+		record = record || s.name == unroll.StyleUnrolled
+	}
+	if fixStyle {
+		rewrite(pkgs, record, restyleFile)
+	}
+	if checkStyles(pkgs) {
+		if fixStyle {
+			fmt.Println("The loops above cannot be rewritten into their style without changing what they measure.")
+		}
+		exit(1)
+	}
+}
+
+// restyleFile rewrites the loops in f into its package's style.
+func restyleFile(fset *token.FileSet, f *ast.File) bool {
+	s := styleFor(filepath.Dir(filePath(fset, f)))
+	c := unroll.Config{Factor: s.factor, Decide: filePolicy(fset, f).decide()}
+	return c.Restyle(fset, f, s.name)
+}
+
+// checkStyles prints the benchmark loops in pkgs that are not
+// in their package's style, and reports whether there were any.
+func checkStyles(pkgs []*build.Package) bool {
+	found := false
+	for _, pkg := range pkgs {
+		want := styleFor(pkg.Dir)
+		for _, cand := range candidates(testFiles(pkg)) {
+			fset := token.NewFileSet()
+			f, err := parseFile(fset, cand.file, cand.src)
+			if err != nil {
+				fail(err)
+				continue
+			}
+			type msg struct {
+				line int
+				text string
+			}
+			var msgs []msg
+			for name, body := range unroll.Bodies(f) {
+				for _, s := range body.List {
+					style, k := unroll.Style(s)
+					if style == "" {
+						continue
+					}
+					got := loopStyle{style, k}
+					if got == want {
+						continue
+					}
+					pos := fset.Position(s.Pos())
+					msgs = append(msgs, msg{pos.Line, fmt.Sprintf("%v: %s: loop style is %v, want %v", pos, name, got, want)})
+				}
+			}
+			sort.Slice(msgs, func(i, j int) bool { return msgs[i].line < msgs[j].line })
+			for _, m := range msgs {
+				fmt.Println(m.text)
+				found = true
+			}
+		}
+	}
+	return found
+}
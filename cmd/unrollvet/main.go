@@ -0,0 +1,18 @@
+// Command unrollvet reports benchmark loops that have not been unrolled
+// and common mistakes in benchmarks.
+//
+// It can be run standalone, or via go vet:
+//
+//	go vet -vettool=$(which unrollvet) ./...
+//
+// Flags are prefixed by analyzer name, such as -unrollbench.factor=4
+// or -benchlint.disable=bn-sizing. To bundle the analyzers into
+// another checker, add analyzer.Analyzers to its list.
+package main
+
+import (
+	"github.com/josharian/unrollbench/analyzer"
+	"golang.org/x/tools/go/analysis/multichecker"
+)
+
+func main() { multichecker.Main(analyzer.Analyzers...) }
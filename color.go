@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sync"
+)
+
+// ANSI escape sequences for colorizing diffs.
+const (
+	ansiReset = "\x1b[0m"
+	ansiBold  = "\x1b[1m"
+	ansiRed   = "\x1b[31m"
+	ansiGreen = "\x1b[32m"
+	ansiCyan  = "\x1b[1;36m"
+)
+
+// useColor reports whether to colorize output: only when standard output
+// is a terminal, and NO_COLOR (see https://no-color.org) is not set.
+var useColor = sync.OnceValue(func() bool {
+	if os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	fi, err := os.Stdout.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+})
+
+// unrollStructure matches the lines of the if/else and loop that unrolling
+// wraps around the copies of a loop body, so that they stand out.
+var unrollStructure = regexp.MustCompile(`^\+\s*(if b\.N < \d+ \{|\} else \{|for .*bN(Unroll|Repeat).* \{)$`)
+
+// printDiffLine prints line, a line of a diff, colorized if useColor.
+func printDiffLine(line string) {
+	if !useColor() {
+		fmt.Println(line)
+		return
+	}
+	color := ""
+	switch {
+	case len(line) >= 3 && (line[:3] == "---" || line[:3] == "+++"):
+		color = ansiBold
+	case unrollStructure.MatchString(line):
+		color = ansiCyan
+	case line != "" && line[0] == '-':
+		color = ansiRed
+	case line != "" && line[0] == '+':
+		color = ansiGreen
+	}
+	if color == "" {
+		fmt.Println(line)
+		return
+	}
+	fmt.Println(color + line + ansiReset)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/build"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"sort"
+)
+
+// Flags for running go in a container.
+var (
+	containerImage   string
+	containerRuntime string
+)
+
+func init() {
+	for _, c := range []*command{runCmd, rankCmd, testCmd, factorsCmd, tuneCmd, bisectCmd} {
+		c.flags.StringVar(&containerImage, "container", "", "run go test in a container from `image`, such as golang:1.23, rather than on this machine")
+		c.flags.StringVar(&containerRuntime, "container-runtime", "docker", "run containers with `command`, such as docker or podman")
+	}
+}
+
+// inContainer returns argv, a command and its arguments,
+// run in a fresh container from -container if it is set.
+// The container sees the current directory, the temporary directory,
+// which holds the overlays, and GOPATH, including the module cache,
+// at the same paths as here, so that paths in arguments still work;
+// it runs as the current user, so that it writes nothing else here.
+func inContainer(argv []string) []string {
+	if containerImage == "" {
+		return argv
+	}
+	if _, err := exec.LookPath(containerRuntime); err != nil {
+		fatal(fmt.Sprintf("-container: %v", err))
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		fatal(err)
+	}
+	run := []string{containerRuntime, "run", "--rm", "-i",
+		"--user", fmt.Sprintf("%d:%d", os.Getuid(), os.Getgid()),
+		"-w", wd,
+		"-e", "HOME=" + os.TempDir(),
+		"-e", "GOPATH=" + build.Default.GOPATH,
+		"-e", "GOFLAGS=" + os.Getenv("GOFLAGS"),
+		"-e", "GO111MODULE=" + os.Getenv("GO111MODULE"),
+		"-e", "GOCACHE=" + filepath.Join(os.TempDir(), "unrollbench-container-cache"),
+	}
+	for _, dir := range containerMounts(wd) {
+		run = append(run, "-v", dir+":"+dir)
+	}
+	run = append(run, containerImage)
+	return append(run, argv...)
+}
+
+// containerMounts returns the directories to mount in the container:
+// wd, the temporary directory, GOPATH, and the module cache,
+// leaving out those inside another.
+func containerMounts(wd string) []string {
+	dirs := []string{wd, os.TempDir()}
+	dirs = append(dirs, filepath.SplitList(build.Default.GOPATH)...)
+	if out, err := exec.Command("go", "env", "GOMODCACHE").Output(); err == nil {
+		dirs = append(dirs, string(bytes.TrimSpace(out)))
+	}
+	sort.Strings(dirs)
+	var mounts []string
+	for _, dir := range dirs {
+		if dir == "" || !filepath.IsAbs(dir) || !exists(dir) {
+			continue
+		}
+		if !slices.ContainsFunc(mounts, func(m string) bool { return within(dir, m) }) {
+			mounts = append(mounts, dir)
+		}
+	}
+	return mounts
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/token"
+	"html/template"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/josharian/unrollbench/bench"
+	"github.com/josharian/unrollbench/unroll"
+)
+
+var httpAddr string
+
+func init() {
+	serveCmd.flags.StringVar(&httpAddr, "http", "", "instead, serve a web dashboard over the packages and the runs in -history on `addr`, such as localhost:8080")
+	serveCmd.flags.StringVar(&historyFile, "history", "", "with -http, show the runs recorded in the SQLite database `file` by run -history")
+}
+
+// A dashboard serves a web UI over packages and the runs in -history.
+type dashboard struct {
+	pkgs []*build.Package
+}
+
+// serveDashboard serves the dashboard for pkgs on -http until interrupted.
+func serveDashboard(pkgs []*build.Package) {
+	d := &dashboard{pkgs: pkgs}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", d.index)
+	mux.HandleFunc("/pkg", d.pkg)
+	mux.HandleFunc("/code", d.code)
+	mux.HandleFunc("/run", d.run)
+	srv := &http.Server{Addr: httpAddr, Handler: mux}
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+	fmt.Printf("Serving the dashboard on http://%s/\n", httpAddr)
+	if err := srv.ListenAndServe(); err != nil && ctx.Err() == nil {
+		fatal(err)
+	}
+}
+
+// A dashBench is a benchmark as shown on the dashboard.
+type dashBench struct {
+	Pkg, Name, File string
+	Line            int
+	Status          string // such as "unrolled ×8" or "not unrolled"
+	Unrolled        bool
+}
+
+// benchmarks returns the benchmarks in pkg, with whether their loops are unrolled,
+// and the parsed files they are in, keyed by function name.
+func benchmarks(pkg *build.Package) ([]dashBench, map[string]*parsedFunc) {
+	var list []dashBench
+	funcs := make(map[string]*parsedFunc)
+	for _, file := range testFiles(pkg) {
+		src, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		fset := token.NewFileSet()
+		f, err := parseFile(fset, file, src)
+		if err != nil {
+			continue
+		}
+		for _, d := range f.Decls {
+			fn, ok := d.(*ast.FuncDecl)
+			if !ok || !unroll.IsBench(fn) {
+				continue
+			}
+			b := dashBench{Pkg: pkg.ImportPath, Name: fn.Name.Name, File: rel(file), Line: fset.Position(fn.Pos()).Line, Status: "no benchmark loop"}
+			for _, s := range fn.Body.List {
+				if _, ok := unroll.Rerolled(s); ok {
+					b.Status = "unrolled ×" + strconv.Itoa(unroll.Factor(s))
+					b.Unrolled = true
+					break
+				}
+				if _, ok := unroll.Unroll(s, unroll.DefaultFactor); ok {
+					b.Status = "not unrolled"
+				}
+			}
+			list = append(list, b)
+			funcs[fn.Name.Name] = &parsedFunc{fset, f, fn, src}
+		}
+	}
+	return list, funcs
+}
+
+// A parsedFunc is a function and the file it is in.
+type parsedFunc struct {
+	fset *token.FileSet
+	f    *ast.File
+	fn   *ast.FuncDecl
+	src  []byte
+}
+
+func (d *dashboard) lookup(path string) *build.Package {
+	for _, pkg := range d.pkgs {
+		if pkg.ImportPath == path {
+			return pkg
+		}
+	}
+	return nil
+}
+
+// A dashPkg is a package on the index page.
+type dashPkg struct {
+	Path            string
+	Benchmarks      int
+	Unrolled, Loops int
+}
+
+// A dashRun is a run recorded in -history.
+type dashRun struct {
+	ID, Time, Command, Commit, Machine, GoVersion string
+}
+
+func (d *dashboard) index(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	var data struct {
+		Pkgs    []dashPkg
+		History string
+		Runs    []dashRun
+	}
+	for _, pkg := range d.pkgs {
+		list, _ := benchmarks(pkg)
+		p := dashPkg{Path: pkg.ImportPath, Benchmarks: len(list)}
+		for _, b := range list {
+			if b.Unrolled {
+				p.Unrolled++
+			}
+			if b.Unrolled || b.Status == "not unrolled" {
+				p.Loops++
+			}
+		}
+		data.Pkgs = append(data.Pkgs, p)
+	}
+	if historyFile != "" {
+		data.History = historyFile
+		for _, row := range sqliteQuery("SELECT id, time, command, commit_hash, machine, go_version FROM runs ORDER BY id DESC;") {
+			if len(row) == 6 {
+				data.Runs = append(data.Runs, dashRun{row[0], row[1], row[2], row[3], row[4], row[5]})
+			}
+		}
+	}
+	render(w, indexPage, data)
+}
+
+func (d *dashboard) pkg(w http.ResponseWriter, r *http.Request) {
+	pkg := d.lookup(r.FormValue("path"))
+	if pkg == nil {
+		http.NotFound(w, r)
+		return
+	}
+	list, _ := benchmarks(pkg)
+	render(w, pkgPage, struct {
+		Path       string
+		Benchmarks []dashBench
+	}{pkg.ImportPath, list})
+}
+
+func (d *dashboard) code(w http.ResponseWriter, r *http.Request) {
+	pkg := d.lookup(r.FormValue("path"))
+	if pkg == nil {
+		http.NotFound(w, r)
+		return
+	}
+	_, funcs := benchmarks(pkg)
+	pf := funcs[r.FormValue("func")]
+	if pf == nil {
+		http.NotFound(w, r)
+		return
+	}
+	tf := pf.fset.File(pf.fn.Pos())
+	start := pf.fn.Pos()
+	if pf.fn.Doc != nil {
+		start = pf.fn.Doc.Pos()
+	}
+	data := struct {
+		Path, Func, Source string
+		Unrolled           []string // previews of the loops unrolled
+	}{
+		Path:   pkg.ImportPath,
+		Func:   pf.fn.Name.Name,
+		Source: string(pf.src[tf.Offset(start):tf.Offset(pf.fn.End())]),
+	}
+	for _, s := range pf.fn.Body.List {
+		if n, ok := unroll.Unroll(s, unroll.DefaultFactor); ok {
+			text, err := unroll.Format(pf.fset, pf.f, s, n)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			data.Unrolled = append(data.Unrolled, string(text))
+		}
+	}
+	render(w, codePage, data)
+}
+
+func (d *dashboard) run(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.FormValue("id"))
+	if historyFile == "" || err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	var old, new []*bench.Result
+	for _, row := range sqliteQuery(fmt.Sprintf("SELECT label, pkg, name, iters, unit, value FROM results WHERE run = %d ORDER BY rowid;", id)) {
+		if len(row) != 6 {
+			continue
+		}
+		n, _ := strconv.Atoi(row[3])
+		v, _ := strconv.ParseFloat(row[5], 64)
+		res := &bench.Result{Pkg: row[1], Name: row[2], N: n, Values: map[string]float64{row[4]: v}}
+		switch row[0] {
+		case "original":
+			old = append(old, res)
+		case "unrolled":
+			new = append(new, res)
+		}
+	}
+	var buf bytes.Buffer
+	if len(old) > 0 {
+		compare(&buf, old, new)
+	}
+	render(w, runPage, struct {
+		ID         int
+		Comparison string
+		Decisions  [][]string
+	}{id, buf.String(), sqliteQuery(fmt.Sprintf("SELECT pkg, func, file, factor FROM decisions WHERE run = %d;", id))})
+}
+
+// render executes t with data, reporting any error to the client.
+func render(w http.ResponseWriter, t *template.Template, data interface{}) {
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(buf.Bytes())
+}
+
+const dashStyle = `<!DOCTYPE html>
+<meta charset="utf-8">
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+td, th { padding: 0.2em 1em; text-align: left; }
+pre { background: #f4f4f4; padding: 1em; }
+.unrolled { color: green; }
+</style>
+<p><a href="/">unrollbench</a></p>
+`
+
+var indexPage = template.Must(template.New("index").Parse(dashStyle + `
+<title>unrollbench</title>
+<h1>Packages</h1>
+<table>
+<tr><th>package</th><th>benchmarks</th><th>loops unrolled</th></tr>
+{{range .Pkgs}}<tr><td><a href="/pkg?path={{.Path}}">{{.Path}}</a></td><td>{{.Benchmarks}}</td><td>{{.Unrolled}} of {{.Loops}}</td></tr>
+{{end}}</table>
+{{if .History}}<h1>Runs in {{.History}}</h1>
+<table>
+<tr><th>run</th><th>time</th><th>command</th><th>commit</th><th>machine</th><th>go</th></tr>
+{{range .Runs}}<tr><td><a href="/run?id={{.ID}}">{{.ID}}</a></td><td>{{.Time}}</td><td>{{.Command}}</td><td>{{.Commit}}</td><td>{{.Machine}}</td><td>{{.GoVersion}}</td></tr>
+{{end}}</table>{{end}}
+`))
+
+var pkgPage = template.Must(template.New("pkg").Parse(dashStyle + `
+<title>{{.Path}}</title>
+<h1>{{.Path}}</h1>
+<table>
+<tr><th>benchmark</th><th>file</th><th>loop</th></tr>
+{{range .Benchmarks}}<tr><td><a href="/code?path={{.Pkg}}&func={{.Name}}">{{.Name}}</a></td><td>{{.File}}:{{.Line}}</td><td{{if .Unrolled}} class="unrolled"{{end}}>{{.Status}}</td></tr>
+{{end}}</table>
+`))
+
+var codePage = template.Must(template.New("code").Parse(dashStyle + `
+<title>{{.Func}}</title>
+<h1><a href="/pkg?path={{.Path}}">{{.Path}}</a>.{{.Func}}</h1>
+<pre>{{.Source}}</pre>
+{{range .Unrolled}}<h2>Unrolled</h2>
+<pre>{{.}}</pre>
+{{end}}`))
+
+var runPage = template.Must(template.New("run").Parse(dashStyle + `
+<title>Run {{.ID}}</title>
+<h1>Run {{.ID}}</h1>
+{{if .Comparison}}<pre>{{.Comparison}}</pre>{{else}}<p>No before and after results.</p>{{end}}
+{{if .Decisions}}<h2>Benchmarks unrolled</h2>
+<table>
+<tr><th>package</th><th>benchmark</th><th>file</th><th>factor</th></tr>
+{{range .Decisions}}<tr>{{range .}}<td>{{.}}</td>{{end}}</tr>
+{{end}}</table>{{end}}
+`))
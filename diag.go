@@ -0,0 +1,85 @@
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/scanner"
+	"go/token"
+	"strings"
+)
+
+// A diagnostic is a problem at a position in a file,
+// naming the function it is in, if any, so that it is easy to find.
+type diagnostic struct {
+	pos token.Position
+	fn  string
+	msg string
+}
+
+func (d diagnostic) Error() string {
+	s := d.pos.String() + ": "
+	if d.fn != "" {
+		s += "in " + d.fn + ": "
+	}
+	return s + d.msg
+}
+
+// diagnostics are all the problems found in a file, one per line.
+type diagnostics []diagnostic
+
+func (ds diagnostics) Error() string {
+	var lines []string
+	for _, d := range ds {
+		lines = append(lines, d.Error())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// An unparsableError is the error from parsing a file that does not parse
+// as it is, such as one being edited or using syntax newer than unrollbench.
+// Such files are skipped rather than failing the run; see fail.
+type unparsableError struct {
+	file string // as named by rel
+	err  error
+}
+
+func (e unparsableError) Error() string { return e.err.Error() }
+
+// diagnose returns err, from parsing src, the contents of file,
+// as diagnostics naming the function each error is in.
+// If the errors are in code unrollbench generated, rather than in the
+// file as it was, after is set, and they say so.
+// Other errors are returned as is.
+func diagnose(file string, src []byte, err error, after bool) error {
+	list, ok := err.(scanner.ErrorList)
+	if !ok {
+		return err
+	}
+	// The parser returns as much syntax as it can despite errors.
+	fset := token.NewFileSet()
+	f, _ := parser.ParseFile(fset, file, src, parser.SkipObjectResolution)
+	var ds diagnostics
+	for _, e := range list {
+		d := diagnostic{pos: e.Pos, msg: e.Msg}
+		d.pos.Filename = file
+		if f != nil {
+			d.fn = funcAt(fset, f, e.Pos.Line)
+		}
+		if after {
+			d.msg = "in rewritten code: " + d.msg
+		}
+		ds = append(ds, d)
+	}
+	return ds
+}
+
+// funcAt returns the name of the function in f on line, if any.
+func funcAt(fset *token.FileSet, f *ast.File, line int) string {
+	for _, d := range f.Decls {
+		fn, ok := d.(*ast.FuncDecl)
+		if ok && fset.Position(fn.Pos()).Line <= line && line <= fset.Position(fn.End()).Line {
+			return fn.Name.Name
+		}
+	}
+	return ""
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/josharian/unrollbench/bench"
+)
+
+// Files describing the machine, on Linux.
+const (
+	loadavgFile  = "/proc/loadavg"
+	governorFile = "/sys/devices/system/cpu/cpu0/cpufreq/scaling_governor"
+)
+
+// goVersion returns the version of the go command that runs benchmarks,
+// in -container if set.
+var goVersion = sync.OnceValue(func() string {
+	argv := inContainer([]string{"go", "env", "GOVERSION"})
+	out, err := exec.CommandContext(ctx, argv[0], argv[1:]...).Output()
+	if err != nil {
+		return ""
+	}
+	return string(bytes.TrimSpace(out))
+})
+
+// environment returns the configuration lines describing where benchmarks
+// are about to run, for each of their results: the Go version, GOOS and GOARCH,
+// the CPU frequency governor, whether -perflock is in effect,
+// -gcflags and -ldflags if set, and the load average, as far as they are known.
+// go test adds the CPU model itself.
+func environment() map[string]string {
+	env := map[string]string{
+		"goversion": goVersion(),
+		"goos":      runtime.GOOS,
+		"goarch":    runtime.GOARCH,
+		"perflock":  stabilization(),
+		"gcflags":   gcflags,
+		"ldflags":   ldflags,
+	}
+	if data, err := os.ReadFile(governorFile); err == nil {
+		env["governor"] = string(bytes.TrimSpace(data))
+	}
+	if data, err := os.ReadFile(loadavgFile); err == nil {
+		env["loadavg"] = loadAverage(string(data))
+	}
+	return env
+}
+
+// loadAverage returns the 1, 5, and 15 minute load averages from
+// the contents of /proc/loadavg.
+func loadAverage(data string) string {
+	f := strings.Fields(data)
+	if len(f) < 3 {
+		return ""
+	}
+	return strings.Join(f[:3], " ")
+}
+
+// addEnvironment adds the configuration lines in env to r,
+// where go test did not already print them.
+func addEnvironment(r *bench.Result, env map[string]string) {
+	if r.Config == nil {
+		r.Config = make(map[string]string)
+	}
+	for k, v := range env {
+		if _, ok := r.Config[k]; !ok && v != "" {
+			r.Config[k] = v
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+
+	"github.com/josharian/unrollbench/unroll"
+)
+
+var estimateCmd = newCommand("estimate", "[packages]", "estimate, without running anything, which benchmarks are dominated by loop overhead", runEstimate)
+
+var overheadThreshold float64
+
+func init() {
+	estimateCmd.flags.Float64Var(&overheadThreshold, "threshold", 0.2, "report benchmarks whose estimated loop overhead is at least `fraction` of their time")
+}
+
+// loopCost is the estimated cost of a loop iteration's control,
+// an increment and a compare and branch, in the units of bodyCost.
+const loopCost = 2
+
+func runEstimate(c *command, args []string) {
+	if len(args) < 1 {
+		c.flags.Usage()
+	}
+	for _, cand := range candidates(testFiles(loadPackages(args)...)) {
+		fset := token.NewFileSet()
+		f, err := parseFile(fset, cand.file, cand.src)
+		if err != nil {
+			fail(err)
+			continue
+		}
+		for _, d := range f.Decls {
+			fn, ok := d.(*ast.FuncDecl)
+			if !ok || !unroll.IsBench(fn) {
+				continue
+			}
+			for _, s := range fn.Body.List {
+				is, _, body := unroll.IsBenchForLoop(s)
+				if !is || unroll.Baseline(s) {
+					continue
+				}
+				cost := bodyCost(body)
+				frac := loopCost / (loopCost + cost)
+				if frac >= overheadThreshold {
+					fmt.Printf("%v: %s: loop overhead is roughly %.0f%% (body costs about %g ops)\n", fset.Position(s.Pos()), fn.Name.Name, frac*100, cost)
+				}
+			}
+		}
+	}
+}
+
+// cheapCalls are builtins and conversions that typically compile to a few instructions,
+// and cheapPkgs are packages whose functions are mostly compiler intrinsics.
+var (
+	cheapCalls = map[string]bool{
+		"len": true, "cap": true, "min": true, "max": true, "real": true, "imag": true,
+		"int": true, "int8": true, "int16": true, "int32": true, "int64": true,
+		"uint": true, "uint8": true, "uint16": true, "uint32": true, "uint64": true, "uintptr": true,
+		"float32": true, "float64": true, "byte": true, "rune": true, "bool": true,
+	}
+	cheapPkgs = map[string]bool{"bits": true, "atomic": true, "unsafe": true}
+)
+
+// bodyCost estimates the cost of running n once, in simple operations.
+// It knows nothing about called functions, so it charges each call
+// a flat cost, which underestimates bodies that call anything expensive.
+func bodyCost(n ast.Node) float64 {
+	cost := 0.0
+	ast.Inspect(n, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.CallExpr:
+			switch fun := n.Fun.(type) {
+			case *ast.Ident:
+				if cheapCalls[fun.Name] {
+					cost++
+					return true
+				}
+			case *ast.SelectorExpr:
+				if pkg, ok := fun.X.(*ast.Ident); ok && cheapPkgs[pkg.Name] {
+					cost += 2
+					return true
+				}
+			}
+			cost += 20
+		case *ast.FuncLit:
+			cost += 10
+			return false
+		case *ast.GoStmt:
+			cost += 200
+		case *ast.DeferStmt:
+			cost += 5
+		case *ast.SendStmt:
+			cost += 20
+		case *ast.UnaryExpr:
+			if n.Op == token.ARROW {
+				cost += 20
+			} else {
+				cost++
+			}
+		case *ast.CompositeLit:
+			cost += 2
+		case *ast.BinaryExpr, *ast.IndexExpr, *ast.StarExpr, *ast.IncDecStmt, *ast.AssignStmt:
+			cost++
+		case *ast.ForStmt:
+			// Guess at ten iterations.
+			cost += 10 * (loopCost + bodyCost(n.Body))
+			return false
+		case *ast.RangeStmt:
+			cost += 10 * (loopCost + bodyCost(n.Body))
+			return false
+		}
+		return true
+	})
+	return cost
+}
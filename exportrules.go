@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"regexp"
+
+	"github.com/josharian/unrollbench/unroll"
+)
+
+var exportRulesCmd = newCommand("export-rules", "[-factor n]", "print gofmt -r rules that unroll the simplest benchmark loops, for use with other tools", runExportRules)
+
+func init() {
+	exportRulesCmd.flags.IntVar(&factor, "factor", unroll.DefaultFactor, "unroll loops into `n` copies of their body")
+}
+
+// ruleBodies are the loop bodies that export-rules writes rules for.
+// In gofmt -r patterns, single lowercase letters are wildcards,
+// so x and y match any expression and x alone any expression statement.
+var ruleBodies = []string{"x", "x = y", "x += y"}
+
+// runExportRules prints a gofmt -r rule for each of ruleBodies.
+//
+// gofmt -r rewrites only expressions, so the rules match benchmarks
+// written as func literals, such as those passed to b.Run or testing.Benchmark,
+// whose body is just a benchmark loop of one statement; benchmark functions
+// are declarations, which no rule can match. The rules also cannot check,
+// as unroll does, that the body mentions no labels, bNUnroll, or bNRepeat.
+// gofmt -r keeps the line positions of the code it matched,
+// so the rewritten loops may have stray line breaks.
+// There are no eg templates: eg matches expressions too, and never func literals.
+func runExportRules(c *command, args []string) {
+	if len(args) > 0 {
+		c.flags.Usage()
+	}
+	if factor < 2 {
+		fatal("-factor must be at least 2")
+	}
+	fmt.Printf("# gofmt -r rules that unroll benchmark loops %d times.\n", factor)
+	fmt.Println("# They match func literals, such as those passed to b.Run, whose body is just the loop.")
+	fmt.Println("# gofmt takes one rule at a time; apply each with: gofmt -w -r 'rule' files")
+	fmt.Println("# gofmt -r keeps the matched code's line positions, so check the result's line breaks.")
+	for _, body := range ruleBodies {
+		rule, err := exportRule(body, factor)
+		if err != nil {
+			fatal(err)
+		}
+		fmt.Println(rule)
+	}
+}
+
+// exportRule returns a gofmt -r rule unrolling the loop in a benchmark func literal
+// whose loop body is the single statement body.
+func exportRule(body string, factor int) (string, error) {
+	pattern := "func(b *testing.B) { for i := 0; i < b.N; i++ { " + body + " } }"
+	fset := token.NewFileSet()
+	e, err := parser.ParseExprFrom(fset, "", pattern, 0)
+	if err != nil {
+		return "", err
+	}
+	lit := e.(*ast.FuncLit)
+	n, ok := unroll.Unroll(lit.Body.List[0], factor)
+	if !ok {
+		return "", fmt.Errorf("cannot unroll %s", pattern)
+	}
+	var buf bytes.Buffer
+	if err := printer.Fprint(&buf, token.NewFileSet(), &ast.FuncLit{Type: lit.Type, Body: &ast.BlockStmt{List: []ast.Stmt{n}}}); err != nil {
+		return "", err
+	}
+	replacement := oneLine(buf.String())
+	if _, err := parser.ParseExpr(replacement); err != nil {
+		return "", fmt.Errorf("bad replacement %s: %v", replacement, err)
+	}
+	return pattern + " -> " + replacement, nil
+}
+
+var (
+	openBrace  = regexp.MustCompile(`\{\n\s*`)
+	closeBrace = regexp.MustCompile(`\n\s*\}`)
+	newline    = regexp.MustCompile(`\n\s*`)
+)
+
+// oneLine joins the lines of src, printed Go code, with semicolons,
+// since gofmt -r takes a rule as a single argument.
+func oneLine(src string) string {
+	src = openBrace.ReplaceAllString(src, "{ ")
+	src = closeBrace.ReplaceAllString(src, " }")
+	return newline.ReplaceAllString(src, "; ")
+}
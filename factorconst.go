@@ -0,0 +1,131 @@
+package main
+
+import (
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/token"
+
+	"github.com/josharian/unrollbench/unroll"
+)
+
+var factorConst string
+
+func init() {
+	unrollCmd.flags.StringVar(&factorConst, "factor-const", "", "refer to -factor as the package-level constant `name`, declared in one test file of each package, instead of repeating it")
+}
+
+// factorConstFiles holds the files in which to declare -factor-const,
+// one per package, as chosen by pickFactorConstFiles.
+var factorConstFiles map[string]bool
+
+// pickFactorConstFiles sets factorConstFiles for pkgs, if -factor-const is set.
+// For each package, including external test packages, it picks the file
+// that already declares the constant, if any, and otherwise the first file
+// with benchmark loops.
+func pickFactorConstFiles(pkgs []*build.Package) {
+	if factorConst == "" {
+		return
+	}
+	factorConstFiles = make(map[string]bool)
+	for _, pkg := range pkgs {
+		first := make(map[string]string) // by package name
+		declared := make(map[string]bool)
+		for _, file := range testFiles(pkg) {
+			fset := token.NewFileSet()
+			f, err := parseFile(fset, file, nil)
+			if err != nil {
+				// Reported when rewriting.
+				continue
+			}
+			name := f.Name.Name
+			if declared[name] {
+				continue
+			}
+			if f.Scope.Lookup(factorConst) != nil {
+				declared[name] = true
+				factorConstFiles[file] = true
+				if other, ok := first[name]; ok {
+					delete(factorConstFiles, other)
+				}
+				continue
+			}
+			if _, ok := first[name]; !ok && hasBenchLoops(f) {
+				first[name] = file
+				factorConstFiles[file] = true
+			}
+		}
+	}
+}
+
+// hasBenchLoops reports whether f has benchmark loops, unrolled or not.
+func hasBenchLoops(f *ast.File) bool {
+	for _, body := range unroll.Bodies(f) {
+		for _, s := range body.List {
+			if _, ok := unroll.Rerolled(s); ok {
+				return true
+			}
+			if ok, _, _ := unroll.IsBenchForLoop(s); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// declareFactorConst declares -factor-const in f as k,
+// if f is one of factorConstFiles.
+func declareFactorConst(fset *token.FileSet, f *ast.File, k int) bool {
+	if !factorConstFiles[filePath(fset, f)] {
+		return false
+	}
+	return unroll.DeclareConst(f, factorConst, k)
+}
+
+// recordConst adds to fs the declaration of -factor-const in out,
+// the rewritten file, so that revert removes it,
+// unless src, the file before rewriting, already declared it.
+func (fs *fileState) recordConst(src, out []byte) error {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "", out, parser.SkipObjectResolution)
+	if err != nil {
+		return err
+	}
+	decl := constDecl(f, factorConst)
+	if decl == nil {
+		return nil
+	}
+	tf := fset.File(f.Pos())
+	start, end := tf.Offset(decl.Pos()), tf.Offset(decl.End())
+	// Take the blank line after it too.
+	for end < len(out) && out[end] == '\n' {
+		end++
+	}
+	r := rewriteRecord{Func: "const " + factorConst, Start: start, End: end, Generated: string(out[start:end])}
+	if prev := fs.rewrote(r.Func, ""); prev != nil {
+		*prev = r
+		return nil
+	}
+	if old, err := parser.ParseFile(token.NewFileSet(), "", src, parser.SkipObjectResolution); err != nil || constDecl(old, factorConst) != nil {
+		// The user's own.
+		return err
+	}
+	fs.Rewrites = append(fs.Rewrites, r)
+	return nil
+}
+
+// constDecl returns the top level declaration of the constant name in f,
+// if it is the only constant declared there.
+func constDecl(f *ast.File, name string) *ast.GenDecl {
+	for _, d := range f.Decls {
+		g, ok := d.(*ast.GenDecl)
+		if !ok || g.Tok != token.CONST || len(g.Specs) != 1 {
+			continue
+		}
+		vs := g.Specs[0].(*ast.ValueSpec)
+		if len(vs.Names) == 1 && vs.Names[0].Name == name {
+			return g
+		}
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/token"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A factorKey identifies a benchmark function, for per-benchmark factors.
+type factorKey struct {
+	pkg  string // import path
+	name string // function name
+}
+
+// factorTable holds per-benchmark unroll factors.
+//
+// Its file format has one benchmark per line:
+//
+//	import/path BenchmarkName factor
+//
+// A factor of 0 leaves the benchmark's loops alone, as does 1.
+// Blank lines and lines starting with # are ignored.
+type factorTable map[factorKey]int
+
+func readFactors(file string) (factorTable, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	t := make(factorTable)
+	s := bufio.NewScanner(f)
+	for line := 1; s.Scan(); line++ {
+		text := strings.TrimSpace(s.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("%s:%d: want import path, benchmark, and factor", file, line)
+		}
+		n, err := strconv.Atoi(fields[2])
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("%s:%d: bad factor %q", file, line, fields[2])
+		}
+		t[factorKey{fields[0], fields[1]}] = n
+	}
+	return t, s.Err()
+}
+
+// save writes t to file.
+func (t factorTable) save(file string) error {
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	if err := t.write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// keys returns the benchmarks in t, sorted.
+func (t factorTable) keys() []factorKey {
+	keys := make([]factorKey, 0, len(t))
+	for k := range t {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].pkg != keys[j].pkg {
+			return keys[i].pkg < keys[j].pkg
+		}
+		return keys[i].name < keys[j].name
+	})
+	return keys
+}
+
+func (t factorTable) write(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for _, k := range t.keys() {
+		fmt.Fprintf(bw, "%s %s %d\n", k.pkg, k.name, t[k])
+	}
+	return bw.Flush()
+}
+
+// benchFactors holds the factors read by unroll -factors,
+// keyed by package directory instead of import path.
+var benchFactors factorTable
+
+// byDir returns the entries of t for pkgs, keyed by package directory.
+func (t factorTable) byDir(pkgs []*build.Package) factorTable {
+	d := make(factorTable)
+	for _, pkg := range pkgs {
+		path := importPath(pkg)
+		for k, n := range t {
+			if k.pkg == path {
+				d[factorKey{pkg.Dir, k.name}] = n
+			}
+		}
+	}
+	return d
+}
+
+// decide returns an unroll.Config.Decide func that looks up
+// the factors for the benchmarks in f, which t must key by directory.
+// Benchmarks that t does not mention get the default factor.
+func (t factorTable) decide(fset *token.FileSet, f *ast.File) func(*ast.FuncDecl, ast.Stmt, int) int {
+	dir := filepath.Dir(filePath(fset, f))
+	return func(fn *ast.FuncDecl, s ast.Stmt, factor int) int {
+		if n, ok := t[factorKey{dir, fn.Name.Name}]; ok {
+			if n < 2 {
+				// A single copy only adds churn.
+				return 0
+			}
+			return n
+		}
+		return factor
+	}
+}
+
+// benchFunc returns the name of the function that produced
+// benchmark result name, such as BenchmarkFoo for BenchmarkFoo/bar-8.
+func benchFunc(name string) string {
+	if i := strings.IndexByte(name, '/'); i >= 0 {
+		name = name[:i]
+	}
+	if i := strings.LastIndexByte(name, '-'); i >= 0 {
+		if _, err := strconv.Atoi(name[i+1:]); err == nil {
+			name = name[:i]
+		}
+	}
+	return name
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"go/ast"
+	"go/build"
+	"go/token"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testModule returns the root of a new module example.com/m
+// with a package p holding a benchmark.
+func testModule(t *testing.T) string {
+	t.Helper()
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "go.mod"), []byte("module example.com/m\n\ngo 1.21\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(root, "p"), 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "p", "p_test.go"), []byte(benchSrc), 0666); err != nil {
+		t.Fatal(err)
+	}
+	return root
+}
+
+// Entries are found however the package was named.
+func TestFactorTableByDir(t *testing.T) {
+	root := testModule(t)
+	table := factorTable{
+		{"example.com/m/p", "BenchmarkA"}: 4,
+		{"example.com/m/q", "BenchmarkA"}: 8,
+	}
+	ctxt := build.Default
+	ctxt.Dir = root
+	for _, path := range []string{"./p", "example.com/m/p"} {
+		pkg, err := ctxt.Import(path, root, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		d := table.byDir([]*build.Package{pkg})
+		if len(d) != 1 || d[factorKey{pkg.Dir, "BenchmarkA"}] != 4 {
+			t.Errorf("%s: byDir = %v, want BenchmarkA in %s: 4", path, d, pkg.Dir)
+		}
+	}
+}
+
+func TestFactorTableDecide(t *testing.T) {
+	dir := filepath.Join(testModule(t), "p")
+	fset := token.NewFileSet()
+	f, err := parseFile(fset, filepath.Join(dir, "p_test.go"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fn := f.Decls[1].(*ast.FuncDecl)
+	for _, tt := range []struct {
+		name  string
+		table factorTable
+		want  int
+	}{
+		{"listed", factorTable{{dir, "BenchmarkA"}: 4}, 4},
+		{"zero", factorTable{{dir, "BenchmarkA"}: 0}, 0},
+		{"one", factorTable{{dir, "BenchmarkA"}: 1}, 0},
+		{"unlisted", factorTable{{dir, "BenchmarkB"}: 4}, 16},
+		{"other package", factorTable{{filepath.Dir(dir), "BenchmarkA"}: 4}, 16},
+	} {
+		if got := tt.table.decide(fset, f)(fn, fn.Body.List[1], 16); got != tt.want {
+			t.Errorf("%s: decide = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/build"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Flags for git integration.
+var (
+	requireClean bool
+	commitBranch string
+)
+
+func init() {
+	for _, c := range []*command{unrollCmd, rerollCmd, normalizeCmd, modernizeCmd, lintCmd} {
+		c.flags.BoolVar(&requireClean, "clean", false, "refuse to run unless the packages' git worktrees are clean")
+		c.flags.StringVar(&commitBranch, "commit", "", "create git branch `name` and commit the rewritten files to it; implies -clean")
+	}
+}
+
+// git runs git in dir and returns its output.
+func git(dir string, args ...string) ([]byte, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		if ee, ok := err.(*exec.ExitError); ok {
+			err = fmt.Errorf("git %s: %v\n%s", strings.Join(args, " "), err, ee.Stderr)
+		}
+		return nil, err
+	}
+	return out, nil
+}
+
+// gitRoot returns the root of the git worktree containing dir.
+func gitRoot(dir string) (string, error) {
+	out, err := git(dir, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return "", err
+	}
+	return string(bytes.TrimSpace(out)), nil
+}
+
+// prepareGit checks that the worktrees containing pkgs are clean,
+// and creates the commit branch, if requested.
+func prepareGit(pkgs []*build.Package) {
+	if commitBranch != "" {
+		requireClean = true
+	}
+	if !requireClean {
+		return
+	}
+	if commitBranch != "" && (outDir != "" || overlayFile != "") {
+		fatal("-commit cannot be used with -o or -overlay")
+	}
+	roots := make(map[string]bool)
+	for _, pkg := range pkgs {
+		root, err := gitRoot(pkg.Dir)
+		if err != nil {
+			fatal(err)
+		}
+		if roots[root] {
+			continue
+		}
+		roots[root] = true
+		out, err := git(root, "status", "--porcelain")
+		if err != nil {
+			fatal(err)
+		}
+		if len(out) > 0 {
+			fatal(fmt.Sprintf("git worktree %s is not clean:\n%s", root, out))
+		}
+		if commitBranch != "" {
+			if _, err := git(root, "checkout", "-b", commitBranch); err != nil {
+				fatal(err)
+			}
+		}
+	}
+}
+
+// commitGit commits the files in changes, if requested,
+// with a message starting with summary and listing the benchmarks.
+func commitGit(changes []change, summary string) {
+	if commitBranch == "" {
+		return
+	}
+	files := make(map[string][]string)   // by worktree root
+	benches := make(map[string][]string) // by worktree root
+	for _, ch := range changes {
+		root, err := gitRoot(ch.pkg.Dir)
+		if err != nil {
+			fatal(err)
+		}
+		files[root] = append(files[root], ch.files...)
+		// And the audit log, so that the worktree is left clean.
+		if log := filepath.Join(repoRoot(ch.pkg.Dir), auditFile); exists(log) && !slices.Contains(files[root], log) {
+			files[root] = append(files[root], log)
+		}
+		for _, fn := range ch.funcs {
+			benches[root] = append(benches[root], ch.pkg.ImportPath+"."+fn)
+		}
+	}
+	roots := make([]string, 0, len(files))
+	for root := range files {
+		roots = append(roots, root)
+	}
+	sort.Strings(roots)
+	for _, root := range roots {
+		fs := files[root]
+		// Include the revert sidecars, so that the commit is self-contained.
+		for _, f := range fs {
+			if state := filepath.Join(filepath.Dir(f), stateFile); exists(state) {
+				fs = append(fs, state)
+			}
+		}
+		if _, err := git(root, append([]string{"add", "--"}, fs...)...); err != nil {
+			fatal(err)
+		}
+		b := benches[root]
+		sort.Strings(b)
+		msg := "all: " + summary + "\n\nGenerated by unrollbench.\n\nBenchmarks:\n\t" + strings.Join(b, "\n\t") + "\n"
+		if _, err := git(root, "commit", "-q", "-m", msg); err != nil {
+			fatal(err)
+		}
+		fmt.Printf("Committed %d benchmarks to branch %s in %s\n", len(b), commitBranch, root)
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/token"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// A helperUse is a function other than a Benchmark function
+// whose loops were rewritten, and the benchmarks that call it.
+type helperUse struct {
+	pkg, name string
+	file      string
+	benches   []string // qualified by package when it differs
+}
+
+// helperUses are the helpers rewritten by unroll, for -md.
+var helperUses []helperUse
+
+// reportHelpers prints which of the functions rewritten in changes
+// are helpers, such as benchmarkBitLenN(b, nbits) or shared suites in
+// non-test files, and which benchmarks in pkgs call them, directly or
+// through other functions, so that a reviewer can see why a function
+// that is not a benchmark changed. Calls are matched by name,
+// so calls through function values and methods are not found.
+func reportHelpers(pkgs []*build.Package, changes []change) {
+	helperUses = findHelpers(pkgs, changes)
+	if len(helperUses) == 0 {
+		return
+	}
+	fmt.Println("\nHelpers rewritten on behalf of benchmarks:")
+	for _, h := range helperUses {
+		callers := strings.Join(h.benches, ", ")
+		if callers == "" {
+			callers = "no benchmark calls found"
+		}
+		fmt.Printf("\t%s.%s (%s): %s\n", h.pkg, h.name, rel(h.file), callers)
+	}
+}
+
+// findHelpers returns the helpers rewritten in changes.
+func findHelpers(pkgs []*build.Package, changes []change) []helperUse {
+	var uses []helperUse
+	var g *callGraph
+	for _, ch := range changes {
+		seen := make(map[string]bool)
+		for _, name := range ch.funcs {
+			// Literals passed to testing.Benchmark are named like F.func1.
+			name, _, _ = strings.Cut(name, ".")
+			if seen[name] || isBenchmarkName(name) && strings.HasSuffix(ch.file, "_test.go") {
+				continue
+			}
+			seen[name] = true
+			if g == nil {
+				g = newCallGraph(pkgs)
+			}
+			uses = append(uses, helperUse{
+				pkg:     ch.pkg.ImportPath,
+				name:    name,
+				file:    ch.file,
+				benches: g.benchmarksCalling(g.pkgOf[ch.file]+"."+name, ch.pkg.ImportPath),
+			})
+		}
+	}
+	return uses
+}
+
+// isBenchmarkName reports whether go test runs a function named name
+// as a benchmark.
+func isBenchmarkName(name string) bool {
+	rest, ok := strings.CutPrefix(name, "Benchmark")
+	if !ok {
+		return false
+	}
+	r, _ := utf8.DecodeRuneInString(rest)
+	return rest == "" || !unicode.IsLower(r)
+}
+
+// A callGraph records which top level functions call which, by name.
+// Functions are keyed by package path and name; functions in
+// external test packages have "_test" appended to the path.
+type callGraph struct {
+	callers map[string][]string // by callee
+	benches map[string]bool     // Benchmark functions
+	pkgOf   map[string]string   // package key by file
+}
+
+// newCallGraph returns the call graph of the Go files in pkgs.
+func newCallGraph(pkgs []*build.Package) *callGraph {
+	g := &callGraph{callers: make(map[string][]string), benches: make(map[string]bool), pkgOf: make(map[string]string)}
+	for _, pkg := range pkgs {
+		files := testFiles(pkg)
+		for _, name := range pkg.GoFiles {
+			files = append(files, filepath.Join(pkg.Dir, name))
+		}
+		seen := make(map[string]bool)
+		for _, file := range files {
+			if seen[file] {
+				continue
+			}
+			seen[file] = true
+			f, err := parseFile(token.NewFileSet(), file, nil)
+			if err != nil {
+				continue // reported by the rewrite
+			}
+			key := pkg.ImportPath
+			if strings.HasSuffix(f.Name.Name, "_test") {
+				key += "_test"
+			}
+			g.pkgOf[file] = key
+			g.addFile(key, f, strings.HasSuffix(file, "_test.go"))
+		}
+	}
+	return g
+}
+
+// addFile adds the calls in f, in the package keyed pkg, to g.
+func (g *callGraph) addFile(pkg string, f *ast.File, test bool) {
+	imports := make(map[string]string) // path by name
+	for _, imp := range f.Imports {
+		p, err := strconv.Unquote(imp.Path.Value)
+		if err != nil {
+			continue
+		}
+		name := path.Base(p)
+		if imp.Name != nil {
+			name = imp.Name.Name
+		}
+		imports[name] = p
+	}
+	for _, d := range f.Decls {
+		fn, ok := d.(*ast.FuncDecl)
+		if !ok || fn.Recv != nil || fn.Body == nil {
+			continue
+		}
+		caller := pkg + "." + fn.Name.Name
+		if test && isBenchmarkName(fn.Name.Name) {
+			g.benches[caller] = true
+		}
+		ast.Inspect(fn.Body, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if !ok {
+				return true
+			}
+			switch fun := call.Fun.(type) {
+			case *ast.Ident:
+				g.callers[pkg+"."+fun.Name] = append(g.callers[pkg+"."+fun.Name], caller)
+			case *ast.SelectorExpr:
+				if x, ok := fun.X.(*ast.Ident); ok && imports[x.Name] != "" {
+					callee := imports[x.Name] + "." + fun.Sel.Name
+					g.callers[callee] = append(g.callers[callee], caller)
+				}
+			}
+			return true
+		})
+	}
+}
+
+// benchmarksCalling returns the Benchmark functions that call fn,
+// directly or indirectly, sorted, and named without their package
+// if it is pkg.
+func (g *callGraph) benchmarksCalling(fn, pkg string) []string {
+	var benches []string
+	seen := map[string]bool{fn: true}
+	queue := []string{fn}
+	for len(queue) > 0 {
+		callee := queue[0]
+		queue = queue[1:]
+		for _, caller := range g.callers[callee] {
+			if seen[caller] {
+				continue
+			}
+			seen[caller] = true
+			if g.benches[caller] {
+				benches = append(benches, caller)
+			}
+			queue = append(queue, caller)
+		}
+	}
+	for i, b := range benches {
+		dot := strings.LastIndex(b, ".")
+		if p := b[:dot]; p == pkg || p == pkg+"_test" {
+			benches[i] = b[dot+1:]
+		}
+	}
+	sort.Strings(benches)
+	return benches
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/josharian/unrollbench/bench"
+)
+
+var historyFile string
+
+func init() {
+	for _, c := range []*command{runCmd, rankCmd, factorsCmd, tuneCmd, bisectCmd} {
+		c.flags.StringVar(&historyFile, "history", "", "record the run, its unroll decisions, and its results in the SQLite database `file`, using the sqlite3 command")
+	}
+}
+
+// historySchema creates the -history tables.
+// A run is keyed by commit, machine, and options;
+// its decisions and results refer to it.
+const historySchema = `
+CREATE TABLE IF NOT EXISTS runs (
+	id INTEGER PRIMARY KEY,
+	time TEXT NOT NULL,
+	command TEXT NOT NULL,
+	options TEXT NOT NULL,
+	commit_hash TEXT NOT NULL,
+	machine TEXT NOT NULL,
+	goos TEXT NOT NULL,
+	goarch TEXT NOT NULL,
+	cpu TEXT NOT NULL,
+	go_version TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS decisions (
+	run INTEGER NOT NULL REFERENCES runs(id),
+	pkg TEXT NOT NULL,
+	func TEXT NOT NULL,
+	file TEXT NOT NULL,
+	factor TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS results (
+	run INTEGER NOT NULL REFERENCES runs(id),
+	label TEXT NOT NULL,
+	pkg TEXT NOT NULL,
+	name TEXT NOT NULL,
+	iters INTEGER NOT NULL,
+	unit TEXT NOT NULL,
+	value REAL NOT NULL
+);
+CREATE INDEX IF NOT EXISTS runs_key ON runs(commit_hash, machine, options);
+`
+
+// historyRun is the id of this run in -history, if set.
+var historyRun int64
+
+// openHistory records the start of this run in -history, if set.
+// The commit is that checked out in the current directory,
+// marked dirty if the worktree has changes.
+func openHistory(c *command) {
+	if historyFile == "" {
+		return
+	}
+	if _, err := exec.LookPath("sqlite3"); err != nil {
+		fatal(fmt.Sprintf("-history: %v", err))
+	}
+	commit := ""
+	if out, err := git(".", "rev-parse", "HEAD"); err == nil {
+		commit = string(bytes.TrimSpace(out))
+		if out, err := git(".", "status", "--porcelain"); err == nil && len(out) > 0 {
+			commit += "-dirty"
+		}
+	}
+	host, _ := os.Hostname()
+	version, err := exec.CommandContext(ctx, "go", "env", "GOVERSION").Output()
+	if err != nil {
+		fatal(fmt.Sprintf("go env GOVERSION: %v", err))
+	}
+	out := sqlite(historySchema + fmt.Sprintf(
+		"INSERT INTO runs (time, command, options, commit_hash, machine, goos, goarch, cpu, go_version) VALUES (%s, %s, %s, %s, %s, %s, %s, '', %s);\nSELECT last_insert_rowid();\n",
+		sqlQuote(time.Now().UTC().Format(time.RFC3339)), sqlQuote(c.name), sqlQuote(strings.Join(os.Args[2:], " ")),
+		sqlQuote(commit), sqlQuote(host), sqlQuote(runtime.GOOS), sqlQuote(runtime.GOARCH),
+		sqlQuote(string(bytes.TrimSpace(version)))))
+	if historyRun, err = strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64); err != nil {
+		fatal(fmt.Sprintf("-history: bad run id %q", out))
+	}
+}
+
+// historyResults records results, labeled with run, in -history, if set.
+func historyResults(results []*bench.Result, run string) {
+	if historyRun == 0 || len(results) == 0 {
+		return
+	}
+	var b strings.Builder
+	for _, r := range results {
+		if cpu := r.Config["cpu"]; cpu != "" {
+			fmt.Fprintf(&b, "UPDATE runs SET cpu = %s WHERE id = %d AND cpu = '';\n", sqlQuote(cpu), historyRun)
+			break
+		}
+	}
+	for _, r := range results {
+		for unit, v := range r.Values {
+			fmt.Fprintf(&b, "INSERT INTO results VALUES (%d, %s, %s, %s, %d, %s, %v);\n",
+				historyRun, sqlQuote(run), sqlQuote(r.Pkg), sqlQuote(r.Name), r.N, sqlQuote(unit), v)
+		}
+	}
+	sqlite(b.String())
+}
+
+// historyChanges records the benchmarks rewritten by changes,
+// and the factor they were unrolled by, in -history, if set.
+func historyChanges(changes []change) {
+	if historyRun == 0 {
+		return
+	}
+	f := strconv.Itoa(factor)
+	if autoFactor {
+		f = "auto"
+	}
+	var b strings.Builder
+	for _, ch := range changes {
+		for _, fn := range ch.funcs {
+			fmt.Fprintf(&b, "INSERT INTO decisions VALUES (%d, %s, %s, %s, %s);\n",
+				historyRun, sqlQuote(ch.pkg.ImportPath), sqlQuote(fn), sqlQuote(rel(ch.file)), sqlQuote(f))
+		}
+	}
+	sqlite(b.String())
+}
+
+// historyFactors records the factors picked in t in -history, if set.
+func historyFactors(t factorTable) {
+	if historyRun == 0 {
+		return
+	}
+	var b strings.Builder
+	for _, k := range t.keys() {
+		fmt.Fprintf(&b, "INSERT INTO decisions VALUES (%d, %s, %s, '', %s);\n",
+			historyRun, sqlQuote(k.pkg), sqlQuote(k.name), sqlQuote(strconv.Itoa(t[k])))
+	}
+	sqlite(b.String())
+}
+
+// sqlite runs script in a transaction on -history and returns its output.
+func sqlite(script string) []byte {
+	cmd := exec.Command("sqlite3", "-batch", historyFile)
+	cmd.Stdin = strings.NewReader("BEGIN;\n" + script + "COMMIT;\n")
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		fatal(fmt.Sprintf("-history: sqlite3: %v", err))
+	}
+	return out
+}
+
+// sqliteQuery runs query on -history and returns its rows.
+func sqliteQuery(query string) [][]string {
+	cmd := exec.Command("sqlite3", "-batch", "-separator", "\t", historyFile, query)
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		fatal(fmt.Sprintf("-history: sqlite3: %v", err))
+	}
+	var rows [][]string
+	for _, line := range strings.Split(strings.TrimSuffix(string(out), "\n"), "\n") {
+		if line != "" {
+			rows = append(rows, strings.Split(line, "\t"))
+		}
+	}
+	return rows
+}
+
+// sqlQuote returns s as an SQL string literal.
+func sqlQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
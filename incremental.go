@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sync"
+)
+
+var incremental bool
+
+func init() {
+	for _, c := range []*command{unrollCmd, rerollCmd} {
+		c.flags.BoolVar(&incremental, "incremental", false, "skip files that have not changed since they were last processed with the same options")
+	}
+}
+
+// An incrementalDB records the files processed in place,
+// for -incremental. It lives in the user cache directory.
+// It is safe to use concurrently.
+type incrementalDB struct {
+	sync.Mutex
+	m map[string]processed // keyed by absolute file path
+}
+
+// processed records how a file was last processed.
+type processed struct {
+	Options string // from rewriteOptions
+	Hash    string // of the file after processing
+}
+
+func incrementalFile() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "unrollbench", "incremental.json"), nil
+}
+
+func loadIncremental() (*incrementalDB, error) {
+	db := &incrementalDB{m: make(map[string]processed)}
+	file, err := incrementalFile()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return db, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &db.m); err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	return db, nil
+}
+
+func (db *incrementalDB) save() error {
+	file, err := incrementalFile()
+	if err != nil {
+		return err
+	}
+	db.Lock()
+	data, err := json.MarshalIndent(db.m, "", "\t")
+	db.Unlock()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0777); err != nil {
+		return err
+	}
+	return os.WriteFile(file, append(data, '\n'), 0666)
+}
+
+// unchanged reports whether file, containing src, was last processed
+// with options opts and has not changed since.
+func (db *incrementalDB) unchanged(file, opts string, src []byte) bool {
+	db.Lock()
+	defer db.Unlock()
+	p, ok := db.m[absPath(file)]
+	return ok && p.Options == opts && p.Hash == hash(src)
+}
+
+// note records that file was processed with options opts,
+// leaving it containing data.
+func (db *incrementalDB) note(file, opts string, data []byte) {
+	db.Lock()
+	defer db.Unlock()
+	db.m[absPath(file)] = processed{Options: opts, Hash: hash(data)}
+}
+
+func absPath(file string) string {
+	if abs, err := filepath.Abs(file); err == nil {
+		return abs
+	}
+	return file
+}
+
+// rewriteOptions describes fn and the options that affect what it does.
+func rewriteOptions(fn func(*token.FileSet, *ast.File) bool) string {
+	name := runtime.FuncForPC(reflect.ValueOf(fn).Pointer()).Name()
+	return fmt.Sprint(name, " ", factor, autoFactor, maxCopies, interleave, factorConst, factorConstFiles, keepOriginal, sequential, lineDirs, copyComments, duplicate, variantList, benchLiterals, nonTestFiles, maxNsPerOp, nsPerOp, benchFactors, profiledLoops, lintSeverity, styleFlag)
+}
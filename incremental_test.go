@@ -0,0 +1,76 @@
+package main
+
+import (
+	"go/build"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const benchSrc = `package p
+
+import "testing"
+
+func BenchmarkA(b *testing.B) {
+	x := 0
+	for i := 0; i < b.N; i++ {
+		x++
+	}
+	_ = x
+}
+`
+
+// A second -incremental run skips the files the first one processed.
+func TestIncrementalRunTwice(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	dir := t.TempDir()
+	file := filepath.Join(dir, "a_test.go")
+	if err := os.WriteFile(file, []byte(benchSrc), 0666); err != nil {
+		t.Fatal(err)
+	}
+	pkg, err := build.ImportDir(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(old bool) { incremental = old }(incremental)
+	incremental = true
+	if changes := rewrite([]*build.Package{pkg}, false, unrollFile); len(changes) != 1 {
+		t.Fatalf("first run made %d changes, want 1", len(changes))
+	}
+	inc, err := loadIncremental()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := rewriteFile(file, unrollFile, true, inc, rewriteOptions(unrollFile)); !r.skip {
+		t.Errorf("second run processes %s again", file)
+	}
+}
+
+func TestIncrementalDB(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	db, err := loadIncremental()
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.note("a_test.go", "opts", []byte("after"))
+	if err := db.save(); err != nil {
+		t.Fatal(err)
+	}
+	if db, err = loadIncremental(); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		file, opts, src string
+		want            bool
+	}{
+		{"a_test.go", "opts", "after", true},
+		{"./a_test.go", "opts", "after", true},
+		{"a_test.go", "other opts", "after", false},
+		{"a_test.go", "opts", "edited", false},
+		{"b_test.go", "opts", "after", false},
+	} {
+		if got := db.unchanged(tt.file, tt.opts, []byte(tt.src)); got != tt.want {
+			t.Errorf("unchanged(%q, %q, %q) = %v, want %v", tt.file, tt.opts, tt.src, got, tt.want)
+		}
+	}
+}
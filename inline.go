@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/token"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var inlineCmd = newCommand("inline", "[packages]", "report how unrolling changes inlining in benchmarks and the functions they call", runInline)
+
+// runInline compiles the tests of each package with -gcflags=-m=2
+// before and after unrolling, and reports the inlining decisions that
+// changed: calls that are no longer, or newly, inlined into the functions
+// in the test files, and functions in them that are no longer, or newly,
+// inlinable. Unrolling makes functions much larger, and the compiler
+// inlines less into large functions, so that a benchmark can end up
+// measuring a call it did not measure before.
+func runInline(c *command, args []string) {
+	if len(args) < 1 {
+		c.flags.Usage()
+	}
+	if factor < 2 {
+		fatal("-factor must be at least 2")
+	}
+	pkgs := loadPackages(args)
+
+	tmp, err := os.CreateTemp("", "unrollbench-*.json")
+	if err != nil {
+		fatal(err)
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+	overlayFile = tmp.Name()
+	rewrite(pkgs, false, unrollFile)
+	var o overlayJSON
+	if data, err := os.ReadFile(overlayFile); err == nil && len(data) > 0 {
+		if err := json.Unmarshal(data, &o); err != nil {
+			fatal(fmt.Sprintf("%s: %v", overlayFile, err))
+		}
+	}
+
+	changed := false
+	for _, pkg := range pkgs {
+		files := testFiles(pkg)
+		if len(files) == 0 {
+			continue
+		}
+		old := compileInlining(pkg, files, "", nil)
+		new := compileInlining(pkg, files, overlayFile, o.Replace)
+		if diffs := diffInlining(old, new); len(diffs) > 0 {
+			fmt.Printf("%s:\n", pkg.ImportPath)
+			for _, d := range diffs {
+				fmt.Printf("\t%s\n", d)
+			}
+			changed = true
+		}
+	}
+	if !changed {
+		fmt.Printf("Unrolling %s changes no inlining decisions.\n", factorDesc())
+	}
+}
+
+// inlining holds the compiler's inlining decisions for a package's tests.
+type inlining struct {
+	calls map[string]map[string]int // by enclosing function and callee: number of calls inlined
+	defs  map[string]inlineDef      // by function, as the compiler names it
+}
+
+// An inlineDef is the compiler's decision whether a function is inlinable.
+type inlineDef struct {
+	ok  bool
+	why string // cost if ok, or else the reason not
+}
+
+// Lines of -m=2 output, with the position's file and line.
+var (
+	inlineCallLine   = regexp.MustCompile(`^(.+):(\d+):\d+: inlining call to (\S+)`)
+	canInlineLine    = regexp.MustCompile(`^(.+):(\d+):\d+: can inline (\S+) with (cost \d+)`)
+	cannotInlineLine = regexp.MustCompile(`^(.+):(\d+):\d+: cannot inline (\S+): (.*)$`)
+)
+
+// compileInlining compiles the tests of pkg, with overlay if not empty,
+// and returns the inlining decisions in files, whose contents
+// are replaced by those of the files in replace, if any.
+func compileInlining(pkg *build.Package, files []string, overlay string, replace map[string]string) *inlining {
+	args := append([]string{"test", "-c", "-o", os.DevNull}, buildFlagsWith(pkg.ImportPath, "-m=2")...)
+	if overlay != "" {
+		args = append(args, "-overlay="+overlay)
+	}
+	args = append(args, pkg.ImportPath)
+	out, err := exec.CommandContext(ctx, "go", args...).CombinedOutput()
+	if err != nil {
+		os.Stdout.Write(out)
+		fatal(fmt.Sprintf("go %v: %v", args, err))
+	}
+
+	fset := token.NewFileSet()
+	parsed := make(map[string]*ast.File)
+	for _, file := range files {
+		src, err := os.ReadFile(cmp.Or(replace[file], file))
+		if err != nil {
+			fatal(err)
+		}
+		if parsed[file], err = parseFile(fset, file, src); err != nil {
+			fatal(err)
+		}
+	}
+	in := &inlining{calls: make(map[string]map[string]int), defs: make(map[string]inlineDef)}
+	s := bufio.NewScanner(bytes.NewReader(out))
+	for s.Scan() {
+		line := s.Text()
+		m := inlineCallLine.FindStringSubmatch(line)
+		if m == nil {
+			m = canInlineLine.FindStringSubmatch(line)
+		}
+		if m == nil {
+			m = cannotInlineLine.FindStringSubmatch(line)
+		}
+		if m == nil {
+			continue
+		}
+		file, err := filepath.Abs(m[1])
+		if err != nil || parsed[file] == nil {
+			continue // not in the test files
+		}
+		n, _ := strconv.Atoi(m[2])
+		fn := funcAt(fset, parsed[file], n)
+		switch {
+		case strings.Contains(line, ": inlining call to "):
+			if fn == "" {
+				continue
+			}
+			if in.calls[fn] == nil {
+				in.calls[fn] = make(map[string]int)
+			}
+			in.calls[fn][m[3]]++
+		case strings.Contains(line, ": can inline "):
+			in.defs[m[3]] = inlineDef{true, m[4]}
+		default:
+			in.defs[m[3]] = inlineDef{false, m[4]}
+		}
+	}
+	return in
+}
+
+// diffInlining describes the inlining decisions that differ between
+// old and new. Whether benchmarks themselves are inlinable is left out,
+// since the testing package calls them through function values.
+func diffInlining(old, new *inlining) []string {
+	var diffs []string
+	fns := make(map[string]bool)
+	for fn := range old.calls {
+		fns[fn] = true
+	}
+	for fn := range new.calls {
+		fns[fn] = true
+	}
+	for fn := range fns {
+		callees := make(map[string]bool)
+		for callee := range old.calls[fn] {
+			callees[callee] = true
+		}
+		for callee := range new.calls[fn] {
+			callees[callee] = true
+		}
+		for callee := range callees {
+			o, n := old.calls[fn][callee], new.calls[fn][callee]
+			switch {
+			case o > 0 && n == 0:
+				diffs = append(diffs, fmt.Sprintf("%s: no longer inlines calls to %s (%d inlined before)", fn, callee, o))
+			case o == 0 && n > 0:
+				diffs = append(diffs, fmt.Sprintf("%s: now inlines calls to %s (%d inlined after)", fn, callee, n))
+			}
+		}
+	}
+	for name, o := range old.defs {
+		top, _, _ := strings.Cut(name, ".")
+		n, ok := new.defs[name]
+		if !ok || o.ok == n.ok || isBenchmarkName(top) {
+			continue
+		}
+		if o.ok {
+			diffs = append(diffs, fmt.Sprintf("%s: no longer inlinable: %s", name, n.why))
+		} else {
+			diffs = append(diffs, fmt.Sprintf("%s: now inlinable, with %s", name, n.why))
+		}
+	}
+	sort.Strings(diffs)
+	return diffs
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/josharian/unrollbench/unroll"
+)
+
+// prompt asks the user about each loop, for unroll -i.
+var prompt = &prompter{in: bufio.NewReader(os.Stdin)}
+
+type prompter struct {
+	in   *bufio.Reader
+	all  bool // accept all remaining loops
+	quit bool // skip all remaining loops
+}
+
+// decide returns a Config.Decide func for loops in f.
+func (p *prompter) decide(fset *token.FileSet, f *ast.File) func(*ast.FuncDecl, ast.Stmt, int) int {
+	return func(fn *ast.FuncDecl, s ast.Stmt, factor int) int {
+		for {
+			switch {
+			case p.quit:
+				return 0
+			case p.all:
+				return factor
+			}
+			fmt.Printf("\n%v: %s\n", fset.Position(s.Pos()), fn.Name.Name)
+			if err := showRewrite(fset, f, s, factor); err != nil {
+				fatal(err)
+			}
+			fmt.Printf("Unroll %d times? [y]es, [n]o, [a]ll, [q]uit, or a different factor: ", factor)
+			line, err := p.in.ReadString('\n')
+			if err != nil && line == "" {
+				// Out of input; leave the rest alone.
+				p.quit = true
+				continue
+			}
+			switch answer := strings.TrimSpace(line); answer {
+			case "y", "yes":
+				return factor
+			case "n", "no":
+				return 0
+			case "a", "all":
+				p.all = true
+			case "q", "quit":
+				p.quit = true
+			default:
+				n, err := strconv.Atoi(answer)
+				if err != nil {
+					fmt.Printf("Unrecognized answer %q.\n", answer)
+					continue
+				}
+				if n < 2 {
+					fmt.Printf("Factor %d would not unroll anything; answer n to leave the loop alone.\n", n)
+					continue
+				}
+				factor = n
+			}
+		}
+	}
+}
+
+// showRewrite prints the rewrite of s with factor as a diff.
+func showRewrite(fset *token.FileSet, f *ast.File, s ast.Stmt, factor int) error {
+	n, _ := unroll.Unroll(s, factor)
+	old, err := unroll.Format(fset, f, s, s)
+	if err != nil {
+		return err
+	}
+	new, err := unroll.Format(fset, f, s, n)
+	if err != nil {
+		return err
+	}
+	// Format leaves off the first line's indentation.
+	indent := strings.Repeat("\t", fset.Position(s.Pos()).Column-1)
+	for _, line := range strings.Split(indent+string(old), "\n") {
+		printDiffLine("-" + line)
+	}
+	for _, line := range strings.Split(indent+string(new), "\n") {
+		printDiffLine("+" + line)
+	}
+	return nil
+}
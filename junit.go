@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/xml"
+	"os"
+	"sort"
+)
+
+var junitFile string
+
+func init() {
+	checkCmd.flags.StringVar(&junitFile, "junit", "", "also write the results as JUnit XML to `file`, with a failing test case for each benchmark left to unroll and each file or package skipped")
+}
+
+// JUnit XML, as understood by most build systems.
+type (
+	junitSuites struct {
+		XMLName xml.Name     `xml:"testsuites"`
+		Suites  []junitSuite `xml:"testsuite"`
+	}
+	junitSuite struct {
+		Name     string      `xml:"name,attr"`
+		Tests    int         `xml:"tests,attr"`
+		Failures int         `xml:"failures,attr"`
+		Cases    []junitCase `xml:"testcase"`
+	}
+	junitCase struct {
+		Class   string        `xml:"classname,attr"`
+		Name    string        `xml:"name,attr"`
+		File    string        `xml:"file,attr,omitempty"`
+		Line    int           `xml:"line,attr,omitempty"`
+		Failure *junitFailure `xml:"failure"`
+	}
+	junitFailure struct {
+		Message string `xml:"message,attr"`
+		Text    string `xml:",chardata"`
+	}
+)
+
+// add adds c to the suite named name, creating it if needed.
+func (j *junitSuites) add(name string, c junitCase) {
+	i := len(j.Suites)
+	for k, s := range j.Suites {
+		if s.Name == name {
+			i = k
+		}
+	}
+	if i == len(j.Suites) {
+		j.Suites = append(j.Suites, junitSuite{Name: name})
+	}
+	s := &j.Suites[i]
+	s.Tests++
+	if c.Failure != nil {
+		s.Failures++
+	}
+	s.Cases = append(s.Cases, c)
+}
+
+// addSkipped adds a failing test case for each package and file skipped.
+func (j *junitSuites) addSkipped() {
+	var paths []string
+	for path := range skipped {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		j.add("skipped", junitCase{Class: "skipped", Name: path, Failure: &junitFailure{Message: "skipped", Text: skipped[path]}})
+	}
+}
+
+// write writes j to file.
+func (j *junitSuites) write(file string) error {
+	data, err := xml.MarshalIndent(j, "", "\t")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, append([]byte(xml.Header), append(data, '\n')...), 0666)
+}
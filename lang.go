@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"go/version"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+var langFlag string
+
+func init() {
+	for _, c := range []*command{modernizeCmd, checkStyleCmd} {
+		c.flags.StringVar(&langFlag, "lang", "", "write only syntax that Go `version`, such as go1.21, accepts, which must not be above the module's go directive; by default, the go directive's version")
+	}
+}
+
+// Go versions that added syntax that rewrites can write.
+const (
+	langRangeInt = "go1.22" // for range b.N
+	langBLoop    = "go1.24" // for b.Loop()
+)
+
+// langs caches language versions by module root.
+var langs = struct {
+	sync.Mutex
+	m map[string]string
+}{m: make(map[string]string)}
+
+// langFor returns the Go language version of the package in dir:
+// -lang if set, or else the go directive of its module,
+// or "" outside modules, where any syntax goes.
+// It is fatal for -lang to be invalid or above the go directive,
+// since the code written would not compile.
+// It is safe to call concurrently.
+func langFor(dir string) string {
+	if langFlag != "" && version.Lang(langFlag) != langFlag {
+		fatal(fmt.Sprintf("bad -lang %q; want a language version like go1.22", langFlag))
+	}
+	root := moduleRoot(dir)
+	langs.Lock()
+	defer langs.Unlock()
+	lang, ok := langs.m[root]
+	if !ok {
+		directive, err := goDirective(filepath.Join(root, "go.mod"))
+		if err != nil {
+			fatal(err)
+		}
+		lang = directive
+		if langFlag != "" {
+			if directive != "" && version.Compare(langFlag, directive) > 0 {
+				fatal(fmt.Sprintf("-lang %s is above the go %s directive in %s", langFlag, strings.TrimPrefix(directive, "go"), rel(filepath.Join(root, "go.mod"))))
+			}
+			lang = langFlag
+		}
+		langs.m[root] = lang
+	}
+	return lang
+}
+
+// langAllows reports whether the package in dir may use syntax
+// added in Go version want.
+func langAllows(dir, want string) bool {
+	lang := langFor(dir)
+	return lang == "" || version.Compare(lang, want) >= 0
+}
+
+// goDirective returns the version in the go directive of the go.mod file,
+// such as go1.22, or "" if there is no such file.
+// A go.mod without the directive means go1.16.
+func goDirective(file string) (string, error) {
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) >= 2 && fields[0] == "go" {
+			v := "go" + fields[1]
+			if !version.IsValid(v) {
+				return "", fmt.Errorf("%s: bad go directive %q", rel(file), fields[1])
+			}
+			return v, nil
+		}
+	}
+	return "go1.16", s.Err()
+}
@@ -0,0 +1,59 @@
+package lint
+
+import (
+	"go/ast"
+)
+
+var bnSizing = &Rule{
+	Name:  "bn-sizing",
+	Doc:   "report b.N used to size inputs, which makes the work per iteration depend on b.N",
+	check: checkBNSizing,
+}
+
+// sizeArgs are functions whose argument at the given index sizes their result.
+var sizeArgs = map[string]int{
+	"strings.Repeat": 1,
+	"bytes.Repeat":   1,
+	"slices.Grow":    1,
+	"slices.Repeat":  1,
+}
+
+func checkBNSizing(c *checker) {
+	ast.Inspect(c.fn.Body, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if !ok {
+			return true
+		}
+		name := funcName(call)
+		var sizes []ast.Expr
+		if name == "make" && len(call.Args) > 1 {
+			// Channels with room for b.N items and slices with
+			// capacity for b.N results record per-iteration work,
+			// which is fine.
+			if _, ok := call.Args[0].(*ast.ChanType); !ok {
+				sizes = call.Args[1:2]
+			}
+		} else if i, ok := sizeArgs[name]; ok && i < len(call.Args) {
+			sizes = call.Args[i : i+1]
+		}
+		for _, x := range sizes {
+			if readsBN(x) {
+				c.report(call.Pos(), "b.N sizes the input to "+name+"; the benchmark should do the same work in each iteration, and loop b.N times")
+				break
+			}
+		}
+		return true
+	})
+}
+
+// readsBN reports whether x reads b.N.
+func readsBN(x ast.Expr) bool {
+	found := false
+	ast.Inspect(x, func(n ast.Node) bool {
+		if x, ok := n.(ast.Expr); ok && isBN(x) {
+			found = true
+		}
+		return !found
+	})
+	return found
+}
@@ -0,0 +1,241 @@
+// Package lint finds common mistakes in benchmarks.
+//
+// Like package unroll, it works on syntax alone,
+// so it can check files that do not type check, quickly.
+package lint
+
+import (
+	"go/ast"
+	"go/constant"
+	"go/token"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/josharian/unrollbench/unroll"
+)
+
+// A Rule is a benchmark hygiene check.
+type Rule struct {
+	Name string // short identifier, such as "setup-in-loop"
+	Doc  string // one line description
+
+	check func(c *checker)
+}
+
+// Rules are all the rules, in the order they are run.
+var Rules = []*Rule{
+	setupInLoop,
+	missingResetTimer,
+	unusedResults,
+	bnSizing,
+	timerInLoop,
+}
+
+// A Finding is a problem found by a rule.
+type Finding struct {
+	Pos     token.Pos
+	Rule    string
+	Func    string // the benchmark
+	Message string
+
+	// Fix, if non-nil, fixes the problem by editing the syntax
+	// passed to Check. Fixes for different findings do not conflict.
+	Fix func()
+}
+
+// Check runs rules over the benchmarks in f
+// and returns their findings, in source order.
+func Check(fset *token.FileSet, f *ast.File, rules []*Rule) []Finding {
+	var findings []Finding
+	for _, d := range f.Decls {
+		fn, ok := d.(*ast.FuncDecl)
+		if !ok || !unroll.IsBench(fn) {
+			continue
+		}
+		c := &checker{fset: fset, file: f, fn: fn}
+		c.loopIndex, c.loop = benchLoop(fn)
+		for _, r := range rules {
+			c.rule = r
+			r.check(c)
+		}
+		findings = append(findings, c.findings...)
+	}
+	sort.SliceStable(findings, func(i, j int) bool { return findings[i].Pos < findings[j].Pos })
+	return findings
+}
+
+// A checker is the state of checking one benchmark.
+type checker struct {
+	fset *token.FileSet
+	file *ast.File
+	fn   *ast.FuncDecl
+	rule *Rule
+
+	loop      *ast.ForStmt // the b.N loop, if found
+	loopIndex int          // index of loop in fn.Body.List
+
+	findings []Finding
+}
+
+func (c *checker) report(pos token.Pos, msg string) {
+	c.reportFix(pos, msg, nil)
+}
+
+func (c *checker) reportFix(pos token.Pos, msg string, fix func()) {
+	c.findings = append(c.findings, Finding{Pos: pos, Rule: c.rule.Name, Func: c.fn.Name.Name, Message: msg, Fix: fix})
+}
+
+// benchLoop returns the first top level loop in fn that runs b.N times,
+// and its index in fn's body.
+func benchLoop(fn *ast.FuncDecl) (int, *ast.ForStmt) {
+	for i, s := range fn.Body.List {
+		loop, ok := s.(*ast.ForStmt)
+		if !ok {
+			continue
+		}
+		if bin, ok := loop.Cond.(*ast.BinaryExpr); ok && bin.Op == token.LSS && isBN(bin.Y) {
+			return i, loop
+		}
+	}
+	return -1, nil
+}
+
+// insert inserts s into block before the statement at index i.
+func insert(block *ast.BlockStmt, i int, s ast.Stmt) {
+	block.List = slices.Insert(block.List, i, s)
+}
+
+var posType = reflect.TypeOf(token.NoPos)
+
+// move moves n, and any comments on the same lines, to pos,
+// so that they print there once n is moved in the syntax tree,
+// and removes the lines they leave behind.
+func (c *checker) move(n ast.Node, pos token.Pos) {
+	tf := c.fset.File(n.Pos())
+	first, last := tf.Line(n.Pos()), tf.Line(n.End())
+	for _, g := range c.file.Comments {
+		if l := tf.Line(g.Pos()); l >= first && l <= last {
+			for _, cm := range g.List {
+				cm.Slash = pos
+			}
+		}
+	}
+	setPos(reflect.ValueOf(n), pos)
+	for l := first; l <= last; l++ {
+		tf.MergeLine(first)
+	}
+}
+
+// setPos sets every position in v to pos.
+func setPos(v reflect.Value, pos token.Pos) {
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if !v.IsNil() {
+			setPos(v.Elem(), pos)
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			setPos(v.Index(i), pos)
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			f := v.Field(i)
+			if f.Type() == posType {
+				if f.Int() != int64(token.NoPos) {
+					f.SetInt(int64(pos))
+				}
+			} else if f.Type() != reflect.TypeOf((*ast.Object)(nil)) && f.Type() != reflect.TypeOf((*ast.Scope)(nil)) {
+				setPos(f, pos)
+			}
+		}
+	}
+}
+
+// isBN reports whether x is b.N.
+func isBN(x ast.Expr) bool {
+	sel, ok := x.(*ast.SelectorExpr)
+	if !ok || sel.Sel.Name != "N" {
+		return false
+	}
+	id, ok := sel.X.(*ast.Ident)
+	return ok && id.Name == "b"
+}
+
+// funcName returns the name of the function called by call,
+// such as "regexp.MustCompile", or "" if it is not a simple name.
+func funcName(call *ast.CallExpr) string {
+	switch fun := call.Fun.(type) {
+	case *ast.Ident:
+		return fun.Name
+	case *ast.SelectorExpr:
+		if x, ok := fun.X.(*ast.Ident); ok {
+			return x.Name + "." + fun.Sel.Name
+		}
+	}
+	return ""
+}
+
+// isConst reports whether x is evidently constant:
+// built from literals alone.
+func isConst(x ast.Expr) bool {
+	switch x := x.(type) {
+	case *ast.BasicLit:
+		return true
+	case *ast.ParenExpr:
+		return isConst(x.X)
+	case *ast.UnaryExpr:
+		return isConst(x.X)
+	case *ast.BinaryExpr:
+		return isConst(x.X) && isConst(x.Y)
+	}
+	return false
+}
+
+// constInt returns the value of x, if it is an integer built from literals.
+func constInt(x ast.Expr) (int64, bool) {
+	v := constValue(x)
+	if v.Kind() != constant.Int {
+		return 0, false
+	}
+	return constant.Int64Val(v)
+}
+
+func constValue(x ast.Expr) constant.Value {
+	switch x := x.(type) {
+	case *ast.BasicLit:
+		return constant.MakeFromLiteral(x.Value, x.Kind, 0)
+	case *ast.ParenExpr:
+		return constValue(x.X)
+	case *ast.UnaryExpr:
+		return constant.UnaryOp(x.Op, constValue(x.X), 0)
+	case *ast.BinaryExpr:
+		l, r := constValue(x.X), constValue(x.Y)
+		switch x.Op {
+		case token.SHL, token.SHR:
+			s, ok := constant.Uint64Val(r)
+			if !ok || s > 64 {
+				return constant.MakeUnknown()
+			}
+			return constant.Shift(l, x.Op, uint(s))
+		case token.EQL, token.NEQ, token.LSS, token.LEQ, token.GTR, token.GEQ:
+			return constant.MakeBool(constant.Compare(l, x.Op, r))
+		case token.QUO:
+			if constant.Sign(r) == 0 {
+				return constant.MakeUnknown()
+			}
+		}
+		return constant.BinaryOp(l, x.Op, r)
+	}
+	return constant.MakeUnknown()
+}
+
+// mentions reports whether the benchmark's name mentions name,
+// which suggests that name is what it measures.
+func (c *checker) mentions(name string) bool {
+	if i := strings.LastIndexByte(name, '.'); i >= 0 {
+		name = name[i+1:]
+	}
+	return strings.Contains(c.fn.Name.Name, name)
+}
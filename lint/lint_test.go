@@ -0,0 +1,106 @@
+package lint
+
+import (
+	"bytes"
+	"flag"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// TestRules checks the findings for testdata/*.go
+// against the // want "regexp" comments on their lines.
+func TestRules(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "*.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			fset := token.NewFileSet()
+			f, err := parser.ParseFile(fset, file, nil, parser.ParseComments)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := make(map[int]*regexp.Regexp)
+			for _, g := range f.Comments {
+				for _, c := range g.List {
+					text, ok := strings.CutPrefix(c.Text, "// want ")
+					if !ok {
+						continue
+					}
+					pattern, err := strconv.Unquote(text)
+					if err != nil {
+						t.Fatalf("%v: bad want comment: %v", fset.Position(c.Pos()), err)
+					}
+					want[fset.Position(c.Pos()).Line] = regexp.MustCompile(pattern)
+				}
+			}
+			for _, finding := range Check(fset, f, Rules) {
+				pos := fset.Position(finding.Pos)
+				re := want[pos.Line]
+				if re == nil {
+					t.Errorf("%v: unexpected finding: %s", pos, finding.Message)
+					continue
+				}
+				if !re.MatchString(finding.Message) {
+					t.Errorf("%v: finding %q does not match %q", pos, finding.Message, re)
+				}
+				delete(want, pos.Line)
+			}
+			for line, re := range want {
+				t.Errorf("%s:%d: no finding matching %q", file, line, re)
+			}
+		})
+	}
+}
+
+var update = flag.Bool("update", false, "update golden files")
+
+// TestFixes checks the result of applying all fixes to testdata/*.go
+// against the .golden files, for those files that have one.
+func TestFixes(t *testing.T) {
+	goldens, err := filepath.Glob(filepath.Join("testdata", "*.go.golden"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, golden := range goldens {
+		file := strings.TrimSuffix(golden, ".golden")
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			fset := token.NewFileSet()
+			f, err := parser.ParseFile(fset, file, nil, parser.ParseComments)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, finding := range Check(fset, f, Rules) {
+				if finding.Fix != nil {
+					finding.Fix()
+				}
+			}
+			var buf bytes.Buffer
+			if err := format.Node(&buf, fset, f); err != nil {
+				t.Fatal(err)
+			}
+			got := buf.Bytes()
+			if *update {
+				if err := os.WriteFile(golden, got, 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("fixed output does not match %s; got:\n%s", golden, got)
+			}
+		})
+	}
+}
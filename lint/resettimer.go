@@ -0,0 +1,122 @@
+package lint
+
+import (
+	"fmt"
+	"go/ast"
+	"slices"
+)
+
+var missingResetTimer = &Rule{
+	Name:  "missing-reset-timer",
+	Doc:   "report expensive setup before the b.N loop that is not excluded from the timing",
+	check: checkMissingResetTimer,
+}
+
+// ioFuncs are functions that do file or network I/O.
+var ioFuncs = map[string]bool{
+	"os.ReadFile":     true,
+	"os.Open":         true,
+	"os.ReadDir":      true,
+	"ioutil.ReadFile": true,
+	"ioutil.ReadAll":  true,
+	"ioutil.ReadDir":  true,
+	"io.ReadAll":      true,
+	"filepath.Glob":   true,
+	"filepath.Walk":   true,
+	"http.Get":        true,
+}
+
+// bigAlloc is the size of a make with a constant size
+// that counts as expensive setup.
+const bigAlloc = 1 << 16
+
+func checkMissingResetTimer(c *checker) {
+	if c.loop == nil {
+		return
+	}
+	var (
+		expensive ast.Stmt
+		what      string
+		running   = true // the timer
+	)
+	for _, s := range c.fn.Body.List[:c.loopIndex] {
+		switch timerCall(s) {
+		case "b.ResetTimer":
+			expensive = nil
+			continue
+		case "b.StopTimer":
+			running = false
+			continue
+		case "b.StartTimer":
+			running = true
+			continue
+		}
+		if expensive != nil || !running {
+			continue
+		}
+		if w := expensiveSetup(s); w != "" {
+			expensive, what = s, w
+		}
+	}
+	if expensive == nil {
+		return
+	}
+	loop := c.loop
+	c.reportFix(expensive.Pos(), fmt.Sprintf("%s before the loop is timed; call b.ResetTimer before the loop", what), func() {
+		reset := &ast.ExprStmt{X: &ast.CallExpr{
+			Fun: &ast.SelectorExpr{
+				X:   &ast.Ident{NamePos: loop.For, Name: "b"},
+				Sel: &ast.Ident{NamePos: loop.For, Name: "ResetTimer"},
+			},
+			Lparen: loop.For,
+			Rparen: loop.For,
+		}}
+		insert(c.fn.Body, slices.Index(c.fn.Body.List, ast.Stmt(loop)), reset)
+	})
+}
+
+// expensiveSetup returns a description of s if it looks expensive,
+// and "" otherwise.
+func expensiveSetup(s ast.Stmt) string {
+	switch s.(type) {
+	case *ast.ForStmt, *ast.RangeStmt:
+		return "a loop"
+	}
+	what := ""
+	ast.Inspect(s, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if !ok || what != "" {
+			return what == ""
+		}
+		name := funcName(call)
+		switch {
+		case ioFuncs[name]:
+			what = name
+		case name == "make":
+			for _, arg := range call.Args[1:] {
+				if n, ok := constInt(arg); ok && n >= bigAlloc {
+					what = "a large allocation"
+				}
+			}
+		}
+		return what == ""
+	})
+	return what
+}
+
+// timerCall returns the name of the b timer method called by s, if any.
+func timerCall(s ast.Stmt) string {
+	es, ok := s.(*ast.ExprStmt)
+	if !ok {
+		return ""
+	}
+	call, ok := es.X.(*ast.CallExpr)
+	if !ok {
+		return ""
+	}
+	switch name := funcName(call); name {
+	case "b.ResetTimer", "b.StopTimer", "b.StartTimer":
+		return name
+	}
+	return ""
+}
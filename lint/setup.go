@@ -0,0 +1,152 @@
+package lint
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"slices"
+)
+
+var setupInLoop = &Rule{
+	Name:  "setup-in-loop",
+	Doc:   "report setup with constant inputs done on every iteration of the b.N loop",
+	check: checkSetupInLoop,
+}
+
+// setupFuncs are functions that, called with constant arguments,
+// build the same value every time: setup, not work to measure.
+var setupFuncs = map[string]bool{
+	"regexp.Compile":          true,
+	"regexp.CompilePOSIX":     true,
+	"regexp.MustCompile":      true,
+	"regexp.MustCompilePOSIX": true,
+	"template.New":            true,
+	"strings.Repeat":          true,
+	"strings.NewReplacer":     true,
+	"bytes.Repeat":            true,
+	"time.LoadLocation":       true,
+	"big.NewInt":              true,
+}
+
+// immutableSetup are the setupFuncs whose results are never modified,
+// so that moving them out of the loop cannot change what it does.
+var immutableSetup = map[string]bool{
+	"regexp.Compile":          true,
+	"regexp.CompilePOSIX":     true,
+	"regexp.MustCompile":      true,
+	"regexp.MustCompilePOSIX": true,
+	"strings.Repeat":          true,
+	"strings.NewReplacer":     true,
+	"time.LoadLocation":       true,
+}
+
+func checkSetupInLoop(c *checker) {
+	if c.loop == nil {
+		return
+	}
+	for _, s := range c.loop.Body.List {
+		as, ok := s.(*ast.AssignStmt)
+		if !ok || len(as.Rhs) != 1 {
+			continue
+		}
+		what := setup(as.Rhs[0])
+		if what == "" || c.mentions(what) {
+			continue
+		}
+		var fix func()
+		if immutableSetup[what] && c.hoistable(as) {
+			loop := c.loop
+			fix = func() {
+				body := c.fn.Body
+				loop.Body.List = slices.DeleteFunc(loop.Body.List, func(s ast.Stmt) bool { return s == as })
+				// Keep the setup out of the timing if the loop was already.
+				i := slices.Index(body.List, ast.Stmt(loop))
+				for i > 0 && timerCall(body.List[i-1]) == "b.ResetTimer" {
+					i--
+				}
+				// Just before the loop, so that comments on as print after it.
+				c.move(as, loop.For-1)
+				insert(body, i, as)
+			}
+		}
+		c.reportFix(s.Pos(), fmt.Sprintf("%s with constant arguments runs on every iteration; move it before the loop", what), fix)
+	}
+}
+
+// hoistable reports whether as, in the b.N loop, can move before the loop:
+// it assigns only to variables that nothing else in the benchmark mentions,
+// except to read them in the loop.
+func (c *checker) hoistable(as *ast.AssignStmt) bool {
+	names := make(map[string]bool)
+	for _, x := range as.Lhs {
+		id, ok := x.(*ast.Ident)
+		if !ok {
+			return false
+		}
+		if id.Name != "_" {
+			names[id.Name] = true
+		}
+	}
+	ok := true
+	for _, s := range c.fn.Body.List {
+		if s != c.loop {
+			ast.Inspect(s, func(n ast.Node) bool {
+				if id, isID := n.(*ast.Ident); isID && names[id.Name] {
+					ok = false
+				}
+				return ok
+			})
+		}
+	}
+	for _, s := range c.loop.Body.List {
+		if s == as {
+			continue
+		}
+		ast.Inspect(s, func(n ast.Node) bool {
+			var written []ast.Expr
+			switch n := n.(type) {
+			case *ast.AssignStmt:
+				written = n.Lhs
+			case *ast.IncDecStmt:
+				written = []ast.Expr{n.X}
+			case *ast.UnaryExpr:
+				if n.Op == token.AND {
+					written = []ast.Expr{n.X}
+				}
+			case *ast.RangeStmt:
+				written = []ast.Expr{n.Key, n.Value}
+			}
+			for _, x := range written {
+				if id, isID := x.(*ast.Ident); isID && names[id.Name] {
+					ok = false
+				}
+			}
+			return ok
+		})
+	}
+	return ok
+}
+
+// setup returns a description of x if it is setup with constant inputs,
+// and "" otherwise.
+func setup(x ast.Expr) string {
+	call, ok := x.(*ast.CallExpr)
+	if !ok {
+		return ""
+	}
+	for _, arg := range call.Args {
+		if !isConst(arg) {
+			return ""
+		}
+	}
+	if name := funcName(call); setupFuncs[name] {
+		return name
+	}
+	// Conversions like []byte("...") allocate a copy every time.
+	if at, ok := call.Fun.(*ast.ArrayType); ok && at.Len == nil && len(call.Args) == 1 {
+		if elt, ok := at.Elt.(*ast.Ident); ok && (elt.Name == "byte" || elt.Name == "rune") {
+			return "[]" + elt.Name + " conversion"
+		}
+	}
+	return ""
+}
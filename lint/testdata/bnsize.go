@@ -0,0 +1,39 @@
+package p
+
+import (
+	"strings"
+	"testing"
+)
+
+func BenchmarkSum(b *testing.B) {
+	s := make([]int, b.N) // want "b.N sizes the input to make"
+	for i := 0; i < b.N; i++ {
+		s[i] = i
+	}
+}
+
+func BenchmarkIndex(b *testing.B) {
+	s := strings.Repeat("x", b.N) + "y" // want "b.N sizes the input to strings.Repeat"
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		sinkInt = strings.IndexByte(s, 'y')
+	}
+}
+
+var sinkInt int
+
+func BenchmarkFixed(b *testing.B) {
+	s := make([]int, 0, 100)
+	for i := 0; i < b.N; i++ {
+		s = append(s[:0], i)
+	}
+}
+
+func BenchmarkLatency(b *testing.B) {
+	latencies := make([]int64, 0, b.N)
+	work := make(chan int, b.N)
+	for i := 0; i < b.N; i++ {
+		work <- i
+		latencies = append(latencies, int64(<-work))
+	}
+}
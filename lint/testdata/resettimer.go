@@ -0,0 +1,67 @@
+package p
+
+import (
+	"os"
+	"testing"
+)
+
+func BenchmarkRead(b *testing.B) {
+	data, err := os.ReadFile("testdata/big") // want "os.ReadFile before the loop is timed"
+	if err != nil {
+		b.Fatal(err)
+	}
+	for i := 0; i < b.N; i++ {
+		process(data)
+	}
+}
+
+func BenchmarkFill(b *testing.B) {
+	buf := make([]int, 1<<20) // want "a large allocation before the loop is timed"
+	for i := range buf {
+		buf[i] = i
+	}
+	for i := 0; i < b.N; i++ {
+		sum(buf)
+	}
+}
+
+func BenchmarkLoop(b *testing.B) {
+	var buf [64]int
+	for i := range buf { // want "a loop before the loop is timed"
+		buf[i] = i
+	}
+	for i := 0; i < b.N; i++ {
+		sum(buf[:])
+	}
+}
+
+func BenchmarkBig(b *testing.B) {
+	buf := make([]byte, 100000) // want "a large allocation"
+	for i := 0; i < b.N; i++ {
+		process(buf)
+	}
+}
+
+func BenchmarkReset(b *testing.B) {
+	data, _ := os.ReadFile("testdata/big")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		process(data)
+	}
+}
+
+func BenchmarkSmall(b *testing.B) {
+	buf := make([]byte, 64)
+	for i := 0; i < b.N; i++ {
+		process(buf)
+	}
+}
+
+func BenchmarkStopped(b *testing.B) {
+	b.StopTimer()
+	data, _ := os.ReadFile("testdata/big")
+	b.StartTimer()
+	for i := 0; i < b.N; i++ {
+		process(data)
+	}
+}
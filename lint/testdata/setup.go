@@ -0,0 +1,53 @@
+package p
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func BenchmarkMatch(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		re := regexp.MustCompile(`a+b`) // want "regexp.MustCompile with constant arguments runs on every iteration"
+		re.MatchString("aab")
+	}
+}
+
+func BenchmarkBytes(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		buf := []byte("hello") // want `\[\]byte conversion`
+		_ = buf
+	}
+}
+
+// Measuring the setup function itself is fine.
+func BenchmarkMustCompile(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		re := regexp.MustCompile(`a+b`)
+		_ = re
+	}
+}
+
+// So is setup that depends on the iteration.
+func BenchmarkRepeatI(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		s := strings.Repeat("x", i%10)
+		_ = s
+	}
+}
+
+func BenchmarkHoisted(b *testing.B) {
+	re := regexp.MustCompile(`a+b`)
+	for i := 0; i < b.N; i++ {
+		re.MatchString("aab")
+	}
+}
+
+// The fix leaves setup alone if the loop changes it.
+func BenchmarkReassigned(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		re := regexp.MustCompile(`a+b`) // want "regexp.MustCompile with constant arguments"
+		re.MatchString("aab")
+		re = nil
+	}
+}
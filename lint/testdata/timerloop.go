@@ -0,0 +1,32 @@
+package p
+
+import (
+	"sort"
+	"testing"
+)
+
+func BenchmarkSort(b *testing.B) {
+	data := make([]int, 100)
+	for i := 0; i < b.N; i++ {
+		b.StopTimer() // want "prepare the inputs for all b.N iterations"
+		for j := range data {
+			data[j] = len(data) - j
+		}
+		b.StartTimer()
+		sort.Ints(data)
+	}
+}
+
+func BenchmarkCheck(b *testing.B) {
+	data := make([]int, 100)
+	for i := 0; i < b.N; i++ {
+		sort.Ints(data)
+		if i%100 == 0 {
+			b.StopTimer() // want "move the untimed work out of the loop"
+			check(data)
+			b.StartTimer()
+		}
+	}
+}
+
+func check([]int) {}
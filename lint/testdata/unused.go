@@ -0,0 +1,39 @@
+package p
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+var x = 2.0
+
+func BenchmarkSqrt(b *testing.B) {
+	for i := 0; i < b.N; i++ { // want "only computes results it discards"
+		math.Sqrt(x)
+	}
+}
+
+func BenchmarkBlank(b *testing.B) {
+	s := "Hello"
+	for i := 0; i < b.N; i++ { // want "only computes results it discards"
+		_ = strings.ToUpper(s)
+		_ = len(s) + i
+	}
+}
+
+var sink float64
+
+func BenchmarkSink(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		sink = math.Sqrt(x)
+	}
+}
+
+func BenchmarkCall(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		_ = compute(i)
+	}
+}
+
+func compute(i int) int { return i }
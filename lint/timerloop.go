@@ -0,0 +1,57 @@
+package lint
+
+import (
+	"go/ast"
+)
+
+var timerInLoop = &Rule{
+	Name:  "timer-in-loop",
+	Doc:   "report b.StopTimer and b.StartTimer in the b.N loop, whose cost swamps fast benchmarks",
+	check: checkTimerInLoop,
+}
+
+func checkTimerInLoop(c *checker) {
+	if c.loop == nil {
+		return
+	}
+	var stop ast.Node
+	ast.Inspect(c.loop.Body, func(n ast.Node) bool {
+		if stop != nil {
+			return false
+		}
+		if call, ok := n.(*ast.CallExpr); ok && funcName(call) == "b.StopTimer" {
+			stop = call
+		}
+		return true
+	})
+	if stop == nil {
+		return
+	}
+	msg := "b.StopTimer in the loop costs more than many benchmarks measure, and skews their results"
+	if untimedPrefix(c.loop.Body.List) {
+		// The body is: stop, setup, start, work.
+		msg += "; prepare the inputs for all b.N iterations before the loop instead"
+	} else {
+		msg += "; move the untimed work out of the loop, or into a separate benchmark"
+	}
+	c.report(stop.Pos(), msg)
+}
+
+// untimedPrefix reports whether list starts by stopping the timer,
+// does untimed setup, and then restarts the timer, and never stops it again.
+func untimedPrefix(list []ast.Stmt) bool {
+	if len(list) < 3 || timerCall(list[0]) != "b.StopTimer" {
+		return false
+	}
+	for i, s := range list[1:] {
+		if timerCall(s) == "b.StartTimer" {
+			for _, s := range list[i+2:] {
+				if timerCall(s) != "" {
+					return false
+				}
+			}
+			return i > 0
+		}
+	}
+	return false
+}
@@ -0,0 +1,210 @@
+package lint
+
+import (
+	"go/ast"
+	"go/token"
+	"strings"
+)
+
+var unusedResults = &Rule{
+	Name:  "unused-results",
+	Doc:   "report b.N loops that compute only results they throw away, which the compiler may eliminate",
+	check: checkUnusedResults,
+}
+
+// pureFuncs are functions without side effects, with their result types.
+var pureFuncs = map[string]string{
+	"len":                    "int",
+	"cap":                    "int",
+	"math.Abs":               "float64",
+	"math.Ceil":              "float64",
+	"math.Exp":               "float64",
+	"math.Floor":             "float64",
+	"math.Log":               "float64",
+	"math.Max":               "float64",
+	"math.Min":               "float64",
+	"math.Pow":               "float64",
+	"math.Sqrt":              "float64",
+	"math.Trunc":             "float64",
+	"bits.LeadingZeros":      "int",
+	"bits.LeadingZeros32":    "int",
+	"bits.LeadingZeros64":    "int",
+	"bits.Len":               "int",
+	"bits.Len32":             "int",
+	"bits.Len64":             "int",
+	"bits.OnesCount":         "int",
+	"bits.OnesCount32":       "int",
+	"bits.OnesCount64":       "int",
+	"bits.TrailingZeros":     "int",
+	"bits.TrailingZeros32":   "int",
+	"bits.TrailingZeros64":   "int",
+	"bits.ReverseBytes64":    "uint64",
+	"bits.RotateLeft64":      "uint64",
+	"strconv.Itoa":           "string",
+	"strconv.Quote":          "string",
+	"strings.Compare":        "int",
+	"strings.Contains":       "bool",
+	"strings.Count":          "int",
+	"strings.EqualFold":      "bool",
+	"strings.HasPrefix":      "bool",
+	"strings.HasSuffix":      "bool",
+	"strings.Index":          "int",
+	"strings.IndexByte":      "int",
+	"strings.LastIndex":      "int",
+	"strings.ToLower":        "string",
+	"strings.ToUpper":        "string",
+	"strings.TrimSpace":      "string",
+	"bytes.Compare":          "int",
+	"bytes.Contains":         "bool",
+	"bytes.Equal":            "bool",
+	"bytes.Index":            "int",
+	"bytes.IndexByte":        "int",
+	"unicode.IsDigit":        "bool",
+	"unicode.IsLetter":       "bool",
+	"unicode.IsSpace":        "bool",
+	"unicode.ToLower":        "rune",
+	"unicode.ToUpper":        "rune",
+	"utf8.RuneLen":           "int",
+	"utf8.RuneCountInString": "int",
+	"utf8.ValidString":       "bool",
+}
+
+// basicTypes are the predeclared types whose conversions are pure.
+var basicTypes = map[string]bool{
+	"bool": true, "string": true, "byte": true, "rune": true,
+	"int": true, "int8": true, "int16": true, "int32": true, "int64": true,
+	"uint": true, "uint8": true, "uint16": true, "uint32": true, "uint64": true, "uintptr": true,
+	"float32": true, "float64": true, "complex64": true, "complex128": true,
+}
+
+func checkUnusedResults(c *checker) {
+	if c.loop == nil || len(c.loop.Body.List) == 0 {
+		return
+	}
+	fixable := true
+	for _, s := range c.loop.Body.List {
+		if !discarded(s) {
+			return
+		}
+		fixable = fixable && resultType(s) != ""
+	}
+	var fix func()
+	if fixable {
+		list := c.loop.Body.List
+		fix = func() {
+			for i, s := range list {
+				typ := resultType(s)
+				name := "sink" + strings.ToUpper(typ[:1]) + typ[1:]
+				switch s := s.(type) {
+				case *ast.AssignStmt:
+					s.Lhs[0].(*ast.Ident).Name = name
+				case *ast.ExprStmt:
+					list[i] = &ast.AssignStmt{
+						Lhs:    []ast.Expr{&ast.Ident{NamePos: s.Pos(), Name: name}},
+						TokPos: s.Pos(),
+						Tok:    token.ASSIGN,
+						Rhs:    []ast.Expr{s.X},
+					}
+				}
+				declareSink(c.file, name, typ)
+			}
+		}
+	}
+	c.reportFix(c.loop.Pos(), "the loop body only computes results it discards, so the compiler may eliminate the work; assign them to a package-level variable", fix)
+}
+
+// resultType returns the type of the single result discarded by s,
+// if it is evident, and "" otherwise.
+func resultType(s ast.Stmt) string {
+	var x ast.Expr
+	switch s := s.(type) {
+	case *ast.AssignStmt:
+		if len(s.Lhs) != 1 {
+			return ""
+		}
+		x = s.Rhs[0]
+	case *ast.ExprStmt:
+		x = s.X
+	}
+	call, ok := x.(*ast.CallExpr)
+	if !ok {
+		return ""
+	}
+	name := funcName(call)
+	if basicTypes[name] {
+		return name
+	}
+	return pureFuncs[name]
+}
+
+// declareSink declares the package-level variable name of type typ in f,
+// unless f already declares it.
+func declareSink(f *ast.File, name, typ string) {
+	for _, d := range f.Decls {
+		gd, ok := d.(*ast.GenDecl)
+		if !ok || gd.Tok != token.VAR {
+			continue
+		}
+		for _, spec := range gd.Specs {
+			for _, id := range spec.(*ast.ValueSpec).Names {
+				if id.Name == name {
+					return
+				}
+			}
+		}
+	}
+	f.Decls = append(f.Decls, &ast.GenDecl{
+		Tok: token.VAR,
+		Specs: []ast.Spec{&ast.ValueSpec{
+			Names: []*ast.Ident{ast.NewIdent(name)},
+			Type:  ast.NewIdent(typ),
+		}},
+	})
+}
+
+// discarded reports whether s computes a pure result and throws it away.
+func discarded(s ast.Stmt) bool {
+	switch s := s.(type) {
+	case *ast.AssignStmt:
+		if s.Tok != token.ASSIGN {
+			return false
+		}
+		for _, x := range s.Lhs {
+			if id, ok := x.(*ast.Ident); !ok || id.Name != "_" {
+				return false
+			}
+		}
+		for _, x := range s.Rhs {
+			if !pure(x) {
+				return false
+			}
+		}
+		return true
+	case *ast.ExprStmt:
+		call, ok := s.X.(*ast.CallExpr)
+		return ok && pure(call)
+	}
+	return false
+}
+
+// pure reports whether evaluating x evidently has no side effects.
+func pure(x ast.Expr) bool {
+	ok := true
+	ast.Inspect(x, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.CallExpr:
+			name := funcName(n)
+			if _, isPure := pureFuncs[name]; !isPure && !basicTypes[name] {
+				ok = false
+			}
+		case *ast.UnaryExpr:
+			if n.Op == token.ARROW {
+				ok = false
+			}
+		case *ast.FuncLit, *ast.CompositeLit:
+			ok = false
+		}
+		return ok
+	})
+	return ok
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"os"
+	"strings"
+
+	"github.com/josharian/unrollbench/lint"
+)
+
+var lintCmd = newCommand("lint", "[-fix] [-rules list] [packages]", "report common mistakes in benchmarks", runLint)
+
+var (
+	lintFix        bool
+	lintConfigFile string
+	lintRuleList   string
+)
+
+func init() {
+	lintCmd.flags.BoolVar(&lintFix, "fix", false, "fix the findings that have mechanical fixes, rewriting files like unroll")
+	lintCmd.flags.StringVar(&lintConfigFile, "config", "", "read rule severities from `file`, with one rule and severity per line")
+	lintCmd.flags.StringVar(&lintRuleList, "rules", "", "set rule severities from a comma-separated `list` of rule=severity, overriding -config; severities are error, warning, and off")
+}
+
+// Lint rule severities. Findings of error severity make lint fail.
+const (
+	severityError   = "error"
+	severityWarning = "warning"
+	severityOff     = "off"
+)
+
+// lintSeverity is the severity of each lint rule, by name.
+var lintSeverity map[string]string
+
+func runLint(c *command, args []string) {
+	if len(args) < 1 {
+		c.flags.Usage()
+	}
+	configureLint()
+	pkgs := loadPackages(args)
+	if lintFix {
+		prepareGit(pkgs)
+	}
+	failed := false
+	for _, cand := range candidates(testFiles(pkgs...)) {
+		fset := token.NewFileSet()
+		f, err := parseFile(fset, cand.file, cand.src)
+		if err != nil {
+			fail(err)
+			continue
+		}
+		for _, finding := range lint.Check(fset, f, lintRules()) {
+			sev := lintSeverity[finding.Rule]
+			fixed := ""
+			if lintFix && finding.Fix != nil {
+				fixed = " (fixed)"
+			} else if sev == severityError {
+				failed = true
+			}
+			fmt.Printf("%v: %s: %s: %s [%s]%s\n", fset.Position(finding.Pos), sev, finding.Func, finding.Message, finding.Rule, fixed)
+		}
+	}
+	if lintFix {
+		changes := rewrite(pkgs, false, fixFile)
+		commitGit(changes, "fix benchmark mistakes found by unrollbench lint")
+	}
+	if failed {
+		exit(1)
+	}
+}
+
+// configureLint sets lintSeverity from -config and -rules.
+func configureLint() {
+	lintSeverity = make(map[string]string)
+	for _, r := range lint.Rules {
+		lintSeverity[r.Name] = severityError
+	}
+	if lintConfigFile != "" {
+		f, err := os.Open(lintConfigFile)
+		if err != nil {
+			fatal(err)
+		}
+		s := bufio.NewScanner(f)
+		for line := 1; s.Scan(); line++ {
+			text := strings.TrimSpace(s.Text())
+			if text == "" || strings.HasPrefix(text, "#") {
+				continue
+			}
+			fields := strings.Fields(text)
+			if len(fields) != 2 {
+				fatal(fmt.Sprintf("%s:%d: want rule and severity", lintConfigFile, line))
+			}
+			if err := setSeverity(fields[0], fields[1]); err != nil {
+				fatal(fmt.Sprintf("%s:%d: %v", lintConfigFile, line, err))
+			}
+		}
+		f.Close()
+		if err := s.Err(); err != nil {
+			fatal(err)
+		}
+	}
+	if lintRuleList != "" {
+		for _, entry := range strings.Split(lintRuleList, ",") {
+			rule, sev, ok := strings.Cut(strings.TrimSpace(entry), "=")
+			if !ok {
+				fatal(fmt.Sprintf("bad -rules entry %q; want rule=severity", entry))
+			}
+			if err := setSeverity(rule, sev); err != nil {
+				fatal(err)
+			}
+		}
+	}
+}
+
+func setSeverity(rule, sev string) error {
+	if _, ok := lintSeverity[rule]; !ok {
+		var names []string
+		for _, r := range lint.Rules {
+			names = append(names, r.Name)
+		}
+		return fmt.Errorf("unknown lint rule %q; rules are %s", rule, strings.Join(names, ", "))
+	}
+	switch sev {
+	case severityError, severityWarning, severityOff:
+	default:
+		return fmt.Errorf("bad severity %q for %s; want error, warning, or off", sev, rule)
+	}
+	lintSeverity[rule] = sev
+	return nil
+}
+
+// lintRules returns the rules that are not turned off.
+func lintRules() []*lint.Rule {
+	var rules []*lint.Rule
+	for _, r := range lint.Rules {
+		if lintSeverity[r.Name] != severityOff {
+			rules = append(rules, r)
+		}
+	}
+	return rules
+}
+
+// fixFile applies the fixes for the findings in f.
+func fixFile(fset *token.FileSet, f *ast.File) bool {
+	fixed := false
+	for _, finding := range lint.Check(fset, f, lintRules()) {
+		if finding.Fix != nil {
+			finding.Fix()
+			fixed = true
+		}
+	}
+	return fixed
+}
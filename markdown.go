@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/josharian/unrollbench/bench"
+	"golang.org/x/perf/benchmath"
+)
+
+var mdFile string
+
+func init() {
+	for _, c := range []*command{unrollCmd, rerollCmd, normalizeCmd, modernizeCmd, runCmd} {
+		c.flags.StringVar(&mdFile, "md", "", "write a Markdown summary of the rewritten benchmarks, what was skipped, and for run, the measured changes, to `file`, for pasting into a pull request")
+	}
+}
+
+// writeMarkdown writes the -md summary of changes, and if old is not nil,
+// of the change in results from old to new.
+func writeMarkdown(changes []change, old, new []*bench.Result) {
+	if mdFile == "" {
+		return
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "Generated by `unrollbench %s`.\n", strings.Join(os.Args[1:], " "))
+
+	fmt.Fprintf(&buf, "\n### Rewritten benchmarks\n\n")
+	if len(changes) == 0 {
+		fmt.Fprintf(&buf, "None.\n")
+	} else {
+		fmt.Fprintf(&buf, "| Package | File | Benchmarks |\n|---|---|---|\n")
+		for _, ch := range changes {
+			fmt.Fprintf(&buf, "| %s | %s | %s |\n", mdCode(ch.pkg.ImportPath), mdCode(filepath.Base(ch.file)), mdCode(ch.funcs...))
+		}
+	}
+
+	if len(helperUses) > 0 {
+		fmt.Fprintf(&buf, "\n### Helpers rewritten\n\n| Helper | File | Called by |\n|---|---|---|\n")
+		for _, h := range helperUses {
+			fmt.Fprintf(&buf, "| %s | %s | %s |\n", mdCode(h.pkg+"."+h.name), mdCode(filepath.Base(h.file)), mdCode(h.benches...))
+		}
+	}
+
+	if len(skipped) > 0 {
+		fmt.Fprintf(&buf, "\n### Skipped\n\n| Package or file | Reason |\n|---|---|\n")
+		var paths []string
+		for path := range skipped {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+		for _, path := range paths {
+			fmt.Fprintf(&buf, "| %s | %s |\n", mdCode(path), mdEscape(skipped[path]))
+		}
+	}
+
+	if old != nil {
+		fmt.Fprintf(&buf, "\n### Results\n")
+		for _, u := range units {
+			keys, before := bench.Group(old, u.unit)
+			_, after := bench.Group(new, u.unit)
+			if len(keys) == 0 {
+				continue
+			}
+			fmt.Fprintf(&buf, "\n| Benchmark | Old %s | New %s | Delta |\n|---|--:|--:|--:|\n", u.name, u.name)
+			// Significant changes first, then those within the noise.
+			var significant, noise []string
+			for _, k := range keys {
+				if len(after[k]) == 0 {
+					continue
+				}
+				b := benchmath.NewSample(before[k], thresholds())
+				a := benchmath.NewSample(after[k], thresholds())
+				o := benchmath.AssumeNothing.Summary(b, confidence).Center
+				n := benchmath.AssumeNothing.Summary(a, confidence).Center
+				c := benchmath.AssumeNothing.Compare(b, a)
+				row := fmt.Sprintf("| %s | %s | %s | %s (%s) |\n", mdCode(k.String()), formatValue(o, u.unit), formatValue(n, u.unit), c.FormatDelta(o, n), c)
+				if c.P < c.Alpha {
+					significant = append(significant, row)
+				} else {
+					noise = append(noise, row)
+				}
+			}
+			buf.WriteString(strings.Join(append(significant, noise...), ""))
+			fmt.Fprintf(&buf, "\n%d of %d changes are significant at α = %v; the rest are within the noise.\n", len(significant), len(significant)+len(noise), alpha)
+		}
+	}
+
+	if err := os.WriteFile(mdFile, buf.Bytes(), 0666); err != nil {
+		fatal(err)
+	}
+}
+
+// mdCode formats names as a comma-separated list of code spans.
+func mdCode(names ...string) string {
+	var list []string
+	for _, name := range names {
+		list = append(list, "`"+name+"`")
+	}
+	return strings.Join(list, ", ")
+}
+
+// mdEscape escapes s for use in a table cell.
+func mdEscape(s string) string {
+	return strings.ReplaceAll(s, "|", `\|`)
+}
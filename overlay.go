@@ -0,0 +1,42 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+)
+
+// overlayJSON is the format of go build's -overlay file.
+type overlayJSON struct {
+	Replace map[string]string
+}
+
+// add records that file is replaced by a file in the cache directory,
+// and returns the path of the replacement.
+// The replacement's directory is created if needed.
+func (o *overlayJSON) add(file string) string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		fatal(err)
+	}
+	dir = filepath.Join(dir, "unrollbench", "overlay")
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		fatal(err)
+	}
+	// Files in different packages often share a name,
+	// so key the replacement by the original's full path.
+	sum := sha256.Sum256([]byte(file))
+	dst := filepath.Join(dir, hex.EncodeToString(sum[:8])+"_"+filepath.Base(file))
+	o.Replace[file] = dst
+	return dst
+}
+
+func (o *overlayJSON) write(file string) error {
+	data, err := json.MarshalIndent(o, "", "\t")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, append(data, '\n'), 0666)
+}
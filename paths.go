@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// canonical returns path with symlinks resolved,
+// or path itself if it cannot be resolved.
+func canonical(path string) string {
+	if c, err := filepath.EvalSymlinks(path); err == nil {
+		if abs, err := filepath.Abs(c); err == nil {
+			return abs
+		}
+	}
+	return path
+}
+
+// moduleRoot returns the root of the module containing dir:
+// the nearest directory with a go.mod file, or without one, dir itself.
+func moduleRoot(pkgDir string) string {
+	for dir := pkgDir; ; {
+		if exists(filepath.Join(dir, "go.mod")) {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+	return pkgDir
+}
+
+// importPaths caches importPath by package directory.
+var importPaths = make(map[string]string)
+
+// importPath returns the import path of pkg as go test reports it.
+// build.Import leaves packages named by relative paths, such as ./p,
+// with those paths, so go list is asked for theirs.
+func importPath(pkg *build.Package) string {
+	if !build.IsLocalImport(pkg.ImportPath) {
+		return pkg.ImportPath
+	}
+	if path, ok := importPaths[pkg.Dir]; ok {
+		return path
+	}
+	cmd := exec.CommandContext(ctx, "go", "list", "-e", "-f", "{{.ImportPath}}", ".")
+	cmd.Dir = pkg.Dir
+	out, err := cmd.Output()
+	if err != nil {
+		fatal(fmt.Sprintf("go list %s: %v", rel(pkg.Dir), err))
+	}
+	path := strings.TrimSpace(string(out))
+	importPaths[pkg.Dir] = path
+	return path
+}
+
+// within reports whether path is dir or inside it.
+func within(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(os.PathSeparator))
+}
+
+// rel returns file as it should appear in output: with -rel,
+// relative to the current directory if it is inside it,
+// and otherwise to its module root; without -rel, unchanged.
+func rel(file string) string {
+	if !relPaths {
+		return file
+	}
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return file
+	}
+	if wd, err := os.Getwd(); err == nil && within(abs, wd) {
+		if r, err := filepath.Rel(wd, abs); err == nil {
+			return r
+		}
+	}
+	root := moduleRoot(filepath.Dir(abs))
+	if r, err := filepath.Rel(root, abs); err == nil && root != filepath.Dir(abs) {
+		return r
+	}
+	return file
+}
+
+// parseFile parses file, reading it if src is nil,
+// naming it in positions as rel does.
+// If file does not parse, the error is an unparsableError.
+func parseFile(fset *token.FileSet, file string, src []byte) (*ast.File, error) {
+	if src == nil {
+		var err error
+		if src, err = os.ReadFile(file); err != nil {
+			return nil, err
+		}
+	}
+	f, err := parser.ParseFile(fset, rel(file), src, parser.ParseComments)
+	if err != nil {
+		return nil, unparsableError{rel(file), diagnose(rel(file), src, err, false)}
+	}
+	parsed.Store(rel(file), file)
+	return f, nil
+}
+
+// parsed maps the names parseFile gives files to their paths.
+var parsed sync.Map
+
+// filePath returns the path of f, which parseFile parsed using fset.
+func filePath(fset *token.FileSet, f *ast.File) string {
+	name := fset.File(f.Pos()).Name()
+	if file, ok := parsed.Load(name); ok {
+		return file.(string)
+	}
+	return name
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/josharian/unrollbench/bench"
+)
+
+// Flags for counting events with perf stat.
+var (
+	perfStat  bool
+	perfIters int
+)
+
+func init() {
+	runCmd.flags.BoolVar(&perfStat, "perf", false, "also run each benchmark under perf stat, on Linux, and compare its hardware counters before and after")
+	runCmd.flags.IntVar(&perfIters, "perf-iters", 1000000, "run each benchmark `n` times under perf stat, so that counts compare")
+}
+
+// perfEvents are the events counted by perf stat, which unrolling
+// should reduce: the loop's increments and compares, and its branches.
+var perfEvents = []string{"instructions", "branches", "branch-misses"}
+
+// perfCounts are the counts of perfEvents in a run, by event.
+type perfCounts map[string]float64
+
+// checkPerf exits if perf stat cannot be used here.
+func checkPerf() {
+	if runtime.GOOS != "linux" {
+		fatal("-perf requires Linux")
+	}
+	if _, err := exec.LookPath("perf"); err != nil {
+		fatal(fmt.Sprintf("-perf: %v", err))
+	}
+	if perfIters < 1 {
+		fatal("-perf-iters must be positive")
+	}
+}
+
+// comparePerf runs the benchmarks in results under perf stat,
+// without and with overlay, and prints a table comparing
+// their counts per iteration to w.
+func comparePerf(w io.Writer, results []*bench.Result, overlay string) {
+	type benchmark struct{ pkg, name string }
+	var list []benchmark
+	keys, _ := bench.Group(results, "ns/op")
+	for _, k := range keys {
+		b := benchmark{k.Pkg, benchFunc(k.Name)}
+		if !slices.Contains(list, b) {
+			list = append(list, b)
+		}
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "name\tevent\told/op\tnew/op\tdelta\n")
+	for _, b := range list {
+		k := bench.Key{Pkg: b.pkg, Name: b.name}
+		old, err := perfRun(b.pkg, b.name, "")
+		if err != nil {
+			fail(fmt.Errorf("%s: %v", k, err))
+			continue
+		}
+		new, err := perfRun(b.pkg, b.name, overlay)
+		if err != nil {
+			fail(fmt.Errorf("%s: %v", k, err))
+			continue
+		}
+		for _, e := range perfEvents {
+			o, ok1 := old[e]
+			n, ok2 := new[e]
+			if !ok1 || !ok2 {
+				fmt.Fprintf(tw, "%s\t%s\tnot counted\t\t\n", k, e)
+				continue
+			}
+			o, n = o/float64(perfIters), n/float64(perfIters)
+			fmt.Fprintf(tw, "%s\t%s\t%.4g\t%.4g\t%s\n", k, e, o, n, formatDelta(o, n))
+		}
+	}
+	tw.Flush()
+}
+
+// perfRun runs the benchmark name in pkg perfIters times under perf stat,
+// with overlay if not empty, and returns the counts.
+// The counts include starting the test binary,
+// which is small next to enough iterations.
+func perfRun(pkg, name, overlay string) (perfCounts, error) {
+	tmp, err := os.CreateTemp("", "unrollbench-perf-*.csv")
+	if err != nil {
+		return nil, err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	exe := fmt.Sprintf("perf stat -x , -e %s -o %s", strings.Join(perfEvents, ","), tmp.Name())
+	args := []string{"test", "-run=^$", "-bench=^" + regexp.QuoteMeta(name) + "$", "-benchtime=" + strconv.Itoa(perfIters) + "x", "-count=1", "-exec=" + exe}
+	args = append(args, buildFlags()...)
+	if overlay != "" {
+		args = append(args, "-overlay="+overlay)
+	}
+	args = append(args, pkg)
+	cmd := exec.CommandContext(ctx, "go", args...)
+	cmd.Stderr = os.Stderr
+	if out, err := cmd.Output(); err != nil {
+		return nil, fmt.Errorf("go %v: %v\n%s", args, err, out)
+	}
+	f, err := os.Open(tmp.Name())
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parsePerf(f)
+}
+
+// parsePerf parses the CSV output of perf stat -x ,
+// which has a line per event, such as
+//
+//	123456789,,instructions:u,100.00,,
+//
+// and skips events that were not counted.
+func parsePerf(r io.Reader) (perfCounts, error) {
+	counts := make(perfCounts)
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := s.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, ",")
+		if len(fields) < 3 {
+			continue
+		}
+		v, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			// <not counted> or <not supported>
+			continue
+		}
+		event, _, _ := strings.Cut(fields[2], ":")
+		counts[event] = v
+	}
+	return counts, s.Err()
+}
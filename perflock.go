@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+// Flags for stabilizing the machine while benchmarks run.
+var (
+	perflock         bool
+	perflockGovernor string
+)
+
+func init() {
+	for _, c := range []*command{runCmd, rankCmd, factorsCmd, tuneCmd, bisectCmd} {
+		c.flags.BoolVar(&perflock, "perflock", false, "run benchmarks under perflock, which serializes them and pins the CPU frequency")
+		c.flags.StringVar(&perflockGovernor, "perflock-governor", "", "with -perflock, pin the CPU frequency to `percent` of its range, as in perflock -governor")
+	}
+}
+
+// withPerflock returns argv, a command and its arguments,
+// run under perflock if -perflock is set.
+func withPerflock(argv []string) []string {
+	if !perflock {
+		return argv
+	}
+	if _, err := exec.LookPath("perflock"); err != nil {
+		fatal(fmt.Sprintf("-perflock: %v; install it with go install github.com/aclements/perflock/cmd/perflock@latest", err))
+	}
+	lock := []string{"perflock"}
+	if perflockGovernor != "" {
+		lock = append(lock, "-governor="+perflockGovernor)
+	}
+	return append(lock, argv...)
+}
+
+// stabilization describes how the machine was stabilized,
+// for the "perflock" configuration line of each result.
+func stabilization() string {
+	switch {
+	case !perflock:
+		return "off"
+	case perflockGovernor != "":
+		return "governor=" + perflockGovernor
+	}
+	return "on"
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// policyFile is where a module, or a git repository outside modules,
+// sets the policy for rewriting its packages, relative to its root.
+// Each line is a setting:
+//
+//	max-factor n    unroll no loop more than n times
+//	opt-in          unroll only benchmarks whose doc comment has optInPragma
+//	allow dir       rewrite only packages in dir, relative to the root;
+//	                may be repeated
+//	style s         the loop style for check-style, such as range
+//
+// Blank lines and lines starting with # are ignored.
+// There are no flags to override a policy.
+const policyFile = ".unrollbench/policy"
+
+// optInPragma marks a benchmark as one to unroll, under an opt-in policy.
+const optInPragma = "//unrollbench:unroll"
+
+// A policy is the contents of a policyFile.
+type policy struct {
+	file      string    // where it came from, or "" if there is none
+	maxFactor int       // if nonzero, the largest factor allowed
+	optIn     bool      // unroll only benchmarks marked with optInPragma
+	allow     []string  // if set, the only directories to rewrite
+	style     loopStyle // if set, the style for check-style
+}
+
+// policies caches policies by package directory and by root directory.
+var policies = struct {
+	sync.Mutex
+	m map[string]*policy
+}{m: make(map[string]*policy)}
+
+// policyFor returns the policy for the package in dir.
+// It is safe to call concurrently.
+func policyFor(dir string) *policy {
+	policies.Lock()
+	defer policies.Unlock()
+	if p, ok := policies.m[dir]; ok {
+		return p
+	}
+	root := repoRoot(dir)
+	p, ok := policies.m[root]
+	if !ok {
+		var err error
+		if p, err = readPolicy(root); err != nil {
+			fatal(err)
+		}
+		policies.m[root] = p
+	}
+	policies.m[dir] = p
+	return p
+}
+
+// repoRoot returns the root of the module containing dir,
+// or outside modules, of its git repository, or failing that, dir.
+func repoRoot(dir string) string {
+	root := moduleRoot(dir)
+	if !exists(filepath.Join(root, "go.mod")) {
+		if r, err := gitRoot(dir); err == nil {
+			root = r
+		}
+	}
+	return root
+}
+
+// readPolicy reads the policy for the packages under root.
+func readPolicy(root string) (*policy, error) {
+	file := filepath.Join(root, policyFile)
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return &policy{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	p := &policy{file: rel(file)}
+	s := bufio.NewScanner(f)
+	for line := 1; s.Scan(); line++ {
+		text := strings.TrimSpace(s.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		switch {
+		case fields[0] == "max-factor" && len(fields) == 2:
+			n, err := strconv.Atoi(fields[1])
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("%s:%d: bad max-factor %q", p.file, line, fields[1])
+			}
+			p.maxFactor = n
+		case fields[0] == "opt-in" && len(fields) == 1:
+			p.optIn = true
+		case fields[0] == "allow" && len(fields) == 2:
+			p.allow = append(p.allow, filepath.Join(root, filepath.FromSlash(fields[1])))
+		case fields[0] == "style" && len(fields) == 2:
+			style, err := parseStyle(fields[1])
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %v", p.file, line, err)
+			}
+			p.style = style
+		default:
+			return nil, fmt.Errorf("%s:%d: bad setting %q; want max-factor n, opt-in, allow dir, or style s", p.file, line, text)
+		}
+	}
+	return p, s.Err()
+}
+
+// allows reports whether p allows rewriting the package in dir.
+func (p *policy) allows(dir string) bool {
+	if len(p.allow) == 0 {
+		return true
+	}
+	for _, a := range p.allow {
+		if within(dir, a) {
+			return true
+		}
+	}
+	return false
+}
+
+// key returns a string that changes when the settings in p do.
+func (p *policy) key() string {
+	return fmt.Sprint(p.maxFactor, p.optIn, p.allow, p.style)
+}
+
+// decide returns a function for unroll.Config.Decide and Limit that applies
+// p's factor cap and opt-in, or nil if p has neither.
+func (p *policy) decide() func(*ast.FuncDecl, ast.Stmt, int) int {
+	if p.maxFactor == 0 && !p.optIn {
+		return nil
+	}
+	return func(fn *ast.FuncDecl, s ast.Stmt, factor int) int {
+		if p.optIn && !optedIn(fn) {
+			return 0
+		}
+		if p.maxFactor != 0 {
+			factor = min(factor, p.maxFactor)
+		}
+		return factor
+	}
+}
+
+// optedIn reports whether fn's doc comment has optInPragma.
+func optedIn(fn *ast.FuncDecl) bool {
+	if fn.Doc == nil {
+		return false
+	}
+	for _, c := range fn.Doc.List {
+		if strings.TrimSpace(c.Text) == optInPragma {
+			return true
+		}
+	}
+	return false
+}
+
+// filePolicy returns the policy for the package containing f.
+func filePolicy(fset *token.FileSet, f *ast.File) *policy {
+	return policyFor(filepath.Dir(filePath(fset, f)))
+}
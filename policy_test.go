@@ -0,0 +1,120 @@
+package main
+
+import (
+	"go/ast"
+	"go/token"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/josharian/unrollbench/unroll"
+)
+
+func TestPolicyFor(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		policy string // contents of policyFile, if any
+		want   policy
+	}{
+		{"none", "", policy{}},
+		{"max-factor", "max-factor 4\n", policy{maxFactor: 4}},
+		{"opt-in", "# comment\n\nopt-in\n", policy{optIn: true}},
+		{"allow", "allow p\nallow q\n", policy{allow: []string{"p", "q"}}},
+		{"style", "style range\n", policy{style: loopStyle{name: unroll.StyleRange}}},
+	} {
+		root := testModule(t)
+		if tt.policy != "" {
+			if err := os.MkdirAll(filepath.Join(root, filepath.Dir(policyFile)), 0777); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(root, policyFile), []byte(tt.policy), 0666); err != nil {
+				t.Fatal(err)
+			}
+			tt.want.file = rel(filepath.Join(root, policyFile))
+		}
+		for i, a := range tt.want.allow {
+			tt.want.allow[i] = filepath.Join(root, a)
+		}
+		p := policyFor(filepath.Join(root, "p"))
+		if p.key() != tt.want.key() || p.file != tt.want.file {
+			t.Errorf("%s: policy %+v, want %+v", tt.name, *p, tt.want)
+		}
+		if p != policyFor(root) {
+			t.Errorf("%s: package and root have different policies", tt.name)
+		}
+	}
+}
+
+func TestReadPolicyErrors(t *testing.T) {
+	for _, text := range []string{
+		"max-factor\n",
+		"max-factor 0\n",
+		"max-factor x\n",
+		"opt-in yes\n",
+		"style fancy\n",
+		"unroll everything\n",
+	} {
+		root := t.TempDir()
+		if err := os.MkdirAll(filepath.Join(root, filepath.Dir(policyFile)), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(root, policyFile), []byte(text), 0666); err != nil {
+			t.Fatal(err)
+		}
+		if p, err := readPolicy(root); err == nil {
+			t.Errorf("readPolicy(%q) = %+v, want error", text, *p)
+		}
+	}
+}
+
+// The policy's opt-in and cap hold whatever factor it is given.
+func TestPolicyDecide(t *testing.T) {
+	root := testModule(t)
+	fset := token.NewFileSet()
+	f, err := parseFile(fset, filepath.Join(root, "p", "p_test.go"), []byte(`package p
+
+import "testing"
+
+//unrollbench:unroll
+func BenchmarkIn(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+	}
+}
+
+func BenchmarkOut(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+	}
+}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	in, out := f.Decls[1].(*ast.FuncDecl), f.Decls[2].(*ast.FuncDecl)
+	for _, tt := range []struct {
+		name    string
+		p       policy
+		fn      *ast.FuncDecl
+		factor  int
+		want    int
+		wantNil bool
+	}{
+		{name: "none", p: policy{}, wantNil: true},
+		{name: "under cap", p: policy{maxFactor: 8}, fn: out, factor: 4, want: 4},
+		{name: "over cap", p: policy{maxFactor: 8}, fn: out, factor: 12, want: 8},
+		{name: "opted in", p: policy{optIn: true}, fn: in, factor: 12, want: 12},
+		{name: "not opted in", p: policy{optIn: true}, fn: out, factor: 12, want: 0},
+		{name: "opted in, capped", p: policy{optIn: true, maxFactor: 8}, fn: in, factor: 12, want: 8},
+	} {
+		d := tt.p.decide()
+		if (d == nil) != tt.wantNil {
+			t.Errorf("%s: decide() == nil is %v, want %v", tt.name, d == nil, tt.wantNil)
+			continue
+		}
+		if d == nil {
+			continue
+		}
+		if got := d(tt.fn, tt.fn.Body.List[0], tt.factor); got != tt.want {
+			t.Errorf("%s: decide(%s, %d) = %d, want %d", tt.name, tt.fn.Name.Name, tt.factor, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"os"
+	"strings"
+
+	"github.com/josharian/unrollbench/unroll"
+)
+
+// prefilter is the comma-separated list of -prefilter markers,
+// strings without which a file is not worth parsing.
+var prefilter string
+
+func init() {
+	for _, c := range []*command{unrollCmd, rerollCmd, normalizeCmd, modernizeCmd, checkCmd, checkStyleCmd, estimateCmd, lintCmd, runCmd, rankCmd, testCmd, factorsCmd, tuneCmd, bisectCmd, asmCmd, inlineCmd, sizeCmd, surveyCmd} {
+		c.flags.StringVar(&prefilter, "prefilter", strings.Join(unroll.DefaultMarkers, ","), "parse only files containing at least one of the strings in comma-separated `list`, or with an empty list, all files")
+	}
+}
+
+// mayHaveBenchmarks reports whether src, the contents of a file,
+// passes -prefilter; see unroll.MayContain.
+func mayHaveBenchmarks(src []byte) bool {
+	if prefilter == "" {
+		return true
+	}
+	return unroll.MayContain(src, strings.Split(prefilter, ","))
+}
+
+// A candidate is a file that mayHaveBenchmarks.
+type candidate struct {
+	file string
+	src  []byte // its contents, if already read, for parseFile
+}
+
+// candidates returns the files that mayHaveBenchmarks, keeping those
+// that cannot be read for the caller to report.
+func candidates(files []string) []candidate {
+	var out []candidate
+	for _, file := range files {
+		if prefilter == "" {
+			out = append(out, candidate{file: file})
+			continue
+		}
+		src, err := os.ReadFile(file)
+		if err != nil || mayHaveBenchmarks(src) {
+			out = append(out, candidate{file, src})
+		}
+	}
+	return out
+}
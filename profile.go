@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/token"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/josharian/unrollbench/unroll"
+)
+
+// Flags for choosing loops using a CPU profile.
+var (
+	profileFile      string
+	profileThreshold float64
+)
+
+func init() {
+	unrollCmd.flags.StringVar(&profileFile, "profile", "", "unroll only loops whose control takes a large share of their benchmark's samples in the CPU profile `file`, as written by go test -cpuprofile")
+	unrollCmd.flags.Float64Var(&profileThreshold, "profile-threshold", 0.1, "with -profile, unroll loops whose control takes at least `fraction` of their benchmark's samples")
+}
+
+// A loopKey identifies a benchmark loop by the file and line of its for statement.
+type loopKey struct {
+	file string
+	line int
+}
+
+// profiledLoops holds the loops picked using -profile, if set.
+var profiledLoops map[loopKey]bool
+
+// A lineKey identifies a line of a function in a profile.
+// Files are identified by base name, since a profile
+// may come from a build in another directory.
+type lineKey struct {
+	fn   string // without its package path, such as BenchmarkX or BenchmarkX.func1
+	file string
+	line int
+}
+
+// A profile holds the CPU samples of a profile, in milliseconds.
+type profile struct {
+	flat map[lineKey]float64 // samples on each line
+	cum  map[string]float64  // samples in or under each function
+}
+
+// readProfile reads the CPU profile file using go tool pprof.
+func readProfile(file string) (*profile, error) {
+	p := &profile{flat: make(map[lineKey]float64), cum: make(map[string]float64)}
+	for _, lines := range []bool{false, true} {
+		args := []string{"tool", "pprof", "-top", "-nodecount=0", "-unit=ms"}
+		if lines {
+			args = append(args, "-lines")
+		}
+		out, err := exec.CommandContext(ctx, "go", append(args, file)...).Output()
+		if err != nil {
+			return nil, fmt.Errorf("go %v: %v", args, err)
+		}
+		s := bufio.NewScanner(bytes.NewReader(out))
+		for s.Scan() {
+			// flat flat% sum% cum cum% name [file:line] [(inline)]
+			f := strings.Fields(s.Text())
+			if len(f) < 6 || !strings.HasSuffix(f[1], "%") {
+				continue
+			}
+			flat, err1 := parseMs(f[0])
+			cum, err2 := parseMs(f[3])
+			if err1 != nil || err2 != nil {
+				continue
+			}
+			fn := profileFunc(f[5])
+			if !lines {
+				p.cum[fn] += cum
+				continue
+			}
+			if len(f) < 7 {
+				continue
+			}
+			file, line, ok := strings.Cut(f[6], ":")
+			n, err := strconv.Atoi(line)
+			if !ok || err != nil {
+				continue
+			}
+			p.flat[lineKey{fn, filepath.Base(file), n}] += flat
+		}
+	}
+	return p, nil
+}
+
+// parseMs parses a value printed by pprof -unit=ms.
+func parseMs(s string) (float64, error) {
+	return strconv.ParseFloat(strings.TrimSuffix(s, "ms"), 64)
+}
+
+// profileFunc returns the name of fn, a function in a profile,
+// without its package path.
+func profileFunc(fn string) string {
+	if i := strings.LastIndexByte(fn, '/'); i >= 0 {
+		fn = fn[i+1:]
+	}
+	_, name, _ := strings.Cut(fn, ".")
+	return name
+}
+
+// A profiledLoop is a benchmark loop and the share of its benchmark's
+// samples that land on its for statement: the increment, compare,
+// and branch that unrolling amortizes.
+type profiledLoop struct {
+	loopKey
+	fn    string
+	share float64
+}
+
+// pickProfiled finds the benchmark loops in pkgs in p, and reports
+// those whose share is at least profileThreshold, largest first.
+// It returns those loops.
+func pickProfiled(p *profile, pkgs []*build.Package) map[loopKey]bool {
+	var loops []profiledLoop
+	for _, file := range testFiles(pkgs...) {
+		fset := token.NewFileSet()
+		f, err := parseFile(fset, file, nil)
+		if err != nil {
+			fail(err)
+			continue
+		}
+		add := func(name string, body *ast.BlockStmt) {
+			total := p.cum[name]
+			if total == 0 {
+				return
+			}
+			for _, s := range body.List {
+				if ok, _, _ := unroll.IsBenchForLoop(s); !ok {
+					continue
+				}
+				line := fset.Position(s.Pos()).Line
+				share := p.flat[lineKey{name, filepath.Base(file), line}] / total
+				loops = append(loops, profiledLoop{loopKey{file, line}, name, share})
+			}
+		}
+		for name, body := range unroll.Bodies(f) {
+			add(name, body)
+		}
+	}
+	sort.SliceStable(loops, func(i, j int) bool {
+		if loops[i].share != loops[j].share {
+			return loops[i].share > loops[j].share
+		}
+		return loops[i].file < loops[j].file || loops[i].file == loops[j].file && loops[i].line < loops[j].line
+	})
+	picked := make(map[loopKey]bool)
+	for _, l := range loops {
+		if l.share < profileThreshold {
+			break
+		}
+		if len(picked) == 0 {
+			fmt.Println("Loops whose control takes the largest share of their benchmark's samples:")
+		}
+		fmt.Printf("\t%s:%d: %s: %.0f%%\n", rel(l.file), l.line, l.fn, l.share*100)
+		picked[l.loopKey] = true
+	}
+	if len(picked) == 0 {
+		fmt.Fprintf(os.Stderr, "No loops take at least %g of their benchmark's samples in %s\n", profileThreshold, profileFile)
+	}
+	return picked
+}
+
+// decideProfiled returns an unroll.Config.Decide func
+// that skips the loops in f not in profiledLoops.
+func decideProfiled(fset *token.FileSet, f *ast.File) func(*ast.FuncDecl, ast.Stmt, int) int {
+	file := filePath(fset, f)
+	return func(fn *ast.FuncDecl, s ast.Stmt, factor int) int {
+		if !profiledLoops[loopKey{file, fset.Position(s.Pos()).Line}] {
+			return 0
+		}
+		return factor
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+var noProgress bool
+
+// A progress reports progress through a list of packages on stderr.
+// On a terminal it keeps redrawing one status line;
+// otherwise it prints a line per package.
+type progress struct {
+	total, done int
+	start       time.Time
+	tty         bool
+	drawn       bool // a status line is on the terminal
+}
+
+// newProgress returns a progress for total packages,
+// or nil if there is nothing worth reporting.
+// All progress methods do nothing on a nil progress.
+func newProgress(total int) *progress {
+	if noProgress || total < 2 {
+		return nil
+	}
+	fi, err := os.Stderr.Stat()
+	tty := err == nil && fi.Mode()&os.ModeCharDevice != 0
+	return &progress{total: total, start: time.Now(), tty: tty}
+}
+
+// begin reports that work on pkg is starting.
+func (p *progress) begin(pkg string) {
+	if p == nil {
+		return
+	}
+	msg := fmt.Sprintf("[%d/%d] %s", p.done+1, p.total, pkg)
+	if p.done > 0 {
+		elapsed := time.Since(p.start)
+		eta := elapsed / time.Duration(p.done) * time.Duration(p.total-p.done)
+		msg += fmt.Sprintf(" (ETA %v)", eta.Round(time.Second))
+	}
+	if p.tty {
+		p.clear()
+		fmt.Fprint(os.Stderr, msg)
+		p.drawn = true
+		return
+	}
+	fmt.Fprintln(os.Stderr, msg)
+}
+
+// end reports that work on the current package is done.
+func (p *progress) end() {
+	if p == nil {
+		return
+	}
+	p.done++
+	if p.done == p.total {
+		p.clear()
+	}
+}
+
+// clear erases the status line, so that other output can be printed.
+func (p *progress) clear() {
+	if p == nil || !p.drawn {
+		return
+	}
+	fmt.Fprint(os.Stderr, "\r\033[K")
+	p.drawn = false
+}
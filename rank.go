@@ -0,0 +1,101 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"slices"
+	"text/tabwriter"
+
+	"github.com/josharian/unrollbench/bench"
+	"golang.org/x/perf/benchmath"
+)
+
+var rankCmd = newCommand("rank", "[-threshold percent] [-bench regexp] [packages]", "list the benchmarks whose times change most when unrolled, which loop overhead dominates", runRank)
+
+var rankThreshold float64
+
+func init() {
+	rankCmd.flags.Float64Var(&rankThreshold, "threshold", 5, "list only benchmarks whose ns/op changes by more than `percent`")
+}
+
+// runRank compares the benchmarks before and after unrolling, as run does,
+// and lists those whose ns/op changed significantly and by more than
+// -threshold, largest change first. Unrolling removes only loop overhead,
+// so these are the benchmarks that measure mostly their loops.
+func runRank(c *command, args []string) {
+	if len(args) < 1 {
+		c.flags.Usage()
+	}
+	if factor < 2 {
+		fatal("-factor must be at least 2")
+	}
+	if rankThreshold < 0 {
+		fatal("-threshold must not be negative")
+	}
+	pkgs := loadPackages(args)
+	openOutputs(c)
+	old, new, _ := beforeAfter(pkgs, args)
+	defer os.Remove(overlayFile)
+
+	fmt.Println()
+	rank(os.Stdout, old, new)
+}
+
+// A rankedBench is a benchmark's change in ns/op after unrolling.
+type rankedBench struct {
+	key      bench.Key
+	old, new float64 // medians
+	delta    float64 // percent
+	p        float64
+}
+
+// rank prints to w the benchmarks whose ns/op changed significantly,
+// at level -alpha, by more than -threshold percent, sorted by the size
+// of the change. Each -cpu setting's results are ranked together,
+// since their names differ.
+func rank(w io.Writer, old, new []*bench.Result) {
+	keys, before := bench.Group(old, "ns/op")
+	_, after := bench.Group(new, "ns/op")
+	var ranked []rankedBench
+	compared := 0
+	for _, k := range keys {
+		if len(after[k]) == 0 {
+			continue
+		}
+		compared++
+		b := benchmath.NewSample(before[k], thresholds())
+		a := benchmath.NewSample(after[k], thresholds())
+		c := benchmath.AssumeNothing.Compare(b, a)
+		r := rankedBench{
+			key: k,
+			old: benchmath.AssumeNothing.Summary(b, confidence).Center,
+			new: benchmath.AssumeNothing.Summary(a, confidence).Center,
+			p:   c.P,
+		}
+		if r.old == 0 || c.P >= c.Alpha {
+			continue
+		}
+		r.delta = (r.new - r.old) / r.old * 100
+		if math.Abs(r.delta) > rankThreshold {
+			ranked = append(ranked, r)
+		}
+	}
+	slices.SortStableFunc(ranked, func(x, y rankedBench) int {
+		return cmp.Compare(math.Abs(y.delta), math.Abs(x.delta))
+	})
+
+	if len(ranked) == 0 {
+		fmt.Fprintf(w, "None of the %d benchmarks compared changed significantly by more than %v%%.\n", compared, rankThreshold)
+		return
+	}
+	fmt.Fprintf(w, "%d of the %d benchmarks compared changed significantly by more than %v%% when unrolled %s:\n\n", len(ranked), compared, rankThreshold, factorDesc())
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "rank\tname\told time/op\tnew time/op\tdelta\tp")
+	for i, r := range ranked {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%.3f\n", i+1, r.key, formatValue(r.old, "ns/op"), formatValue(r.new, "ns/op"), formatDelta(r.old, r.new), r.p)
+	}
+	tw.Flush()
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/build"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/josharian/unrollbench/bench"
+)
+
+var remoteHosts string
+
+func init() {
+	runCmd.flags.StringVar(&remoteHosts, "hosts", "", "run the comparison on each SSH host in comma-separated `list`, instead of locally, and report each host's results")
+}
+
+// A remoteHost is an SSH host that runs benchmarks for -hosts.
+type remoteHost struct {
+	name         string
+	goos, goarch string
+	dir          string // temporary directory on the host
+}
+
+// unameArch maps uname -m to GOARCH.
+var unameArch = map[string]string{
+	"x86_64":  "amd64",
+	"amd64":   "amd64",
+	"i386":    "386",
+	"i686":    "386",
+	"aarch64": "arm64",
+	"arm64":   "arm64",
+	"armv7l":  "arm",
+	"ppc64le": "ppc64le",
+	"s390x":   "s390x",
+	"riscv64": "riscv64",
+}
+
+// runRemote compares the benchmarks in pkgs before and after unrolling
+// on each of -hosts, concurrently, and prints each host's comparison.
+// The test binaries are built here, for each host's GOOS and GOARCH,
+// and copied to the host with scp, so the hosts need only ssh;
+// benchmarks that read files from their package directory will fail.
+func runRemote(pkgs []*build.Package) {
+	var hosts []*remoteHost
+	for _, h := range strings.Split(remoteHosts, ",") {
+		if h = strings.TrimSpace(h); h != "" {
+			hosts = append(hosts, &remoteHost{name: h})
+		}
+	}
+	if len(hosts) == 0 {
+		fatal("-hosts is empty")
+	}
+
+	tmp, err := os.MkdirTemp("", "unrollbench-remote-")
+	if err != nil {
+		fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	overlayFile = filepath.Join(tmp, "overlay.json")
+	historyChanges(rewrite(pkgs, false, unrollFile))
+
+	// Build the test binaries once per platform.
+	built := make(map[string]string) // local directory by GOOS/GOARCH
+	for _, h := range hosts {
+		fmt.Printf("Checking %s\n", h.name)
+		out, err := ssh(h.name, "uname -sm && mktemp -d")
+		f := strings.Fields(string(out))
+		if err != nil || len(f) != 3 || unameArch[f[1]] == "" {
+			fatal(fmt.Sprintf("%s: cannot determine platform: %v %q", h.name, err, out))
+		}
+		h.goos, h.goarch, h.dir = strings.ToLower(f[0]), unameArch[f[1]], f[2]
+		platform := h.goos + "/" + h.goarch
+		if built[platform] != "" {
+			continue
+		}
+		dir := filepath.Join(tmp, h.goos+"_"+h.goarch)
+		if err := os.Mkdir(dir, 0777); err != nil {
+			fatal(err)
+		}
+		fmt.Printf("Building tests for %s\n", platform)
+		for i, pkg := range pkgs {
+			if len(testFiles(pkg)) == 0 {
+				continue
+			}
+			for _, run := range []string{"original", "unrolled"} {
+				args := append([]string{"test", "-c", "-o", filepath.Join(dir, remoteBinary(i, run))}, buildFlags()...)
+				if run == "unrolled" {
+					args = append(args, "-overlay="+overlayFile)
+				}
+				cmd := exec.CommandContext(ctx, "go", append(args, pkg.ImportPath)...)
+				cmd.Env = append(os.Environ(), "GOOS="+h.goos, "GOARCH="+h.goarch)
+				if out, err := cmd.CombinedOutput(); err != nil {
+					os.Stdout.Write(out)
+					fatal(fmt.Sprintf("go %v for %s: %v", args, platform, err))
+				}
+			}
+		}
+		built[platform] = dir
+	}
+
+	// Run the hosts concurrently; each runs its benchmarks one at a time.
+	old := make([][]*bench.Result, len(hosts))
+	new := make([][]*bench.Result, len(hosts))
+	errs := make([]error, len(hosts))
+	var wg sync.WaitGroup
+	for i, h := range hosts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			old[i], new[i], errs[i] = h.bench(pkgs, built[h.goos+"/"+h.goarch])
+		}()
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		fatal("interrupted")
+	}
+
+	for i, h := range hosts {
+		fmt.Printf("\n%s (%s/%s):\n", h.name, h.goos, h.goarch)
+		if errs[i] != nil {
+			fail(fmt.Errorf("%s: %v", h.name, errs[i]))
+			continue
+		}
+		compare(os.Stdout, old[i], new[i])
+		writeBenchOut(old[i], "original")
+		writeBenchOut(new[i], "unrolled")
+	}
+	if len(failures) > 0 {
+		exit(1)
+	}
+}
+
+// remoteBinary is the name of the test binary for the ith package and run.
+func remoteBinary(i int, run string) string {
+	return fmt.Sprintf("p%d.%s.test", i, run)
+}
+
+// bench copies the test binaries in dir to h, runs them,
+// removes them, and returns the results before and after unrolling,
+// labeled with a "host" configuration line.
+func (h *remoteHost) bench(pkgs []*build.Package, dir string) (old, new []*bench.Result, err error) {
+	defer ssh(h.name, "rm -rf "+shellQuote(h.dir))
+	files, err := filepath.Glob(filepath.Join(dir, "*.test"))
+	if err != nil {
+		return nil, nil, err
+	}
+	args := append(files, h.name+":"+h.dir+"/")
+	if out, err := exec.CommandContext(ctx, "scp", append([]string{"-q"}, args...)...).CombinedOutput(); err != nil {
+		return nil, nil, fmt.Errorf("scp: %v\n%s", err, out)
+	}
+	flags := []string{"-test.run=^$", "-test.bench=" + benchRegexp, "-test.count=" + strconv.Itoa(benchCount)}
+	if benchTime != "" {
+		flags = append(flags, "-test.benchtime="+benchTime)
+	}
+	if benchCPU != "" {
+		flags = append(flags, "-test.cpu="+benchCPU)
+	}
+	if verifyAllocs {
+		flags = append(flags, "-test.benchmem")
+	}
+	for i, pkg := range pkgs {
+		if len(testFiles(pkg)) == 0 {
+			continue
+		}
+		for _, run := range []string{"original", "unrolled"} {
+			env := h.environment()
+			cmd := "cd " + shellQuote(h.dir) + " && ./" + remoteBinary(i, run)
+			for _, f := range flags {
+				cmd += " " + shellQuote(f)
+			}
+			out, err := ssh(h.name, cmd)
+			if err != nil {
+				return nil, nil, fmt.Errorf("%s %s: %v\n%s", pkg.ImportPath, run, err, out)
+			}
+			results, err := bench.Parse(bytes.NewReader(out))
+			if err != nil {
+				return nil, nil, err
+			}
+			for _, r := range results {
+				r.Pkg = pkg.ImportPath
+				addEnvironment(r, env)
+			}
+			if run == "original" {
+				old = append(old, results...)
+			} else {
+				new = append(new, results...)
+			}
+		}
+	}
+	return old, new, nil
+}
+
+// environment is like the package-level environment, but for h,
+// which runs test binaries built with the local Go toolchain.
+func (h *remoteHost) environment() map[string]string {
+	env := map[string]string{
+		"host":      h.name,
+		"goversion": goVersion(),
+		"goos":      h.goos,
+		"goarch":    h.goarch,
+	}
+	out, _ := ssh(h.name, "cat "+governorFile+" 2>/dev/null; echo; cat "+loadavgFile+" 2>/dev/null")
+	governor, loadavg, _ := strings.Cut(string(out), "\n")
+	env["governor"] = strings.TrimSpace(governor)
+	env["loadavg"] = loadAverage(loadavg)
+	return env
+}
+
+// ssh runs cmd on host and returns its output.
+// An error includes what cmd printed to standard error.
+func ssh(host, cmd string) ([]byte, error) {
+	out, err := exec.CommandContext(ctx, "ssh", "-o", "BatchMode=yes", host, cmd).Output()
+	if ee, ok := err.(*exec.ExitError); ok {
+		err = fmt.Errorf("ssh %s: %v\n%s", host, err, ee.Stderr)
+	}
+	return out, err
+}
+
+// shellQuote quotes s for a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/josharian/unrollbench/bench"
+)
+
+var reportCmd = newCommand("report", "-history file [-bench regexp]", "show how each benchmark's unrolling delta changed across the runs in a history", runReport)
+
+// regressionPoints is the change in a benchmark's unrolling delta,
+// in percentage points, that report highlights.
+var regressionPoints float64
+
+func init() {
+	reportCmd.flags.StringVar(&historyFile, "history", "", "read runs from the SQLite database `file`, recorded by run -history")
+	reportCmd.flags.StringVar(&benchRegexp, "bench", ".", "report only benchmarks matching `regexp`")
+	reportCmd.flags.Float64Var(&regressionPoints, "threshold", 5, "highlight a delta that moves by more than `points` percentage points from the run before")
+}
+
+// A historyPoint is a benchmark's mean ns/op, before and after unrolling,
+// in one recorded run.
+type historyPoint struct {
+	time, commit, goVersion, machine string
+	old, new                         float64
+}
+
+func runReport(c *command, args []string) {
+	if len(args) > 0 || historyFile == "" {
+		c.flags.Usage()
+	}
+	if _, err := exec.LookPath("sqlite3"); err != nil {
+		fatal(fmt.Sprintf("-history: %v", err))
+	}
+	if _, err := os.Stat(historyFile); err != nil {
+		fatal(err)
+	}
+	re, err := regexp.Compile(benchRegexp)
+	if err != nil {
+		fatal(fmt.Sprintf("-bench: %v", err))
+	}
+
+	rows := sqliteQuery(`
+SELECT r.id, r.time, r.commit_hash, r.go_version, r.machine, x.pkg, x.name, x.label, avg(x.value)
+FROM runs r JOIN results x ON x.run = r.id
+WHERE x.unit = 'ns/op' AND x.label IN ('original', 'unrolled')
+GROUP BY r.id, x.pkg, x.name, x.label
+ORDER BY r.time, r.id;`)
+	var keys []bench.Key
+	points := make(map[bench.Key][]*historyPoint)
+	last := make(map[bench.Key]string) // id of the run of the last point
+	for _, row := range rows {
+		if len(row) != 9 {
+			fatal(fmt.Sprintf("-history: unexpected row %q", row))
+		}
+		k := bench.Key{Pkg: row[5], Name: row[6]}
+		if !re.MatchString(k.Name) {
+			continue
+		}
+		v, err := strconv.ParseFloat(row[8], 64)
+		if err != nil {
+			fatal(fmt.Sprintf("-history: %v", err))
+		}
+		if points[k] == nil {
+			keys = append(keys, k)
+		}
+		if last[k] != row[0] {
+			last[k] = row[0]
+			commit, dirty := strings.CutSuffix(row[2], "-dirty")
+			if len(commit) > 12 {
+				commit = commit[:12]
+			}
+			if dirty {
+				commit += "-dirty"
+			}
+			p := &historyPoint{time: row[1], commit: commit, goVersion: row[3], machine: row[4], old: math.NaN(), new: math.NaN()}
+			points[k] = append(points[k], p)
+		}
+		p := points[k][len(points[k])-1]
+		if row[7] == "original" {
+			p.old = v
+		} else {
+			p.new = v
+		}
+	}
+	if len(keys) == 0 {
+		fmt.Println("No recorded runs of matching benchmarks.")
+		return
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	for i, k := range keys {
+		if i > 0 {
+			fmt.Fprintln(tw)
+		}
+		fmt.Fprintf(tw, "%s\n", k)
+		fmt.Fprintf(tw, "time\tcommit\tgo\tmachine\toriginal\tunrolled\tdelta\t\n")
+		prev := math.NaN()
+		for _, p := range points[k] {
+			d := (p.new - p.old) / p.old * 100
+			note := ""
+			// A delta closer to zero means that unrolling helps less:
+			// the benchmark has become less sensitive to loop overhead.
+			if moved := d - prev; math.Abs(moved) > regressionPoints {
+				note = fmt.Sprintf("%+.1f points", moved)
+				if moved > 0 {
+					note += " (regression)"
+				}
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", p.time, p.commit, p.goVersion, p.machine,
+				formatValue(p.old, "ns/op"), formatValue(p.new, "ns/op"), formatDelta(p.old, p.new), note)
+			if !math.IsNaN(d) {
+				prev = d
+			}
+		}
+	}
+	tw.Flush()
+}
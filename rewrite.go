@@ -0,0 +1,434 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/printer"
+	"go/token"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+
+	"github.com/josharian/unrollbench/unroll"
+	"golang.org/x/tools/imports"
+)
+
+// A change describes the files written for one rewritten file.
+type change struct {
+	pkg   *build.Package
+	file  string   // file rewritten
+	files []string // files written
+	funcs []string // benchmarks rewritten
+}
+
+// rewrite applies fn to the test files in pkgs and writes the results.
+// When files are rewritten in place, it records the rewritten loops
+// for revert if record is set, and otherwise forgets the files' records,
+// which are now stale.
+// It returns the changes made by fn.
+//
+// Files are read, parsed, and rewritten concurrently, up to -j at a time,
+// and then written in order. A file that cannot be rewritten is reported
+// and skipped, and rewrite exits, reporting all failures,
+// once the other files are done. If interrupted, rewrite stops
+// before writing the next file, records the files already written,
+// and exits. Packages that their policyFile does not allow
+// to be rewritten are skipped.
+func rewrite(pkgs []*build.Package, record bool, fn func(*token.FileSet, *ast.File) bool) []change {
+	var changes []change
+	if outDir != "" && overlayFile != "" {
+		fatal("-o and -overlay are mutually exclusive")
+	}
+	var overlay *overlayJSON
+	if overlayFile != "" {
+		overlay = &overlayJSON{Replace: make(map[string]string)}
+	}
+	inPlace := outDir == "" && overlay == nil && buildTag == "" && archFactors == nil
+	var (
+		inc  *incrementalDB
+		opts = rewriteOptions(fn)
+	)
+	if incremental {
+		if !inPlace || interactive {
+			fatal("-incremental only applies when rewriting files in place without -i")
+		}
+		var err error
+		if inc, err = loadIncremental(); err != nil {
+			fatal(err)
+		}
+	}
+	n := jobs
+	if interactive {
+		// One question at a time.
+		n = 1
+	}
+	sem := make(chan bool, max(n, 1))
+	failed := len(failures)
+	prog := newProgress(len(pkgs))
+	for _, pkg := range pkgs {
+		if ctx.Err() != nil {
+			break
+		}
+		prog.begin(pkg.ImportPath)
+		if p := policyFor(pkg.Dir); !p.allows(pkg.Dir) {
+			prog.clear()
+			skip(rel(pkg.Dir), "outside the directories allowed by "+p.file)
+			prog.end()
+			continue
+		}
+		dir := pkg.Dir
+		if outDir != "" {
+			dir = filepath.Join(outDir, filepath.FromSlash(pkg.ImportPath))
+			if err := copyDir(dir, pkg.Dir); err != nil {
+				fatal(err)
+			}
+		}
+		var st pkgState
+		if inPlace {
+			var err error
+			if st, err = loadState(pkg.Dir); err != nil {
+				fatal(err)
+			}
+		}
+		files := testFiles(pkg)
+		results := make([]chan *fileResult, len(files))
+		for i, file := range files {
+			results[i] = make(chan *fileResult, 1)
+			go func() {
+				sem <- true
+				if ctx.Err() != nil {
+					results[i] <- &fileResult{file: file, skip: true}
+				} else {
+					results[i] <- rewriteFile(file, fn, inPlace, inc, opts)
+				}
+				<-sem
+			}()
+		}
+		for i, file := range files {
+			r := <-results[i]
+			if ctx.Err() != nil {
+				// Keep what was written, and record it below.
+				break
+			}
+			if r.skip {
+				if r.why != "" {
+					prog.clear()
+					skip(rel(file), r.why)
+				}
+				continue
+			}
+			prog.clear()
+			fmt.Println("Processing", rel(file))
+			ch, err := r.write(pkg, dir, st, overlay, record)
+			if err != nil {
+				fail(err)
+				continue
+			}
+			if r.ent.Changed {
+				changes = append(changes, ch)
+			}
+			if inc != nil {
+				data := r.src // unchanged files are not written
+				if r.ent.Changed {
+					data = r.ent.Out
+				}
+				inc.note(file, r.opts, data)
+			}
+		}
+		if inPlace {
+			if err := st.save(pkg.Dir); err != nil {
+				fatal(err)
+			}
+		}
+		prog.end()
+	}
+	if overlay != nil {
+		if err := overlay.write(overlayFile); err != nil {
+			fatal(err)
+		}
+	}
+	if inc != nil {
+		if err := inc.save(); err != nil {
+			fatal(err)
+		}
+	}
+	if ctx.Err() != nil {
+		fatal("interrupted; the files rewritten so far were recorded for revert")
+	}
+	if len(failures) > failed {
+		// Don't go on to use a partial rewrite.
+		exit(1)
+	}
+	return changes
+}
+
+// A fileResult is a file rewritten in memory, ready to be written.
+type fileResult struct {
+	file string
+	src  []byte
+	mode os.FileMode
+	ent  rewriteEntry
+	opts string // rewrite options, for -incremental
+	skip bool   // not rewritten, such as when unchanged for -incremental
+	why  string // why skipped, if worth reporting
+	err  error  // reported when writing
+
+	// The rewritten syntax, unless ent came from the cache.
+	fset   *token.FileSet
+	f      *ast.File
+	before map[string][]ast.Stmt
+}
+
+// rewriteFile reads file and applies fn to it.
+// It is safe to call concurrently, as long as fn is.
+func rewriteFile(file string, fn func(*token.FileSet, *ast.File) bool, inPlace bool, inc *incrementalDB, opts string) *fileResult {
+	// The file's policy changes what fn does.
+	r := &fileResult{file: file, opts: opts + " " + policyFor(filepath.Dir(file)).key()}
+	var err error
+	if r.src, err = os.ReadFile(file); err != nil {
+		r.err = err
+		return r
+	}
+	if inc != nil && inc.unchanged(file, r.opts, r.src) {
+		r.skip = true
+		return r
+	}
+	if !mayHaveBenchmarks(r.src) {
+		r.skip = true
+		return r
+	}
+	fi, err := os.Stat(file)
+	if err != nil {
+		r.err = err
+		return r
+	}
+	r.mode = fi.Mode()
+	cgo := usesCgo(file, r.src)
+	if cgo && !rewriteCgo {
+		r.skip = true
+		r.why = `imports "C"; use -cgo to rewrite it anyway`
+		return r
+	}
+	key := rewriteKey(fn, file, r.src)
+	// A file changed in place needs its syntax to record the change,
+	// and will not have the same contents next time anyway.
+	if key != "" && cacheGet(key, &r.ent) && !(inPlace && r.ent.Changed) {
+		return r
+	}
+	r.fset = token.NewFileSet()
+	// TODO: avoid stripping build tags
+	r.f, err = parseFile(r.fset, file, r.src)
+	if err != nil {
+		r.err = err
+		return r
+	}
+	r.before = benchStmts(r.f)
+	r.ent.Changed = fn(r.fset, r.f)
+	var buf bytes.Buffer
+	if err := printer.Fprint(&buf, r.fset, r.f); err != nil {
+		r.err = err
+		return r
+	}
+	r.ent.Out = buf.Bytes()
+	if r.ent.Changed {
+		out := r.ent.Out
+		if r.ent.Out, err = fixImports(file, out); err != nil {
+			r.err = diagnose(rel(file), out, err, true)
+			return r
+		}
+	}
+	if cgo {
+		if r.ent.Out, err = keepPreamble(r.src, r.ent.Out); err != nil {
+			r.err = fmt.Errorf("%s: %v", rel(file), err)
+			return r
+		}
+	}
+	if copyComments && r.ent.Changed {
+		if r.ent.Out, err = unroll.CopyComments(r.ent.Out); err != nil {
+			r.err = diagnose(rel(file), r.ent.Out, err, true)
+			return r
+		}
+	}
+	if r.ent.Changed {
+		if r.ent.Out, err = unroll.Checksums(r.ent.Out); err != nil {
+			r.err = diagnose(rel(file), r.ent.Out, err, true)
+			return r
+		}
+	}
+	if lineDirs && r.ent.Changed {
+		if r.ent.Out, err = unroll.LineDirectives(filepath.Base(file), r.src, r.ent.Out); err != nil {
+			r.err = diagnose(rel(file), r.ent.Out, err, true)
+			return r
+		}
+	}
+	if r.ent.Changed {
+		if r.err = keepsEdits(file, r.src, r.ent.Out); r.err != nil {
+			return r
+		}
+	}
+	r.ent.Funcs = changedFuncs(r.before, r.f)
+	if key != "" {
+		cachePut(key, r.ent)
+	}
+	return r
+}
+
+// write records r in st, if rewriting in place,
+// and writes its outputs to dir or overlay.
+func (r *fileResult) write(pkg *build.Package, dir string, st pkgState, overlay *overlayJSON, record bool) (change, error) {
+	ch := change{pkg: pkg, file: r.file, funcs: r.ent.Funcs}
+	if r.err != nil {
+		return ch, r.err
+	}
+	file, src := r.file, r.src
+	if st != nil && r.ent.Changed {
+		name := filepath.Base(file)
+		if record {
+			fs := st[name]
+			if fs == nil {
+				fs = &fileState{Before: hash(src)}
+				st[name] = fs
+			}
+			if err := fs.record(r.before, r.fset, r.f, src, r.ent.Out); err != nil {
+				return ch, diagnose(rel(file), r.ent.Out, err, true)
+			}
+		} else {
+			delete(st, name)
+		}
+	}
+	if !r.ent.Changed {
+		// The printed file may not even be gofmt'ed; leave it alone.
+		return ch, nil
+	}
+	outputs := []output{{file, r.ent.Out}}
+	var err error
+	switch {
+	case buildTag != "":
+		if outputs, err = tagged(buildTag, file, src, r.ent.Out); err != nil {
+			return ch, err
+		}
+	case archFactors != nil:
+		if outputs, err = archOutputs(file, src); err != nil {
+			return ch, err
+		}
+	}
+
+	for _, o := range outputs {
+		dst := filepath.Join(dir, filepath.Base(o.file))
+		if overlay != nil {
+			dst = overlay.add(o.file)
+		}
+		old, err := os.ReadFile(dst)
+		if err == nil && bytes.Equal(old, o.data) {
+			// Leave unchanged files alone, to preserve their mtimes for -watch.
+			continue
+		}
+		if backup {
+			if err := backupFile(dst); err != nil {
+				return ch, err
+			}
+		}
+		if err := os.WriteFile(dst, o.data, r.mode); err != nil {
+			return ch, err
+		}
+		ch.files = append(ch.files, dst)
+		if overlay == nil {
+			if err := audit(pkg, dst, ch.funcs, old, o.data); err != nil {
+				return ch, err
+			}
+		}
+	}
+	return ch, nil
+}
+
+// fixImports returns src, the rewritten contents of file, with imports
+// added for any packages the rewrite started to use, unused ones removed,
+// and the import block sorted, as goimports would.
+func fixImports(file string, src []byte) ([]byte, error) {
+	return imports.Process(file, src, &imports.Options{Comments: true, TabIndent: true, TabWidth: 8})
+}
+
+// changedFuncs returns the names of the benchmarks in f
+// whose statements are not those in before, which was
+// recorded by benchStmts before f was rewritten.
+func changedFuncs(before map[string][]ast.Stmt, f *ast.File) []string {
+	var names []string
+	for name, after := range benchStmts(f) {
+		if !slices.Equal(before[name], after) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// An output is the new contents of a file.
+type output struct {
+	file string // path to the file in its package
+	data []byte
+}
+
+// backupFile copies file, if it exists, to its backup location.
+// An existing backup is left alone, so that the backup
+// holds the contents from before unrollbench first touched file.
+func backupFile(file string) error {
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	dst := file + backupSuffix
+	if backupDir != "" {
+		abs, err := filepath.Abs(dst)
+		if err != nil {
+			return err
+		}
+		dst = filepath.Join(backupDir, abs)
+		if err := os.MkdirAll(filepath.Dir(dst), 0777); err != nil {
+			return err
+		}
+	}
+	if _, err := os.Stat(dst); err == nil {
+		return nil
+	}
+	return os.WriteFile(dst, data, 0666)
+}
+
+// copyDir copies the regular files in src to dst, creating dst if needed.
+// It does not copy subdirectories.
+func copyDir(dst, src string) error {
+	if err := os.MkdirAll(dst, 0777); err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if !e.Type().IsRegular() {
+			continue
+		}
+		fi, err := e.Info()
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(filepath.Join(src, e.Name()))
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(dst, e.Name()), data, fi.Mode()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func exists(file string) bool {
+	_, err := os.Stat(file)
+	return err == nil
+}
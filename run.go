@@ -0,0 +1,478 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/build"
+	"io"
+	"maps"
+	"math"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/josharian/unrollbench/bench"
+	"golang.org/x/perf/benchmath"
+)
+
+var runCmd = newCommand("run", "[-bench regexp] [packages]", "compare benchmarks before and after unrolling", runRun)
+
+// Flags for running benchmarks.
+var (
+	benchRegexp  string
+	benchCount   int
+	benchTime    string
+	benchCPU     string
+	jsonFile     string
+	benchOutFile string
+	alpha        float64
+	verifyAllocs bool
+)
+
+func init() {
+	for _, c := range []*command{runCmd, rankCmd, factorsCmd, tuneCmd, bisectCmd} {
+		c.flags.StringVar(&benchRegexp, "bench", ".", "run only benchmarks matching `regexp`")
+		c.flags.IntVar(&benchCount, "count", 5, "run each benchmark `n` times, before and after")
+		c.flags.StringVar(&benchTime, "benchtime", "", "run each benchmark for duration `d`, as in go test -benchtime")
+		c.flags.StringVar(&benchCPU, "cpu", "", "run each benchmark with each GOMAXPROCS in `list`, as in go test -cpu")
+		c.flags.StringVar(&jsonFile, "json", "", "stream results and failures as JSON lines to `file` (- for standard output) as they arrive")
+		c.flags.StringVar(&benchOutFile, "bench-out", "", "also write all results to `file` in the Go benchmark format, labeled by an unroll configuration line, for benchstat")
+		c.flags.Float64Var(&alpha, "alpha", 0.05, "consider a change significant only if a Mann-Whitney U-test gives it a p-value below `level`")
+	}
+	calibrateCmd.flags.IntVar(&benchCount, "count", 5, "run each benchmark `n` times")
+	calibrateCmd.flags.StringVar(&benchTime, "benchtime", "", "run each benchmark for duration `d`, as in go test -benchtime")
+	runCmd.flags.BoolVar(&verifyAllocs, "verify-allocs", false, "check that unrolling does not change any benchmark's B/op or allocs/op")
+}
+
+func runRun(c *command, args []string) {
+	if len(args) < 1 {
+		c.flags.Usage()
+	}
+	if factor < 2 {
+		fatal("-factor must be at least 2")
+	}
+	if perfStat {
+		checkPerf()
+	}
+	pkgs := loadPackages(args)
+	openOutputs(c)
+	if remoteHosts != "" {
+		runRemote(pkgs)
+		return
+	}
+
+	old, new, changes := beforeAfter(pkgs, args)
+	defer os.Remove(overlayFile)
+
+	fmt.Println()
+	compare(os.Stdout, old, new)
+	writeMarkdown(changes, old, new)
+	if perfStat {
+		fmt.Println("\nCounting events with perf stat")
+		comparePerf(os.Stdout, old, overlayFile)
+	}
+	if verifyAllocs && !sameAllocs(os.Stdout, old, new) {
+		os.Exit(1)
+	}
+}
+
+// beforeAfter runs the benchmarks in pkgs, named by args, as they are
+// and then unrolled through an overlay, which it leaves in overlayFile
+// for the caller to remove, and returns both sets of results.
+func beforeAfter(pkgs []*build.Package, args []string) (old, new []*bench.Result, changes []change) {
+	fmt.Println("Running original benchmarks")
+	old = goTestBench(args, "", "original")
+
+	tmp, err := os.CreateTemp("", "unrollbench-*.json")
+	if err != nil {
+		fatal(err)
+	}
+	tmp.Close()
+	overlayFile = tmp.Name()
+	changes = rewrite(pkgs, false, unrollFile)
+	historyChanges(changes)
+
+	fmt.Println("Running unrolled benchmarks")
+	new = goTestBench(args, overlayFile, "unrolled")
+	return old, new, changes
+}
+
+// sameAllocs reports whether each benchmark allocates the same
+// before and after unrolling, reporting those that do not to w.
+// A difference usually means that unrolling changed what the benchmark does,
+// for example by enabling or disabling an optimization.
+func sameAllocs(w io.Writer, old, new []*bench.Result) bool {
+	same := true
+	for _, unit := range []string{"B/op", "allocs/op"} {
+		keys, before := bench.Group(old, unit)
+		_, after := bench.Group(new, unit)
+		for _, k := range keys {
+			b, a := before[k], after[k]
+			if len(a) == 0 || b.Mean() == a.Mean() {
+				continue
+			}
+			if same {
+				fmt.Fprintln(w)
+			}
+			same = false
+			fmt.Fprintf(w, "%s: %s changed from %s to %s\n", k, unit, formatValue(b.Mean(), unit), formatValue(a.Mean(), unit))
+		}
+	}
+	return same
+}
+
+// goTestBench runs the benchmarks in pkgs, with overlay if not empty,
+// and returns the results. It reports progress as results arrive,
+// streams them to -json, and writes them to -bench-out, labeled with run.
+func goTestBench(pkgs []string, overlay, run string) []*bench.Result {
+	args := []string{"test", "-json", "-run=^$", "-bench=" + benchRegexp, "-count=" + strconv.Itoa(benchCount)}
+	if benchTime != "" {
+		args = append(args, "-benchtime="+benchTime)
+	}
+	if benchCPU != "" {
+		args = append(args, "-cpu="+benchCPU)
+	}
+	if verifyAllocs {
+		args = append(args, "-benchmem")
+	}
+	args = append(args, buildFlags()...)
+	if overlay != "" {
+		args = append(args, "-overlay="+overlay)
+	}
+	args = append(args, pkgs...)
+	key := resultsKey(args, pkgs, overlay)
+	var results []*bench.Result
+	if key != "" && cacheGet(key, &results) {
+		fmt.Fprintln(os.Stderr, "\tusing cached results")
+		writeBenchOut(results, run)
+		historyResults(results, run)
+		return results
+	}
+	env := environment()
+	cmd := benchCommand(args)
+	cmd.Stderr = os.Stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		fatal(err)
+	}
+	var (
+		output  = make(map[string][]string)      // by package, to show on failure
+		partial = make(map[bench.Key]string)     // incomplete output lines
+		streams = make(map[string]*bench.Stream) // by package, for configuration lines
+		failed  bool
+	)
+	d := json.NewDecoder(stdout)
+	for {
+		var e testEvent
+		if err := d.Decode(&e); err == io.EOF {
+			break
+		} else if err != nil {
+			fatal(fmt.Sprintf("go %v: %v", args, err))
+		}
+		switch e.Action {
+		case "output", "build-output":
+			pkg := e.Package
+			if pkg == "" {
+				pkg = e.ImportPath
+			}
+			output[pkg] = append(output[pkg], e.Output)
+			// A benchmark prints its name before running
+			// and its result after, so lines may arrive in pieces.
+			k := bench.Key{Pkg: pkg, Name: e.Test}
+			line := partial[k] + e.Output
+			if !strings.HasSuffix(line, "\n") {
+				partial[k] = line
+				continue
+			}
+			delete(partial, k)
+			if streams[pkg] == nil {
+				streams[pkg] = new(bench.Stream)
+			}
+			r := streams[pkg].Line(line)
+			if r == nil {
+				continue
+			}
+			r.Pkg = e.Package
+			addEnvironment(r, env)
+			results = append(results, r)
+			fmt.Fprintf(os.Stderr, "\t%s\t%s\n", bench.Key{Pkg: r.Pkg, Name: r.Name}, formatValue(r.Values["ns/op"], "ns/op"))
+			emit(streamEvent{Run: run, Action: "result", Package: r.Pkg, Test: r.Name, Result: r})
+		case "fail", "build-fail":
+			failed = true
+			pkg := e.Package
+			if pkg == "" {
+				pkg = e.ImportPath
+			}
+			if e.Test != "" {
+				fmt.Fprintf(os.Stderr, "\tFAIL %s\n", bench.Key{Pkg: pkg, Name: e.Test})
+			} else {
+				fmt.Fprint(os.Stderr, strings.Join(output[pkg], ""))
+			}
+			emit(streamEvent{Run: run, Action: "fail", Package: pkg, Test: e.Test})
+		}
+	}
+	err = cmd.Wait()
+	if ctx.Err() != nil {
+		fatal("interrupted")
+	}
+	if err != nil || failed {
+		fatal(fmt.Sprintf("go %v: %v", args, cmp.Or(err, errors.New("failed"))))
+	}
+	if key != "" {
+		cachePut(key, results)
+	}
+	writeBenchOut(results, run)
+	historyResults(results, run)
+	return results
+}
+
+// benchCommand returns the command that runs go with args,
+// inside -container and under -perflock, if set.
+func benchCommand(args []string) *exec.Cmd {
+	argv := withPerflock(inContainer(append([]string{"go"}, args...)))
+	return exec.CommandContext(ctx, argv[0], argv[1:]...)
+}
+
+// A testEvent is an event from go test -json.
+type testEvent struct {
+	Action     string
+	Package    string
+	ImportPath string // for build events
+	Test       string
+	Output     string
+}
+
+// A streamEvent is a line of -json output.
+type streamEvent struct {
+	Run     string // which run of the benchmarks, such as "original"
+	Action  string // "result" or "fail"
+	Package string
+	Test    string
+	Result  *bench.Result `json:",omitempty"`
+}
+
+// jsonOut is where -json output goes, if anywhere.
+var jsonOut *json.Encoder
+
+// benchOut is where -bench-out output goes, if anywhere.
+var benchOut *bench.Writer
+
+// emit writes e to the -json output, if any.
+func emit(e streamEvent) {
+	if jsonOut == nil {
+		return
+	}
+	if err := jsonOut.Encode(e); err != nil {
+		fatal(err)
+	}
+}
+
+// writeBenchOut writes results to -bench-out, if set,
+// with an "unroll: run" configuration line, so that benchstat
+// can compare runs with -col unroll.
+func writeBenchOut(results []*bench.Result, run string) {
+	if benchOut == nil {
+		return
+	}
+	for _, r := range results {
+		labeled := *r
+		labeled.Config = maps.Clone(r.Config)
+		if labeled.Config == nil {
+			labeled.Config = make(map[string]string)
+		}
+		labeled.Config["unroll"] = run
+		if err := benchOut.Write(&labeled); err != nil {
+			fatal(err)
+		}
+	}
+}
+
+// openOutputs sets up the -json, -bench-out, and -history outputs for c.
+func openOutputs(c *command) {
+	openHistory(c)
+	if benchOutFile != "" {
+		f, err := os.Create(benchOutFile)
+		if err != nil {
+			fatal(err)
+		}
+		// As for -json, the file is left for the OS to close at exit.
+		benchOut = bench.NewWriter(f)
+	}
+	switch jsonFile {
+	case "":
+	case "-":
+		jsonOut = json.NewEncoder(os.Stdout)
+	default:
+		f, err := os.Create(jsonFile)
+		if err != nil {
+			fatal(err)
+		}
+		// The file is left for the OS to close at exit;
+		// each event is written as it arrives.
+		jsonOut = json.NewEncoder(f)
+	}
+}
+
+// units are the metrics compared, with their benchstat-style names.
+var units = []struct{ unit, name string }{
+	{"ns/op", "time/op"},
+	{"B/op", "alloc/op"},
+	{"allocs/op", "allocs/op"},
+}
+
+// confidence is the confidence level of the intervals printed by compare.
+const confidence = 0.95
+
+// thresholds returns the thresholds for the statistical tests, from -alpha.
+func thresholds() *benchmath.Thresholds {
+	if alpha <= 0 || alpha >= 1 {
+		fatal("-alpha must be between 0 and 1")
+	}
+	return &benchmath.Thresholds{CompareAlpha: alpha}
+}
+
+// compare prints a benchstat-style table comparing old and new to w.
+// Each value is a median with its confidence interval, and each delta
+// is "~" unless a Mann-Whitney U-test finds the difference significant
+// at level -alpha. The significant changes are listed first,
+// separately from those within the noise.
+func compare(w io.Writer, old, new []*bench.Result) {
+	var warnings []string
+	warn := func(errs []error) {
+		for _, err := range errs {
+			if !slices.Contains(warnings, err.Error()) {
+				warnings = append(warnings, err.Error())
+			}
+		}
+	}
+	summarize := func(s bench.Sample, unit string) (*benchmath.Sample, string) {
+		bs := benchmath.NewSample(s, thresholds())
+		sum := benchmath.AssumeNothing.Summary(bs, confidence)
+		warn(bs.Warnings)
+		warn(sum.Warnings)
+		return bs, formatValue(sum.Center, unit) + " ± " + sum.PctRangeString()
+	}
+
+	// With -cpu, compare each GOMAXPROCS setting separately.
+	configs := []string{""}
+	if benchCPU != "" {
+		configs = strings.Split(benchCPU, ",")
+	}
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	first := true
+	for _, cpu := range configs {
+		o, n := old, new
+		if cpu != "" {
+			o, n = withProcs(old, cpu), withProcs(new, cpu)
+			if !first {
+				fmt.Fprintln(tw)
+			}
+			fmt.Fprintf(tw, "GOMAXPROCS=%s:\n", cpu)
+			first = true
+		}
+		for _, u := range units {
+			keys, before := bench.Group(o, u.unit)
+			_, after := bench.Group(n, u.unit)
+			if len(keys) == 0 {
+				continue
+			}
+			if !first {
+				fmt.Fprintln(tw)
+			}
+			first = false
+			fmt.Fprintf(tw, "name\told %s\tnew %s\tdelta\n", u.name, u.name)
+			var significant, noise []string
+			for _, k := range keys {
+				b, bs := summarize(before[k], u.unit)
+				if len(after[k]) == 0 {
+					noise = append(noise, fmt.Sprintf("%s\t%s\t\t\n", k, bs))
+					continue
+				}
+				a, as := summarize(after[k], u.unit)
+				c := benchmath.AssumeNothing.Compare(b, a)
+				warn(c.Warnings)
+				old := benchmath.AssumeNothing.Summary(b, confidence).Center
+				new := benchmath.AssumeNothing.Summary(a, confidence).Center
+				row := fmt.Sprintf("%s\t%s\t%s\t%s (%s)\n", k, bs, as, c.FormatDelta(old, new), c)
+				if c.P < c.Alpha {
+					significant = append(significant, row)
+				} else {
+					noise = append(noise, row)
+				}
+			}
+			for _, row := range significant {
+				fmt.Fprint(tw, row)
+			}
+			if len(noise) > 0 {
+				if len(significant) > 0 {
+					fmt.Fprintf(tw, "within noise (p ≥ %v):\t\t\t\n", alpha)
+				}
+				for _, row := range noise {
+					fmt.Fprint(tw, row)
+				}
+			}
+		}
+	}
+	tw.Flush()
+	if len(warnings) > 0 {
+		fmt.Fprintln(w)
+		for _, s := range warnings {
+			fmt.Fprintf(w, "warning: %s\n", s)
+		}
+	}
+}
+
+// withProcs returns the results run with GOMAXPROCS set to cpu.
+func withProcs(results []*bench.Result, cpu string) []*bench.Result {
+	var out []*bench.Result
+	for _, r := range results {
+		// go test leaves off the -GOMAXPROCS suffix when it is 1.
+		procs := "1"
+		if i := strings.LastIndexByte(r.Name, '-'); i >= 0 {
+			if _, err := strconv.Atoi(r.Name[i+1:]); err == nil {
+				procs = r.Name[i+1:]
+			}
+		}
+		if procs == strings.TrimSpace(cpu) {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// formatValue formats v, in units of unit, scaled for readability.
+func formatValue(v float64, unit string) string {
+	switch unit {
+	case "ns/op":
+		for _, s := range []struct {
+			suffix string
+			scale  float64
+		}{{"s", 1e9}, {"ms", 1e6}, {"µs", 1e3}} {
+			if math.Abs(v) >= s.scale {
+				return fmt.Sprintf("%.4g%s", v/s.scale, s.suffix)
+			}
+		}
+		return fmt.Sprintf("%.4gns", v)
+	case "B/op":
+		return fmt.Sprintf("%.4gB", v)
+	}
+	return fmt.Sprintf("%.4g", v)
+}
+
+func formatDelta(old, new float64) string {
+	if old == new {
+		return "~"
+	}
+	if old == 0 {
+		return "+Inf%"
+	}
+	return fmt.Sprintf("%+.2f%%", (new-old)/old*100)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/token"
+	"math"
+	"path/filepath"
+
+	"github.com/josharian/unrollbench/bench"
+)
+
+// nsPerOp holds the fastest measured ns/op of each benchmark function,
+// keyed by package directory, for unroll -max-ns-per-op and -results.
+var nsPerOp map[factorKey]float64
+
+// measure runs the benchmarks in pkgs once and returns their times.
+func measure(paths []string, pkgs []*build.Package) map[factorKey]float64 {
+	fmt.Println("Measuring benchmarks")
+	count := benchCount
+	benchCount = 1
+	defer func() { benchCount = count }()
+	return fastest(goTestBench(paths, "", "original"), pkgs)
+}
+
+// fastest returns the fastest ns/op in results of each benchmark function
+// in pkgs, keyed by package directory. A function with sub-benchmarks
+// gets the time of its fastest sub-benchmark, since unrolling its loops
+// affects them all.
+func fastest(results []*bench.Result, pkgs []*build.Package) map[factorKey]float64 {
+	dirs := make(map[string]string)
+	for _, pkg := range pkgs {
+		dirs[importPath(pkg)] = pkg.Dir
+	}
+	m := make(map[factorKey]float64)
+	for _, r := range results {
+		ns, ok := r.Values["ns/op"]
+		dir := dirs[r.Pkg]
+		if !ok || dir == "" {
+			continue
+		}
+		k := factorKey{dir, benchFunc(r.Name)}
+		if old, ok := m[k]; !ok || ns < old {
+			m[k] = ns
+		}
+	}
+	return m
+}
+
+// targetNs is roughly how many nanoseconds of work an iteration
+// of an unrolled loop needs for loop overhead to stop mattering.
+const targetNs = 10
+
+// decideMeasured returns an unroll.Config.Decide func for the loops
+// of benchmarks in f, using their times in nsPerOp.
+// It skips benchmarks without times, and those not faster than maxNsPerOp, if set.
+// It unrolls the rest just enough that each iteration does about targetNs of work,
+// up to the given factor.
+func decideMeasured(fset *token.FileSet, f *ast.File) func(*ast.FuncDecl, ast.Stmt, int) int {
+	dir := filepath.Dir(filePath(fset, f))
+	return func(fn *ast.FuncDecl, s ast.Stmt, factor int) int {
+		ns, ok := nsPerOp[factorKey{dir, fn.Name.Name}]
+		if !ok || maxNsPerOp > 0 && ns >= maxNsPerOp {
+			return 0
+		}
+		if n := int(math.Ceil(targetNs / ns)); n < factor {
+			factor = n
+		}
+		if factor < 2 {
+			// A single copy only adds churn.
+			return 0
+		}
+		return factor
+	}
+}
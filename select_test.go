@@ -0,0 +1,26 @@
+package main
+
+import (
+	"go/build"
+	"testing"
+
+	"github.com/josharian/unrollbench/bench"
+)
+
+func TestFastest(t *testing.T) {
+	root := testModule(t)
+	pkg, err := build.Import("./p", root, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results := []*bench.Result{
+		{Pkg: "example.com/m/p", Name: "BenchmarkA/small-8", Values: map[string]float64{"ns/op": 3}},
+		{Pkg: "example.com/m/p", Name: "BenchmarkA/large-8", Values: map[string]float64{"ns/op": 30}},
+		{Pkg: "example.com/m/p", Name: "BenchmarkB-8", Values: map[string]float64{"B/op": 16}},
+		{Pkg: "example.com/m/q", Name: "BenchmarkC-8", Values: map[string]float64{"ns/op": 1}},
+	}
+	got := fastest(results, []*build.Package{pkg})
+	if len(got) != 1 || got[factorKey{pkg.Dir, "BenchmarkA"}] != 3 {
+		t.Errorf("fastest = %v, want BenchmarkA in %s: 3", got, pkg.Dir)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+
+	"github.com/josharian/unrollbench/unroll"
+)
+
+var serveCmd = newCommand("serve", "[-http addr [packages]]", "serve code actions over JSON-RPC on stdin and stdout, or a web dashboard with -http", runServe)
+
+// Server provides code actions for editor integrations.
+// It is served over JSON-RPC by unrollbench serve, under the name "unrollbench".
+type Server struct{}
+
+// CodeActionArgs are the arguments to Server.CodeActions.
+type CodeActionArgs struct {
+	Filename string
+	Src      *string // contents of Filename; if nil, Filename is read from disk
+	Offset   int     // byte offset of the cursor
+}
+
+// A CodeAction is a titled set of edits to the file.
+type CodeAction struct {
+	Title string
+	Edits []Edit
+}
+
+// An Edit replaces the bytes [Offset, End) with NewText.
+type Edit struct {
+	Offset  int
+	End     int
+	NewText string
+}
+
+// CodeActions reports the code actions available at the cursor.
+func (Server) CodeActions(args *CodeActionArgs, reply *[]CodeAction) error {
+	var src interface{}
+	if args.Src != nil {
+		src = *args.Src
+	}
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, args.Filename, src, parser.ParseComments)
+	if err != nil {
+		return err
+	}
+	tf := fset.File(f.Pos())
+	if args.Offset < 0 || args.Offset > tf.Size() {
+		return nil
+	}
+	pos := tf.Pos(args.Offset)
+
+	actions := []CodeAction{}
+	for _, d := range f.Decls {
+		fn, ok := d.(*ast.FuncDecl)
+		if !ok || !unroll.IsBench(fn) || pos < fn.Pos() || pos > fn.End() {
+			continue
+		}
+		for _, s := range fn.Body.List {
+			if pos < s.Pos() || pos > s.End() {
+				continue
+			}
+			var title string
+			var n ast.Stmt
+			start := s.Pos()
+			if r, ok := unroll.Unroll(s, unroll.DefaultFactor); ok {
+				title = "Unroll this benchmark loop"
+				n = r
+			} else if orig, ok := unroll.Rerolled(s); ok {
+				title = "Revert unrolling"
+				n = orig
+				start = unroll.GeneratedStart(tf, f, s)
+			} else {
+				continue
+			}
+			text, err := unroll.Format(fset, f, s, n)
+			if err != nil {
+				return err
+			}
+			actions = append(actions, CodeAction{
+				Title: title,
+				Edits: []Edit{{
+					Offset:  tf.Offset(start),
+					End:     tf.Offset(s.End()),
+					NewText: string(text),
+				}},
+			})
+		}
+	}
+	*reply = actions
+	return nil
+}
+
+// stdio is a connection over stdin and stdout.
+type stdio struct{}
+
+func (stdio) Read(p []byte) (int, error)  { return os.Stdin.Read(p) }
+func (stdio) Write(p []byte) (int, error) { return os.Stdout.Write(p) }
+func (stdio) Close() error                { return nil }
+
+func runServe(c *command, args []string) {
+	if httpAddr != "" {
+		if len(args) == 0 {
+			args = []string{"."}
+		}
+		serveDashboard(loadPackages(args))
+		return
+	}
+	if len(args) != 0 {
+		c.flags.Usage()
+	}
+	if err := rpc.RegisterName("unrollbench", Server{}); err != nil {
+		fatal(err)
+	}
+	jsonrpc.ServeConn(stdio{})
+}
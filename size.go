@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+var sizeCmd = newCommand("size", "[packages]", "report how unrolling changes the size of test binaries", runSize)
+
+func runSize(c *command, args []string) {
+	if len(args) < 1 {
+		c.flags.Usage()
+	}
+	if factor < 2 {
+		fatal("-factor must be at least 2")
+	}
+	pkgs := loadPackages(args)
+
+	dir, err := os.MkdirTemp("", "unrollbench-")
+	if err != nil {
+		fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	overlayFile = filepath.Join(dir, "overlay.json")
+	rewrite(pkgs, false, unrollFile)
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "name\told size\tnew size\tdelta")
+	for _, pkg := range pkgs {
+		old := filepath.Join(dir, "old.test")
+		new := filepath.Join(dir, "new.test")
+		buildTest(pkg.ImportPath, "", old)
+		buildTest(pkg.ImportPath, overlayFile, new)
+		oldSize, newSize := fileSize(old), fileSize(new)
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%s\n", pkg.ImportPath, oldSize, newSize, formatDelta(float64(oldSize), float64(newSize)))
+
+		oldSyms := symbolSizes(old, pkg.ImportPath)
+		newSyms := symbolSizes(new, pkg.ImportPath)
+		var names []string
+		for name := range newSyms {
+			if oldSyms[name] != newSyms[name] {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			o, n := oldSyms[name], newSyms[name]
+			fmt.Fprintf(tw, "  %s\t%d\t%d\t%s\n", name, o, n, formatDelta(float64(o), float64(n)))
+		}
+	}
+	tw.Flush()
+}
+
+// buildTest builds the test binary for the package with import path path,
+// with overlay if not empty, and writes it to out.
+// It leaves out empty if the package has no test files.
+func buildTest(path, overlay, out string) {
+	args := append([]string{"test", "-c", "-o", out}, buildFlags()...)
+	if overlay != "" {
+		args = append(args, "-overlay="+overlay)
+	}
+	args = append(args, path)
+	if out, err := exec.CommandContext(ctx, "go", args...).CombinedOutput(); err != nil {
+		os.Stdout.Write(out)
+		fatal(fmt.Sprintf("go %v: %v", args, err))
+	}
+}
+
+func fileSize(file string) int64 {
+	fi, err := os.Stat(file)
+	if err != nil {
+		return 0
+	}
+	return fi.Size()
+}
+
+// symbolSizes returns the sizes of the symbols in the binary file
+// that belong to the package with import path path.
+func symbolSizes(file, path string) map[string]int64 {
+	sizes := make(map[string]int64)
+	if !exists(file) {
+		return sizes
+	}
+	out, err := exec.CommandContext(ctx, "go", "tool", "nm", "-size", file).Output()
+	if err != nil {
+		fatal(fmt.Sprintf("go tool nm: %v", err))
+	}
+	s := bufio.NewScanner(bytes.NewReader(out))
+	for s.Scan() {
+		// Lines look like "  543360         17 T smoke.BenchmarkX".
+		f := strings.Fields(s.Text())
+		if len(f) != 4 || !strings.HasPrefix(f[3], path+".") {
+			continue
+		}
+		n, err := strconv.ParseInt(f[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		sizes[f[3]] += n
+	}
+	return sizes
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/josharian/unrollbench/unroll"
+)
+
+var revertCmd = newCommand("revert", "[packages]", "restore the original loops recorded by unroll", runRevert)
+
+// stateFile is the sidecar in each package directory that records
+// the loops rewritten by unroll, so that revert can restore them.
+const stateFile = ".unrollbench/state"
+
+// A pkgState records the rewrites in a package, keyed by file name.
+type pkgState map[string]*fileState
+
+// A fileState records the rewrites in a file.
+type fileState struct {
+	Before   string // hash of the file before its first rewrite
+	After    string // hash of the file after its last rewrite
+	Rewrites []rewriteRecord
+}
+
+// A rewriteRecord records a single rewritten loop.
+type rewriteRecord struct {
+	Func      string // enclosing function
+	Start     int    // byte offset of Generated in the rewritten file
+	End       int
+	Original  string // the original loop
+	Generated string // the code that replaced it
+}
+
+func hash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func loadState(dir string) (pkgState, error) {
+	st := make(pkgState)
+	data, err := os.ReadFile(filepath.Join(dir, stateFile))
+	if os.IsNotExist(err) {
+		return st, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &st); err != nil {
+		return nil, fmt.Errorf("%s: %v", filepath.Join(dir, stateFile), err)
+	}
+	return st, nil
+}
+
+func (st pkgState) save(dir string) error {
+	file := filepath.Join(dir, stateFile)
+	if len(st) == 0 {
+		err := os.Remove(file)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err == nil {
+			// Remove the sidecar directory too, if nothing else is in it.
+			os.Remove(filepath.Dir(file))
+		}
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0777); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(st, "", "\t")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, append(data, '\n'), 0666)
+}
+
+// benchStmts returns the top level statements of the benchmarks in f,
+// keyed by function name. The slices are copies, so they survive rewriting.
+func benchStmts(f *ast.File) map[string][]ast.Stmt {
+	m := make(map[string][]ast.Stmt)
+	for name, body := range unroll.Bodies(f) {
+		m[name] = append([]ast.Stmt(nil), body.List...)
+	}
+	return m
+}
+
+// record adds to fs the loops that were rewritten in f,
+// given the statements before, src before rewriting, and out after.
+func (fs *fileState) record(before map[string][]ast.Stmt, fset *token.FileSet, f *ast.File, src, out []byte) error {
+	outFset := token.NewFileSet()
+	outFile, err := parser.ParseFile(outFset, "", out, parser.ParseComments)
+	if err != nil {
+		return err
+	}
+	after := benchStmts(outFile)
+	tf := fset.File(f.Pos())
+	outTF := outFset.File(outFile.Pos())
+	bodies := unroll.Bodies(f)
+	var names []string
+	for name := range bodies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if before[name] == nil {
+			continue
+		}
+		for i, s := range bodies[name].List {
+			old := before[name][i]
+			if s == old {
+				continue
+			}
+			gen := after[name][i]
+			start := outTF.Offset(unroll.GeneratedStart(outTF, outFile, gen))
+			end := outTF.Offset(gen.End())
+			r := rewriteRecord{
+				Func:      name,
+				Start:     start,
+				End:       end,
+				Original:  string(src[tf.Offset(old.Pos()):tf.Offset(old.End())]),
+				Generated: string(out[start:end]),
+			}
+			// A loop unrolled again with a new factor
+			// still reverts to the loop it was first.
+			if prev := fs.rewrote(r.Func, r.Original); prev != nil {
+				r.Original = prev.Original
+				*prev = r
+				continue
+			}
+			fs.Rewrites = append(fs.Rewrites, r)
+		}
+	}
+	if factorConst != "" {
+		if err := fs.recordConst(src, out); err != nil {
+			return err
+		}
+	}
+	fs.After = hash(out)
+	return nil
+}
+
+// rewrote returns the record of the rewrite in fn that generated code, if any.
+func (fs *fileState) rewrote(fn, code string) *rewriteRecord {
+	for i := range fs.Rewrites {
+		r := &fs.Rewrites[i]
+		if r.Func == fn && strings.Contains(r.Generated, code) {
+			return r
+		}
+	}
+	return nil
+}
+
+func runRevert(c *command, args []string) {
+	if len(args) < 1 {
+		c.flags.Usage()
+	}
+	failed := false
+	for _, pkg := range loadPackages(args) {
+		st, err := loadState(pkg.Dir)
+		if err != nil {
+			fatal(err)
+		}
+		names := make([]string, 0, len(st))
+		for name := range st {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fs := st[name]
+			file := filepath.Join(pkg.Dir, name)
+			fmt.Println("Reverting", rel(file))
+			if err := fs.revert(pkg, file); err != nil {
+				fmt.Println(err)
+				failed = true
+				continue
+			}
+			delete(st, name)
+		}
+		if err := st.save(pkg.Dir); err != nil {
+			fatal(err)
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// revert restores the original loops recorded in fs to file,
+// in pkg, and records it in the audit log.
+func (fs *fileState) revert(pkg *build.Package, file string) error {
+	src, err := os.ReadFile(file)
+	data := src
+	if err != nil {
+		return err
+	}
+	fi, err := os.Stat(file)
+	if err != nil {
+		return err
+	}
+	// Undo the last rewrite first, so that earlier offsets stay meaningful.
+	rewrites := append([]rewriteRecord(nil), fs.Rewrites...)
+	sort.SliceStable(rewrites, func(i, j int) bool { return rewrites[i].Start > rewrites[j].Start })
+	for _, r := range rewrites {
+		start := r.Start
+		// After unrelated edits, the generated code may have moved.
+		if !bytes.HasPrefix(data[min(start, len(data)):], []byte(r.Generated)) {
+			if bytes.Count(data, []byte(r.Generated)) != 1 {
+				return fmt.Errorf("%s: cannot find unrolled loop in %s; was it edited?", rel(file), r.Func)
+			}
+			start = bytes.Index(data, []byte(r.Generated))
+		}
+		data = append(data[:start:start], append([]byte(r.Original), data[start+len(r.Generated):]...)...)
+	}
+	if err := os.WriteFile(file, data, fi.Mode()); err != nil {
+		return err
+	}
+	var funcs []string
+	for _, r := range fs.Rewrites {
+		if !strings.HasPrefix(r.Func, "const ") && !slices.Contains(funcs, r.Func) {
+			funcs = append(funcs, r.Func)
+		}
+	}
+	sort.Strings(funcs)
+	return audit(pkg, file, funcs, src, data)
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/josharian/unrollbench/unroll"
+)
+
+var surveyCmd = newCommand("survey", "[-v] [packages]", "report, without changing anything, how many benchmarks and loops each package has and which would be unrolled", runSurvey)
+
+var (
+	surveyVerbose bool
+	minRewritable float64
+)
+
+func init() {
+	surveyCmd.flags.BoolVar(&surveyVerbose, "v", false, "also list each benchmark and loop that would not be unrolled, and why")
+	surveyCmd.flags.Float64Var(&minRewritable, "min", 0, "fail unless at least `percent` of the b.N loops found are ones unroll can rewrite")
+}
+
+// Benchmark shapes with b.N loops that unroll does not recognize.
+const (
+	unrecognizedLoop = "benchmark's b.N loop has an unrecognized shape"
+	nestedLoop       = "benchmark's b.N loop is not at top level"
+)
+
+// A surveyPkg is what survey found in a package.
+type surveyPkg struct {
+	path       string
+	benchmarks int
+	withLoops  int // benchmarks with b.N loops unroll recognizes
+	loops      int
+	unroll     int // loops that unroll would rewrite
+	found      int // loops, and benchmarks with b.N loops that unroll does not recognize
+	baselines  int // loops with empty bodies, which unroll leaves alone on purpose
+}
+
+// runSurvey reports the benchmarks in packages, which may include
+// patterns such as std or ./..., expanded by go list.
+// A benchmark with no loop that unroll recognizes is counted under
+// what it does instead, and a loop that would not be rewritten
+// under why, so the totals show which shapes are worth recognizing.
+// Packages and files skipped by the other commands are surveyed
+// and counted as skipped.
+//
+// The loops unroll recognizes, rewritten already or not, are the ones
+// it can rewrite safely; with -min, survey fails if too few of the b.N loops
+// found are such, counting each benchmark whose loops it does not
+// recognize once. Loops with empty bodies, which measure the loop overhead
+// and are never unrolled, count neither way.
+func runSurvey(c *command, args []string) {
+	if len(args) < 1 {
+		c.flags.Usage()
+	}
+	includeAsmCoupled = true
+	why := make(map[string]int)
+	var pkgs []surveyPkg
+	for _, pkg := range loadPackages(expandPatterns(args)) {
+		s := surveyPkg{path: pkg.ImportPath}
+		pkgWhy := ""
+		if asmCoupled(pkg) != "" {
+			pkgWhy = "package tests look coupled to assembly"
+		} else if p := policyFor(pkg.Dir); !p.allows(pkg.Dir) {
+			pkgWhy = "package outside the directories allowed by policy"
+		}
+		for _, file := range testFiles(pkg) {
+			src, err := os.ReadFile(file)
+			if err != nil {
+				fail(err)
+				continue
+			}
+			if !mayHaveBenchmarks(src) {
+				continue
+			}
+			fset := token.NewFileSet()
+			f, err := parseFile(fset, file, src)
+			if err != nil {
+				fail(err)
+				continue
+			}
+			fileWhy := pkgWhy
+			if fileWhy == "" && cgoImport(f) != nil && !rewriteCgo {
+				fileWhy = `file imports "C"`
+			}
+			p := policyFor(pkg.Dir)
+			for _, d := range f.Decls {
+				fn, ok := d.(*ast.FuncDecl)
+				if !ok || !unroll.IsBench(fn) {
+					continue
+				}
+				s.benchmarks++
+				note := func(pos token.Pos, reason string) {
+					why[reason]++
+					if surveyVerbose {
+						fmt.Printf("%v: %s: %s\n", fset.Position(pos), fn.Name.Name, reason)
+					}
+				}
+				loops := 0
+				for _, st := range fn.Body.List {
+					reason := ""
+					if _, ok := unroll.Rerolled(st); ok {
+						reason = "loop already unrolled"
+					} else if unroll.Baseline(st) {
+						reason = "loop body is empty, measuring loop overhead"
+						s.baselines++
+					} else if _, ok := unroll.Unroll(st, unroll.DefaultFactor); !ok {
+						continue
+					} else if unroll.HasGenerated(st) {
+						reason = "loop contains unrolled code"
+					}
+					loops++
+					switch {
+					case reason != "":
+					case fileWhy != "":
+						reason = fileWhy
+					case p.optIn && !optedIn(fn):
+						reason = "benchmark not opted in by policy"
+					default:
+						s.unroll++
+						continue
+					}
+					note(st.Pos(), reason)
+				}
+				if loops == 0 {
+					shape := benchShape(fn)
+					if shape == unrecognizedLoop || shape == nestedLoop {
+						s.found++
+					}
+					note(fn.Pos(), shape)
+				} else {
+					s.withLoops++
+				}
+				s.loops += loops
+				s.found += loops
+			}
+		}
+		s.found -= s.baselines
+		pkgs = append(pkgs, s)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "package\tbenchmarks\twith loops\tloops\twould unroll\tskipped")
+	var total surveyPkg
+	for _, s := range pkgs {
+		if s.benchmarks == 0 {
+			continue
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%d\n", s.path, s.benchmarks, s.withLoops, s.loops, s.unroll, s.loops-s.unroll)
+		total.benchmarks += s.benchmarks
+		total.withLoops += s.withLoops
+		total.loops += s.loops
+		total.unroll += s.unroll
+		total.found += s.found
+		total.baselines += s.baselines
+	}
+	fmt.Fprintf(tw, "total\t%d\t%d\t%d\t%d\t%d\n", total.benchmarks, total.withLoops, total.loops, total.unroll, total.loops-total.unroll)
+	tw.Flush()
+
+	if len(why) > 0 {
+		reasons := make([]string, 0, len(why))
+		for r := range why {
+			reasons = append(reasons, r)
+		}
+		sort.Slice(reasons, func(i, j int) bool {
+			if why[reasons[i]] != why[reasons[j]] {
+				return why[reasons[i]] > why[reasons[j]]
+			}
+			return reasons[i] < reasons[j]
+		})
+		fmt.Println("\nNot unrolled:")
+		for _, r := range reasons {
+			fmt.Printf("\t%6d  %s\n", why[r], r)
+		}
+	}
+	pct := 100.0
+	if total.found > 0 {
+		pct = 100 * float64(total.loops-total.baselines) / float64(total.found)
+	}
+	fmt.Printf("\nunroll can rewrite %d of the %d b.N loops found (%.1f%%).\n", total.loops-total.baselines, total.found, pct)
+	if minRewritable > 0 && pct < minRewritable {
+		fmt.Printf("That is below -min %g%%.\n", minRewritable)
+		exit(1)
+	}
+	if len(failures) > 0 {
+		exit(1)
+	}
+}
+
+// benchShape describes what fn, a benchmark with no top level loop
+// that unroll recognizes, does instead.
+func benchShape(fn *ast.FuncDecl) string {
+	var loop, run, parallel, nested, other, bN bool
+	ast.Inspect(fn.Body, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.CallExpr:
+			if sel, ok := n.Fun.(*ast.SelectorExpr); ok && isB(sel.X) {
+				switch sel.Sel.Name {
+				case "Loop":
+					loop = true
+				case "Run":
+					run = true
+				case "RunParallel":
+					parallel = true
+				}
+			}
+		case *ast.ForStmt, *ast.RangeStmt:
+			if mentionsBN(n) {
+				if slices.Contains(fn.Body.List, n.(ast.Stmt)) {
+					other = true
+				} else {
+					nested = true
+				}
+				return false
+			}
+		case *ast.SelectorExpr:
+			if isB(n.X) && n.Sel.Name == "N" {
+				bN = true
+			}
+		}
+		return true
+	})
+	switch {
+	case loop:
+		return "benchmark uses b.Loop"
+	case other:
+		return unrecognizedLoop
+	case nested:
+		return nestedLoop
+	case parallel:
+		return "benchmark uses b.RunParallel"
+	case run:
+		return "benchmark runs sub-benchmarks"
+	case bN:
+		return "benchmark passes b.N to other code"
+	}
+	return "benchmark has no b.N loop"
+}
+
+// mentionsBN reports whether n mentions b.N.
+func mentionsBN(n ast.Node) bool {
+	found := false
+	ast.Inspect(n, func(n ast.Node) bool {
+		if sel, ok := n.(*ast.SelectorExpr); ok && isB(sel.X) && sel.Sel.Name == "N" {
+			found = true
+		}
+		return !found
+	})
+	return found
+}
+
+// isB reports whether x is the identifier b.
+func isB(x ast.Expr) bool {
+	id, ok := x.(*ast.Ident)
+	return ok && id.Name == "b"
+}
+
+// expandPatterns returns paths with each package pattern,
+// such as std or a path containing ..., replaced by the packages
+// with test files that go list finds for it.
+func expandPatterns(paths []string) []string {
+	var out []string
+	for _, path := range paths {
+		if path != "std" && path != "all" && !strings.Contains(path, "...") {
+			out = append(out, path)
+			continue
+		}
+		cmd := exec.CommandContext(ctx, "go", "list", "-e", "-f", "{{if or .TestGoFiles .XTestGoFiles}}{{.ImportPath}}{{end}}", filepath.ToSlash(path))
+		cmd.Stderr = os.Stderr
+		list, err := cmd.Output()
+		if err != nil {
+			fatal(fmt.Sprintf("go list %s: %v", path, err))
+		}
+		out = append(out, strings.Fields(string(list))...)
+	}
+	return out
+}
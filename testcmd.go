@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+var testCmd = newCommand("test", "[packages] [go test flags]", "run go test on the packages unrolled, without changing them", runTest)
+
+// runTest unrolls the packages into a temporary overlay and runs go test
+// on them with it and the flags after the packages, such as -bench and -count.
+// It exits with go test's exit status.
+func runTest(c *command, args []string) {
+	if factor < 2 {
+		fatal("-factor must be at least 2")
+	}
+	// As with go test, flags follow the packages.
+	paths, testArgs := args, []string(nil)
+	for i, arg := range args {
+		if strings.HasPrefix(arg, "-") {
+			paths, testArgs = args[:i], args[i:]
+			break
+		}
+	}
+	for _, arg := range testArgs {
+		if arg == "-overlay" || strings.HasPrefix(arg, "-overlay=") || strings.HasPrefix(arg, "--overlay") {
+			fatal("unrollbench test supplies its own -overlay")
+		}
+	}
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+	pkgs := loadPackages(paths)
+
+	tmp, err := os.CreateTemp("", "unrollbench-*.json")
+	if err != nil {
+		fatal(err)
+	}
+	tmp.Close()
+	overlayFile = tmp.Name()
+	rewrite(pkgs, false, unrollFile)
+
+	goArgs := append(append([]string{"test", "-overlay=" + overlayFile}, buildFlags()...), testArgs...)
+	cmd := benchCommand(append(goArgs, paths...))
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	err = cmd.Run()
+	os.Remove(overlayFile)
+	var exitErr *exec.ExitError
+	switch {
+	case errors.As(err, &exitErr):
+		os.Exit(exitErr.ExitCode())
+	case err != nil:
+		fatal(err)
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/josharian/unrollbench/bench"
+)
+
+var factorsCmd = newCommand("factors", "[packages]", "measure benchmarks at several unroll factors and pick one for each", runFactors)
+
+var (
+	tryFactors   string
+	kneeFraction float64
+	factorsOut   string
+)
+
+func init() {
+	for _, c := range []*command{factorsCmd, calibrateCmd} {
+		c.flags.StringVar(&tryFactors, "try", "1,4,8,16,32", "comma-separated unroll `factors` to measure")
+		c.flags.Float64Var(&kneeFraction, "threshold", 0.02, "pick the smallest factor within `fraction` of the fastest")
+	}
+	factorsCmd.flags.StringVar(&factorsOut, "o", "", "write the picked factors to `file`, for unroll -factors")
+}
+
+func runFactors(c *command, args []string) {
+	if len(args) < 1 {
+		c.flags.Usage()
+	}
+	try := parseTry()
+	pkgs := loadPackages(args)
+	openOutputs(c)
+
+	tmp, err := os.CreateTemp("", "unrollbench-*.json")
+	if err != nil {
+		fatal(err)
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+	overlayFile = tmp.Name()
+
+	// means[k][i] is the mean ns/op of k at factor try[i].
+	var keys []bench.Key
+	means := make(map[bench.Key][]float64)
+	for i, f := range try {
+		fmt.Printf("Running benchmarks unrolled %d times\n", f)
+		factor = f
+		rewrite(pkgs, false, unrollFile)
+		ks, samples := bench.Group(goTestBench(args, overlayFile, "×"+strconv.Itoa(f)), "ns/op")
+		for _, k := range ks {
+			if means[k] == nil {
+				keys = append(keys, k)
+				means[k] = make([]float64, len(try))
+				for j := range means[k] {
+					means[k][j] = math.NaN()
+				}
+			}
+			means[k][i] = samples[k].Mean()
+		}
+	}
+
+	picked := make(factorTable)
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprint(tw, "\nname")
+	for _, f := range try {
+		fmt.Fprintf(tw, "\t×%d", f)
+	}
+	fmt.Fprintln(tw, "\tpicked")
+	for _, k := range keys {
+		fmt.Fprint(tw, k)
+		for _, m := range means[k] {
+			fmt.Fprintf(tw, "\t%s", formatValue(m, "ns/op"))
+		}
+		f := knee(try, means[k], kneeFraction)
+		fmt.Fprintf(tw, "\t%d\n", f)
+		if f < 2 {
+			// A single copy only adds churn; leave the loop alone.
+			f = 0
+		}
+		// Sub-benchmarks share their function's loops,
+		// so give the function the largest factor any of them needs.
+		fk := factorKey{k.Pkg, benchFunc(k.Name)}
+		picked[fk] = max(picked[fk], f)
+	}
+	tw.Flush()
+	historyFactors(picked)
+
+	if factorsOut != "" {
+		if err := picked.save(factorsOut); err != nil {
+			fatal(err)
+		}
+	}
+}
+
+var tuneCmd = newCommand("tune", "[packages]", "search for the best unroll factor for each benchmark, recording them in a factors file", runTune)
+
+var (
+	tuneFile   string
+	tuneRounds int
+)
+
+func init() {
+	tuneCmd.flags.Float64Var(&kneeFraction, "threshold", 0.02, "pick the smallest factor within `fraction` of the fastest")
+	tuneCmd.flags.StringVar(&tuneFile, "factors", "unrollbench.factors", "read and record factors in `file`, for unroll -factors")
+	tuneCmd.flags.IntVar(&tuneRounds, "rounds", 5, "stop after `n` rounds, even if factors are still changing")
+}
+
+// runTune repeatedly measures each benchmark at half, the same as,
+// and double its current factor, and moves it to the best of them,
+// until no factor changes.
+func runTune(c *command, args []string) {
+	if len(args) < 1 {
+		c.flags.Usage()
+	}
+	if factor < 2 {
+		fatal("-factor must be at least 2")
+	}
+	pkgs := loadPackages(args)
+	openOutputs(c)
+	cur := make(factorTable)
+	if exists(tuneFile) {
+		var err error
+		if cur, err = readFactors(tuneFile); err != nil {
+			fatal(err)
+		}
+	}
+
+	tmp, err := os.CreateTemp("", "unrollbench-*.json")
+	if err != nil {
+		fatal(err)
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+	overlayFile = tmp.Name()
+
+	start := factor
+	scales := []struct{ num, den int }{{1, 2}, {1, 1}, {2, 1}}
+	for round := 1; round <= tuneRounds; round++ {
+		// tried[k][i] and means[k][i] are the factor and mean ns/op
+		// of k at scales[i] of its function's current factor.
+		var keys []bench.Key
+		tried := make(map[bench.Key][]int)
+		means := make(map[bench.Key][]float64)
+		for i, s := range scales {
+			scale := func(f int) int { return max(1, f*s.num/s.den) }
+			fmt.Printf("Round %d: running benchmarks at %d/%d of their factors\n", round, s.num, s.den)
+			t := make(factorTable)
+			for k, f := range cur {
+				t[k] = scale(f)
+			}
+			benchFactors = t.byDir(pkgs)
+			factor = scale(start)
+			rewrite(pkgs, false, unrollFile)
+			ks, samples := bench.Group(goTestBench(args, overlayFile, fmt.Sprintf("round %d, %d/%d", round, s.num, s.den)), "ns/op")
+			for _, k := range ks {
+				if means[k] == nil {
+					keys = append(keys, k)
+					tried[k] = make([]int, len(scales))
+					means[k] = make([]float64, len(scales))
+				}
+				f, ok := t[factorKey{k.Pkg, benchFunc(k.Name)}]
+				if !ok {
+					f = factor
+				}
+				tried[k][i] = f
+				means[k][i] = samples[k].Mean()
+			}
+		}
+		factor = start
+
+		// Sub-benchmarks share their function's loops,
+		// so give the function the largest factor any of them needs.
+		next := make(factorTable)
+		for _, k := range keys {
+			if slices.Contains(means[k], 0) {
+				// Missing from a run; leave it be.
+				continue
+			}
+			fk := factorKey{k.Pkg, benchFunc(k.Name)}
+			next[fk] = max(next[fk], knee(tried[k], means[k], kneeFraction))
+		}
+		changed := false
+		for _, k := range next.keys() {
+			f := next[k]
+			if old, ok := cur[k]; !ok || old != f {
+				fmt.Printf("\t%s: factor %d\n", bench.Key{Pkg: k.pkg, Name: k.name}, f)
+				changed = true
+			}
+			cur[k] = f
+		}
+		if err := cur.save(tuneFile); err != nil {
+			fatal(err)
+		}
+		if !changed {
+			fmt.Println("Factors are stable.")
+			break
+		}
+	}
+	historyFactors(cur)
+	fmt.Printf("Recorded factors in %s; apply them with unrollbench unroll -factors %s.\n", tuneFile, tuneFile)
+}
+
+// parseTry returns the factors in -try.
+func parseTry() []int {
+	var try []int
+	for _, s := range strings.Split(tryFactors, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil || n < 1 {
+			fatal(fmt.Sprintf("bad factor %q in -try", s))
+		}
+		try = append(try, n)
+	}
+	return try
+}
+
+// knee returns the smallest factor in try whose mean
+// is within fraction of the best mean:
+// the factor past which results stop improving.
+func knee(try []int, means []float64, fraction float64) int {
+	best := math.Inf(1)
+	for _, m := range means {
+		if m < best {
+			best = m
+		}
+	}
+	for i, m := range means {
+		if m <= best*(1+fraction) {
+			return try[i]
+		}
+	}
+	return try[len(try)-1]
+}
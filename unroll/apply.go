@@ -0,0 +1,62 @@
+package unroll
+
+import (
+	"go/ast"
+
+	"golang.org/x/tools/go/ast/astutil"
+)
+
+// An Applier unrolls benchmark loops during an astutil.Apply traversal,
+// so that unrolling can be composed with other passes:
+//
+//	var a unroll.Applier
+//	astutil.Apply(f, a.Pre, a.Post)
+//
+// Pre and Post are astutil.ApplyFuncs. Callers composing their own
+// ApplyFuncs must call Pre and Post for every node.
+type Applier struct {
+	// Factor is the number of copies of each loop body.
+	// If zero, DefaultFactor is used.
+	Factor int
+
+	// Unrolled is the number of loops rewritten so far.
+	Unrolled int
+
+	body *ast.BlockStmt // body of the benchmark being traversed, if any
+}
+
+// Pre rewrites the benchmark loop at c, if there is one.
+func (a *Applier) Pre(c *astutil.Cursor) bool {
+	switch n := c.Node().(type) {
+	case *ast.FuncDecl:
+		if IsBench(n) {
+			a.body = n.Body
+		}
+	case ast.Stmt:
+		// Only top level loops; see Func.
+		if a.body == nil || c.Parent() != a.body {
+			break
+		}
+		factor := a.Factor
+		if factor == 0 {
+			factor = DefaultFactor
+		}
+		r, ok := Unroll(n, factor)
+		if !ok {
+			break
+		}
+		c.Replace(r)
+		a.Unrolled++
+		// Don't descend into the copies of the body.
+		return false
+	}
+	return true
+}
+
+// Post ends the traversal of a benchmark.
+func (a *Applier) Post(c *astutil.Cursor) bool {
+	if fn, ok := c.Node().(*ast.FuncDecl); ok && fn.Body == a.body {
+		a.body = nil
+	}
+	return true
+}
@@ -0,0 +1,33 @@
+package unroll
+
+import "go/ast"
+
+// autoFactors maps loop sizes, in syntax nodes, to factors:
+// a loop of up to size nodes gets factor copies of its body.
+// Larger loops are left alone; their overhead is negligible.
+var autoFactors = []struct{ size, factor int }{
+	{20, 16},
+	{40, 8},
+	{80, 4},
+	{160, 2},
+}
+
+// AutoFactor returns a factor for the benchmark loop s chosen from
+// its size alone: small bodies, whose loop overhead matters most,
+// get large factors, and larger bodies get smaller ones.
+// It returns 0 if s is too large to be worth unrolling.
+func AutoFactor(s ast.Stmt) int {
+	size := 0
+	ast.Inspect(s, func(n ast.Node) bool {
+		if n != nil {
+			size++
+		}
+		return true
+	})
+	for _, a := range autoFactors {
+		if size <= a.size {
+			return a.factor
+		}
+	}
+	return 0
+}
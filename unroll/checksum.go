@@ -0,0 +1,144 @@
+package unroll
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"sort"
+	"strings"
+)
+
+// SumPrefix starts the comments added by Checksums.
+const SumPrefix = "// unrollbench:sum "
+
+// Checksums marks each unrolled loop in out, the source of a file
+// after unrolling, with a comment such as
+//
+//	// unrollbench:sum 1a2b3c4d
+//
+// holding a hash of its code, so that a later edit by hand can be told
+// apart from the code as generated; see Region.Tampered.
+// Loops marked already keep their comments, edited or not;
+// rewriting a loop drops its comment.
+// The comments from CopyComments and //line directives are not hashed,
+// so Checksums may come before LineDirectives.
+func Checksums(out []byte) ([]byte, error) {
+	regions, err := Regions(out)
+	if err != nil {
+		return nil, err
+	}
+	notes := make(map[int]string) // by line
+	for _, r := range regions {
+		if r.Sum == "" {
+			notes[r.Line] = SumPrefix + checksum(r.code)
+		}
+	}
+	var buf bytes.Buffer
+	for i, line := range bytes.SplitAfter(out, []byte("\n")) {
+		if note, ok := notes[i+1]; ok {
+			indent := line[:len(line)-len(bytes.TrimLeft(line, "\t "))]
+			fmt.Fprintf(&buf, "%s%s\n", indent, note)
+		}
+		buf.Write(line)
+	}
+	return buf.Bytes(), nil
+}
+
+// A Region is an unrolled loop in a file.
+type Region struct {
+	Line int    // the line it starts on, ignoring //line directives
+	Func string // the function containing it, named as by Bodies
+	Sum  string // the hash in its comment from Checksums, or "" if none
+	code string // its code, as hashed
+}
+
+// Regions returns the unrolled loops in src, in order.
+func Regions(src []byte) ([]Region, error) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "", src, parser.SkipObjectResolution)
+	if err != nil {
+		return nil, err
+	}
+	tf := fset.File(f.Pos())
+	lines := bytes.SplitAfter(src, []byte("\n"))
+	var regions []Region
+	for name, body := range Bodies(f) {
+		for _, s := range body.List {
+			if _, ok := Rerolled(s); !ok {
+				continue
+			}
+			r := Region{
+				Line: rawLine(tf, s.Pos()),
+				Func: name,
+				code: hashed(src[tf.Offset(s.Pos()):tf.Offset(s.End())]),
+			}
+			for l := r.Line - 1; l >= 1; l-- {
+				text := string(bytes.TrimSpace(lines[l-1]))
+				if strings.HasPrefix(text, linePrefix) {
+					continue
+				}
+				if sum, ok := strings.CutPrefix(text, SumPrefix); ok {
+					r.Sum = strings.TrimSpace(sum)
+				}
+				break
+			}
+			regions = append(regions, r)
+		}
+	}
+	sort.Slice(regions, func(i, j int) bool { return regions[i].Line < regions[j].Line })
+	return regions, nil
+}
+
+// Tampered reports whether r has been edited by hand
+// since Checksums marked it.
+func (r Region) Tampered() bool {
+	return r.Sum != "" && r.Sum != checksum(r.code)
+}
+
+// Same reports whether r and o have the same code.
+func (r Region) Same(o Region) bool {
+	return r.code == o.code
+}
+
+// hashed returns the parts of code, an unrolled loop, that Checksums hashes:
+// its lines without indentation, blank lines, copy comments, or //line directives.
+func hashed(code []byte) string {
+	var kept []string
+	for _, line := range strings.Split(string(code), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, copyPrefix) || strings.HasPrefix(line, linePrefix) {
+			continue
+		}
+		kept = append(kept, line)
+	}
+	return strings.Join(kept, "\n")
+}
+
+// checksum returns the hash of code for a Checksums comment.
+func checksum(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:4])
+}
+
+// dropChecksum removes the comment added by Checksums for s, which is in f,
+// from the line above it, joining that line to s's.
+func dropChecksum(tf *token.File, f *ast.File, s ast.Stmt) {
+	line := rawLine(tf, s.Pos())
+	for i, g := range f.Comments {
+		c := g.List[len(g.List)-1]
+		if rawLine(tf, c.Pos()) != line-1 || !strings.HasPrefix(c.Text, SumPrefix) {
+			continue
+		}
+		if len(g.List) == 1 {
+			f.Comments = append(f.Comments[:i], f.Comments[i+1:]...)
+		} else {
+			g.List = g.List[:len(g.List)-1]
+		}
+		tf.MergeLine(line - 1)
+		return
+	}
+}
@@ -0,0 +1,78 @@
+package unroll
+
+import (
+	"go/ast"
+	"go/token"
+)
+
+// name rewrites s, a statement generated by Unrolled with factor copies,
+// to use c.FactorConst in place of factor, if it should.
+func (c *Config) name(s ast.Stmt, factor int) ast.Stmt {
+	if c.FactorConst == "" || factor != c.factor() {
+		return s
+	}
+	guard := s.(*ast.IfStmt).Cond.(*ast.BinaryExpr)
+	guard.Y = ident(guard.Y.Pos(), c.FactorConst)
+	div := s.(*ast.IfStmt).Else.(*ast.BlockStmt).List[0].(*ast.ForStmt).Init.(*ast.AssignStmt).Rhs[1].(*ast.BinaryExpr)
+	div.Y = ident(div.Y.Pos(), c.FactorConst)
+	return s
+}
+
+// named reports whether s, a statement generated by Unrolled
+// with factor copies, uses c.FactorConst if it should, and otherwise not.
+func (c *Config) named(s ast.Stmt, factor int) bool {
+	id, ok := s.(*ast.IfStmt).Cond.(*ast.BinaryExpr).Y.(*ast.Ident)
+	if c.FactorConst != "" && factor == c.factor() {
+		return ok && id.Name == c.FactorConst
+	}
+	return !ok
+}
+
+// DeclareConst declares the integer constant name with value value
+// at the top level of f, after its imports,
+// or sets its value if f already declares it there.
+// It reports whether f changed.
+func DeclareConst(f *ast.File, name string, value int) bool {
+	pos := f.Name.End()
+	insert := 0
+	for i, d := range f.Decls {
+		g, ok := d.(*ast.GenDecl)
+		if !ok {
+			continue
+		}
+		if g.Tok == token.IMPORT {
+			pos, insert = g.End(), i+1
+			continue
+		}
+		if g.Tok != token.CONST {
+			continue
+		}
+		for _, spec := range g.Specs {
+			vs := spec.(*ast.ValueSpec)
+			for j, id := range vs.Names {
+				if id.Name != name {
+					continue
+				}
+				if j < len(vs.Values) && intLit(vs.Values[j]) == value {
+					return false
+				}
+				if len(vs.Names) == len(vs.Values) {
+					vs.Values[j] = basicInt(vs.Values[j].Pos(), value)
+					return true
+				}
+				// Part of an iota sequence or the like; leave it be.
+				return false
+			}
+		}
+	}
+	decl := &ast.GenDecl{
+		TokPos: pos,
+		Tok:    token.CONST,
+		Specs: []ast.Spec{&ast.ValueSpec{
+			Names:  []*ast.Ident{ident(pos, name)},
+			Values: []ast.Expr{basicInt(pos, value)},
+		}},
+	}
+	f.Decls = append(f.Decls[:insert], append([]ast.Decl{decl}, f.Decls[insert:]...)...)
+	return true
+}
@@ -0,0 +1,73 @@
+package unroll
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"slices"
+	"strings"
+)
+
+// copyPrefix starts the comments added by CopyComments.
+const copyPrefix = "// unroll copy "
+
+// CopyComments annotates each copy of the body in the unrolled loops in out,
+// the source of a file after unrolling, with a comment such as
+//
+//	// unroll copy 3/10
+//
+// so that readers of profiles, disassembly, and panics can tell the copies apart.
+// Copies repeated by an inner loop (see Config.MaxCopies) are numbered
+// within their block.
+func CopyComments(out []byte) ([]byte, error) {
+	// Start over, for loops annotated before.
+	var lines [][]byte
+	for _, line := range bytes.SplitAfter(out, []byte("\n")) {
+		if !bytes.HasPrefix(bytes.TrimLeft(line, "\t "), []byte(copyPrefix)) {
+			lines = append(lines, line)
+		}
+	}
+	out = bytes.Join(lines, nil)
+
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "", out, parser.SkipObjectResolution)
+	if err != nil {
+		return nil, err
+	}
+	tf := fset.File(f.Pos())
+	notes := make(map[int]string) // by line
+	for _, body := range Bodies(f) {
+		for _, s := range body.List {
+			if _, ok := Rerolled(s); !ok {
+				continue
+			}
+			copies, _ := layout(s)
+			if len(copies) == 1 {
+				// Interleaved; see Config.Interleave.
+				continue
+			}
+			for k, c := range copies {
+				notes[rawLine(tf, c.Pos())] = fmt.Sprintf("%s%d/%d", copyPrefix, k+1, len(copies))
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	for i, line := range lines {
+		if note, ok := notes[i+1]; ok {
+			indent := line[:len(line)-len(bytes.TrimLeft(line, "\t "))]
+			fmt.Fprintf(&buf, "%s%s\n", indent, note)
+		}
+		buf.Write(line)
+	}
+	return buf.Bytes(), nil
+}
+
+// dropCopyComments removes the comments added by CopyComments in s, which is in f.
+func dropCopyComments(f *ast.File, s ast.Stmt) {
+	f.Comments = slices.DeleteFunc(f.Comments, func(g *ast.CommentGroup) bool {
+		return g.Pos() > s.Pos() && g.End() < s.End() && strings.HasPrefix(g.List[0].Text, copyPrefix)
+	})
+}
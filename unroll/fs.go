@@ -0,0 +1,71 @@
+package unroll
+
+import (
+	"bytes"
+	"context"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+// A FileWriter receives the files rewritten by Config.FS.
+type FileWriter interface {
+	// WriteFile writes data to the file name, a slash-separated path
+	// relative to the root of the fs.FS that the file was read from.
+	WriteFile(name string, data []byte) error
+}
+
+// FS unrolls the benchmark loops in the test files in fsys, walking it
+// as the go command walks a module: it skips testdata and vendor
+// directories and those whose names start with . or _.
+// It writes the files that change to w, leaving fsys alone,
+// so that fsys and w can be in memory, such as for a playground or a test.
+// It stops between files, without writing a partial result,
+// if ctx is canceled.
+// It returns the names of the files written.
+func (c *Config) FS(ctx context.Context, fsys fs.FS, w FileWriter) ([]string, error) {
+	var written []string
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		base := path.Base(name)
+		if d.IsDir() {
+			if name != "." && (base == "testdata" || base == "vendor" || strings.HasPrefix(base, ".") || strings.HasPrefix(base, "_")) {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasSuffix(base, "_test.go") || strings.HasPrefix(base, ".") || strings.HasPrefix(base, "_") {
+			return nil
+		}
+		src, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+		fset := token.NewFileSet()
+		f, err := parser.ParseFile(fset, name, src, parser.ParseComments)
+		if err != nil {
+			return err
+		}
+		if !c.File(fset, f) {
+			return nil
+		}
+		var buf bytes.Buffer
+		if err := printer.Fprint(&buf, fset, f); err != nil {
+			return err
+		}
+		if err := w.WriteFile(name, buf.Bytes()); err != nil {
+			return err
+		}
+		written = append(written, name)
+		return nil
+	})
+	return written, err
+}
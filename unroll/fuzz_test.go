@@ -0,0 +1,154 @@
+package unroll
+
+import (
+	"bytes"
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"go/types"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// Seeds adapted from std-lib benchmarks.
+var fuzzSeeds = []string{
+	`package big
+
+import "testing"
+
+type Word uint
+
+func bitLen(x Word) int { return 0 }
+
+func benchmarkBitLenN(b *testing.B, nbits uint) {
+	testword := Word((uint64(1) << nbits) - 1)
+	for i := 0; i < b.N; i++ {
+		bitLen(testword)
+	}
+}
+`,
+	`package strings
+
+import (
+	"strings"
+	"testing"
+)
+
+var benchInputHard = strings.Repeat("<hello>", 100)
+
+func BenchmarkIndexHard1(b *testing.B) {
+	b.SetBytes(int64(len(benchInputHard)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		strings.Index(benchInputHard, "<>")
+	}
+}
+`,
+	`package sort
+
+import (
+	"sort"
+	"testing"
+)
+
+func BenchmarkSortInt1K(b *testing.B) {
+	b.StopTimer()
+	for i := 0; i < b.N; i++ {
+		data := make([]int, 1<<10)
+		for i := 0; i < len(data); i++ {
+			data[i] = i ^ 0x2cc
+		}
+		b.StartTimer()
+		sort.Ints(data)
+		b.StopTimer()
+	}
+}
+`,
+	`package p
+
+import "testing"
+
+func BenchmarkLabel(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+	loop:
+		for {
+			break loop
+		}
+	}
+}
+`,
+	`package p
+
+import "testing"
+
+func BenchmarkShadow(b *testing.B) {
+	bNUnroll := 0
+	for i := 0; i < b.N; i++ {
+		bNUnroll += i
+	}
+	_ = bNUnroll
+}
+`,
+}
+
+var (
+	fuzzImporterMu sync.Mutex
+	fuzzImporter   = importer.ForCompiler(token.NewFileSet(), "source", nil)
+)
+
+// typecheck reports whether f type checks.
+func typecheck(fset *token.FileSet, f *ast.File) error {
+	fuzzImporterMu.Lock()
+	defer fuzzImporterMu.Unlock()
+	conf := types.Config{Importer: fuzzImporter}
+	_, err := conf.Check(f.Name.Name, fset, []*ast.File{f}, nil)
+	return err
+}
+
+func FuzzFile(f *testing.F) {
+	for _, seed := range fuzzSeeds {
+		f.Add(seed)
+	}
+	inputs, err := filepath.Glob(filepath.Join("testdata", "*.input"))
+	if err != nil {
+		f.Fatal(err)
+	}
+	for _, input := range inputs {
+		src, err := os.ReadFile(input)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(string(src))
+	}
+
+	f.Fuzz(func(t *testing.T, src string) {
+		fset := token.NewFileSet()
+		file, err := parser.ParseFile(fset, "fuzz_test.go", src, parser.ParseComments)
+		if err != nil || typecheck(fset, file) != nil {
+			t.Skip()
+		}
+
+		File(file)
+		var buf bytes.Buffer
+		if err := printer.Fprint(&buf, fset, file); err != nil {
+			t.Fatal(err)
+		}
+		out := buf.Bytes()
+
+		fset = token.NewFileSet()
+		file, err = parser.ParseFile(fset, "fuzz_test.go", out, parser.ParseComments)
+		if err != nil {
+			t.Fatalf("rewritten source does not parse: %v\n%s", err, out)
+		}
+		if err := typecheck(fset, file); err != nil {
+			t.Fatalf("rewritten source does not type check: %v\n%s", err, out)
+		}
+		if again := rewrite(t, "fuzz_test.go", out); !bytes.Equal(again, out) {
+			t.Fatalf("rewrite is not idempotent; first pass:\n%s\nsecond pass:\n%s", out, again)
+		}
+	})
+}
@@ -0,0 +1,33 @@
+package unroll
+
+import "go/ast"
+
+// HasGenerated reports whether n is or contains, at any depth,
+// a statement generated by Unrolled, recognized by its guard
+// and its bNUnroll marker. Rewriting such code would unroll it twice.
+func HasGenerated(n ast.Node) bool {
+	found := false
+	ast.Inspect(n, func(n ast.Node) bool {
+		if s, ok := n.(ast.Stmt); ok && !found {
+			if _, ok := Rerolled(s); ok {
+				found = true
+			}
+		}
+		return !found
+	})
+	return found
+}
+
+// funcLits returns the number of function literals in n,
+// not counting those inside others.
+func funcLits(n ast.Node) int {
+	count := 0
+	ast.Inspect(n, func(n ast.Node) bool {
+		if _, ok := n.(*ast.FuncLit); ok {
+			count++
+			return false
+		}
+		return true
+	})
+	return count
+}
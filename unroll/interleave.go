@@ -0,0 +1,110 @@
+package unroll
+
+import (
+	"go/ast"
+	"go/token"
+)
+
+// interleave rewrites s, a statement generated by Unrolled with factor copies
+// of body, to interleave the statements of the copies, as c.Interleave says:
+//
+//	for i, bNUnroll := 0, b.N / 3; i < bNUnroll; i++ {
+//		{
+//			x = f(x)
+//			x = f(x)
+//			x = f(x)
+//			y = g(y)
+//			y = g(y)
+//			y = g(y)
+//		}
+//	}
+func (c *Config) interleave(s ast.Stmt, body *ast.BlockStmt) ast.Stmt {
+	if !c.Interleave || body == nil || !interleavable(body) {
+		return s
+	}
+	block, _ := copiesBlock(s)
+	var list []ast.Stmt
+	for _, stmt := range body.List {
+		for range block.List {
+			list = append(list, stmt)
+		}
+	}
+	block.List = []ast.Stmt{&ast.BlockStmt{Lbrace: body.Lbrace, List: list, Rbrace: body.Rbrace}}
+	return s
+}
+
+// interleaved reports whether copies, the copies in a statement
+// generated by Unrolled that should number n, have been interleaved.
+func interleaved(copies []ast.Stmt, n int) bool {
+	return n > 1 && len(copies) == 1
+}
+
+// interleavable reports whether the statements of body can be interleaved
+// across copies without changing what the loop computes, as far as syntax
+// can tell: they are simple statements that declare nothing, and none assigns
+// a variable that another mentions. Calls are assumed not to interact.
+func interleavable(body *ast.BlockStmt) bool {
+	if len(body.List) < 2 {
+		return false
+	}
+	writes := make([]map[string]bool, len(body.List))
+	for i, s := range body.List {
+		writes[i] = make(map[string]bool)
+		switch s := s.(type) {
+		case *ast.ExprStmt:
+		case *ast.IncDecStmt:
+			writes[i][root(s.X)] = true
+			delete(writes[i], "_")
+		case *ast.AssignStmt:
+			if s.Tok == token.DEFINE {
+				return false
+			}
+			for _, x := range s.Lhs {
+				writes[i][root(x)] = true
+			}
+			delete(writes[i], "_")
+		default:
+			return false
+		}
+		if writes[i][""] {
+			// Through a pointer or the like.
+			return false
+		}
+	}
+	for i, s := range body.List {
+		conflict := false
+		ast.Inspect(s, func(n ast.Node) bool {
+			if id, ok := n.(*ast.Ident); ok {
+				for j := range body.List {
+					if j != i && writes[j][id.Name] {
+						conflict = true
+					}
+				}
+			}
+			return !conflict
+		})
+		if conflict {
+			return false
+		}
+	}
+	return true
+}
+
+// root returns the variable that assigning to x assigns to, or part of,
+// such as a for a.b[i], or "" if there is none, such as for *p.
+func root(x ast.Expr) string {
+	for {
+		switch e := x.(type) {
+		case *ast.Ident:
+			return e.Name
+		case *ast.SelectorExpr:
+			x = e.X
+		case *ast.IndexExpr:
+			x = e.X
+		case *ast.ParenExpr:
+			x = e.X
+		default:
+			return ""
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package unroll
+
+import (
+	"bytes"
+	"go/ast"
+	"go/printer"
+	"go/token"
+	"sort"
+	"strings"
+)
+
+// KeepHeader is the first line of the comment left by Config.KeepOriginal.
+const KeepHeader = "// Original benchmark loop, before unrolling:"
+
+// keepOriginal adds a comment containing s to f, directly above s.
+func keepOriginal(fset *token.FileSet, f *ast.File, s ast.Stmt) {
+	var buf bytes.Buffer
+	if err := printer.Fprint(&buf, fset, &printer.CommentedNode{Node: s, Comments: f.Comments}); err != nil {
+		// s came from f, so it is printable.
+		panic(err)
+	}
+	// The comment goes just before s, in its indentation.
+	pos := s.Pos() - 1
+	g := &ast.CommentGroup{List: []*ast.Comment{{Slash: pos, Text: KeepHeader}}}
+	for _, line := range strings.Split(buf.String(), "\n") {
+		g.List = append(g.List, &ast.Comment{Slash: pos, Text: strings.TrimRight("//\t"+line, "\t")})
+	}
+	i := sort.Search(len(f.Comments), func(i int) bool { return f.Comments[i].Pos() > pos })
+	f.Comments = append(f.Comments[:i], append([]*ast.CommentGroup{g}, f.Comments[i:]...)...)
+}
+
+// GeneratedStart returns the start of s, an unrolled loop in f,
+// including the comments generated along with it directly above:
+// the original loop left by KeepOriginal, the checksum from Checksums,
+// and a directive from LineDirectives. Reverting s replaces them too.
+func GeneratedStart(tf *token.File, f *ast.File, s ast.Stmt) token.Pos {
+	start := s.Pos()
+	line := rawLine(tf, s.Pos())
+	for _, g := range f.Comments {
+		if rawLine(tf, g.End()) != line-1 {
+			continue
+		}
+		for _, c := range g.List {
+			if c.Text == KeepHeader {
+				return c.Pos()
+			}
+			if strings.HasPrefix(c.Text, SumPrefix) || strings.HasPrefix(c.Text, linePrefix) && rawLine(tf, c.Pos()) == line-1 {
+				start = min(start, c.Pos())
+			}
+		}
+	}
+	return start
+}
+
+// dropOriginal removes the comment left by keepOriginal above s, if any,
+// along with the lines it occupied.
+func dropOriginal(tf *token.File, f *ast.File, s ast.Stmt) {
+	line := rawLine(tf, s.Pos())
+	for i, g := range f.Comments {
+		if rawLine(tf, g.End()) != line-1 {
+			continue
+		}
+		// Comments directly above ours end up in the same group.
+		for k, c := range g.List {
+			if c.Text != KeepHeader {
+				continue
+			}
+			start := rawLine(tf, c.Pos())
+			if k == 0 {
+				f.Comments = append(f.Comments[:i], f.Comments[i+1:]...)
+			} else {
+				g.List = g.List[:k]
+			}
+			for n := line - start; n > 0; n-- {
+				tf.MergeLine(start - 1)
+			}
+			return
+		}
+	}
+}
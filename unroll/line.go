@@ -0,0 +1,228 @@
+package unroll
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"strings"
+)
+
+// LineDirectives returns out, the result of unrolling src, with //line
+// directives that attribute the lines of each unrolled loop to the loop
+// in src: the original loop and each copy of its body to the loop's lines,
+// and the code around them to the loop's first line.
+// The lines after each loop are attributed to the lines they came from,
+// so that profiles and panics refer to the same lines before and after.
+// Copies of benchmarks added by Duplicate and Variants are attributed
+// to the benchmarks they copy, and each declaration to its own lines,
+// so that added code does not shift the lines after it.
+// file is the file name to use in the directives.
+func LineDirectives(file string, src, out []byte) ([]byte, error) {
+	// Start over, for loops unrolled with directives before.
+	var lines [][]byte
+	for _, line := range bytes.SplitAfter(out, []byte("\n")) {
+		if !bytes.HasPrefix(line, []byte(linePrefix+file+":")) {
+			lines = append(lines, line)
+		}
+	}
+	out = bytes.Join(lines, nil)
+
+	fset := token.NewFileSet()
+	sf, err := parser.ParseFile(fset, "", src, parser.SkipObjectResolution)
+	if err != nil {
+		return nil, err
+	}
+	of, err := parser.ParseFile(fset, "", out, parser.SkipObjectResolution)
+	if err != nil {
+		return nil, err
+	}
+	stf, otf := fset.File(sf.Pos()), fset.File(of.Pos())
+
+	// target maps lines in out to the lines in src they came from,
+	// as src's own directives, if any, say.
+	target := make(map[int]int)
+	decls := make(map[string]ast.Decl)
+	for _, d := range sf.Decls {
+		if k := declKey(d); k != "" {
+			decls[k] = d
+		}
+	}
+	for _, d := range of.Decls {
+		if o := decls[copied(declKey(d), decls)]; o != nil {
+			target[rawLine(otf, declPos(d))] = stf.Line(declPos(o))
+		}
+	}
+	before := Bodies(sf)
+	for name, body := range Bodies(of) {
+		top, lit, _ := strings.Cut(name, ".")
+		if orig := copied("func "+top, decls); orig != "func "+top {
+			name = strings.TrimPrefix(orig, "func ")
+			if lit != "" {
+				name += "." + lit
+			}
+		}
+		old := before[name]
+		if old == nil || len(old.List) != len(body.List) {
+			continue
+		}
+		for i, s := range body.List {
+			if _, ok := Rerolled(s); !ok {
+				continue
+			}
+			o := old.List[i]
+			if orig, ok := Rerolled(o); ok {
+				// Unrolled again with a new factor.
+				o = orig
+			}
+			last := stf.Line(o.End())
+			if seq, ok := Sequential(o); ok {
+				// Attribute the loop to the pb.Next loop,
+				// which has the body's layout.
+				o = seq
+			}
+			if ok, _, _ := IsBenchForLoop(o); !ok {
+				continue
+			}
+			first := stf.Line(o.Pos())
+			start, end := rawLine(otf, s.Pos()), rawLine(otf, s.End())
+			for l := start; l < end; l++ {
+				target[l] = first
+			}
+			target[end] = last
+			// The original loop and the copies of its body
+			// are laid out like the loop in src.
+			like := func(n ast.Node) {
+				for l := rawLine(otf, n.Pos()); l <= rawLine(otf, n.End()); l++ {
+					target[l] = first + l - rawLine(otf, n.Pos())
+				}
+			}
+			ifs := s.(*ast.IfStmt)
+			like(ifs.Body.List[0])
+			copies, _ := layout(s)
+			for _, c := range copies {
+				like(c)
+				// Number a comment from CopyComments as the line before the copy,
+				// so that they share a directive.
+				if l := rawLine(otf, c.Pos()) - 1; bytes.HasPrefix(bytes.TrimLeft(lines[l-1], "\t "), []byte(copyPrefix)) {
+					target[l] = first - 1
+				}
+			}
+		}
+	}
+
+	// Add a directive wherever the numbering would otherwise go wrong.
+	var buf bytes.Buffer
+	next := 1 // what the next line is numbered
+	for i, line := range lines {
+		t, ok := target[i+1]
+		if !ok {
+			t = next
+		}
+		if t != next {
+			fmt.Fprintf(&buf, "%s%s:%d\n", linePrefix, file, t)
+		}
+		buf.Write(line)
+		next = t + 1
+	}
+	return buf.Bytes(), nil
+}
+
+// declKey identifies d among the declarations of a file,
+// by the name of the function or the first thing it declares.
+func declKey(d ast.Decl) string {
+	switch d := d.(type) {
+	case *ast.FuncDecl:
+		if d.Recv != nil {
+			return "" // methods are not benchmarks; leave them be
+		}
+		return "func " + d.Name.Name
+	case *ast.GenDecl:
+		if len(d.Specs) == 0 {
+			return ""
+		}
+		switch s := d.Specs[0].(type) {
+		case *ast.ImportSpec:
+			return "import " + s.Path.Value
+		case *ast.TypeSpec:
+			return "type " + s.Name.Name
+		case *ast.ValueSpec:
+			return d.Tok.String() + " " + s.Names[0].Name
+		}
+	}
+	return ""
+}
+
+// copied returns key, the key of a declaration in the result of unrolling,
+// or if decls, those in the source, has no such declaration and key is that of
+// a copy added by Duplicate or Variants, the key of the benchmark it copies:
+// the longest benchmark name that the copy's name extends.
+func copied(key string, decls map[string]ast.Decl) string {
+	if _, ok := decls[key]; ok || !strings.HasPrefix(key, "func Benchmark") {
+		return key
+	}
+	orig := key
+	for k, d := range decls {
+		fn, ok := d.(*ast.FuncDecl)
+		if ok && IsBench(fn) && strings.HasPrefix(key, k) && (orig == key || len(k) > len(orig)) {
+			orig = k
+		}
+	}
+	return orig
+}
+
+// declPos returns the start of d, including its doc comment.
+func declPos(d ast.Decl) token.Pos {
+	switch d := d.(type) {
+	case *ast.FuncDecl:
+		if d.Doc != nil {
+			return d.Doc.Pos()
+		}
+	case *ast.GenDecl:
+		if d.Doc != nil {
+			return d.Doc.Pos()
+		}
+	}
+	return d.Pos()
+}
+
+const linePrefix = "//line "
+
+// rawLine returns the line of p in tf, ignoring any //line directives.
+func rawLine(tf *token.File, p token.Pos) int {
+	return tf.PositionFor(p, false).Line
+}
+
+// dropLineDirectives removes the //line directives added by LineDirectives
+// for s, which is in f, along with the line of the one directly above s.
+func dropLineDirectives(tf *token.File, f *ast.File, s ast.Stmt) {
+	line := rawLine(tf, s.Pos())
+	above := tf.LineStart(max(line-1, 1))
+	merge := false
+	var comments []*ast.CommentGroup
+	for _, g := range f.Comments {
+		if g.End() < above || g.Pos() > s.End() {
+			comments = append(comments, g)
+			continue
+		}
+		var list []*ast.Comment
+		for _, c := range g.List {
+			if !strings.HasPrefix(c.Text, linePrefix) || c.Pos() < above {
+				list = append(list, c)
+				continue
+			}
+			if rawLine(tf, c.Pos()) == line-1 {
+				merge = true
+			}
+		}
+		if len(list) > 0 {
+			g.List = list
+			comments = append(comments, g)
+		}
+	}
+	f.Comments = comments
+	if merge {
+		tf.MergeLine(line - 1)
+	}
+}
@@ -0,0 +1,93 @@
+package unroll
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+)
+
+// BenchmarkLiterals unrolls the benchmark loops in the function literals
+// passed to testing.Benchmark in f, leaving benchmark functions alone.
+// It reports whether any loops were rewritten.
+func (c *Config) BenchmarkLiterals(fset *token.FileSet, f *ast.File) bool {
+	changed := false
+	for _, d := range f.Decls {
+		fn, ok := d.(*ast.FuncDecl)
+		if !ok || fn.Body == nil {
+			continue
+		}
+		_, lits := LiteralsIn(fn)
+		for _, lit := range lits {
+			if c.body(fset, f, fn, lit.Body) {
+				changed = true
+			}
+		}
+	}
+	return changed
+}
+
+// Bodies returns the bodies of the benchmarks in f, and of the
+// function literals passed to testing.Benchmark, keyed by function name.
+func Bodies(f *ast.File) map[string]*ast.BlockStmt {
+	m := make(map[string]*ast.BlockStmt)
+	for _, d := range f.Decls {
+		fn, ok := d.(*ast.FuncDecl)
+		if !ok || fn.Recv != nil || fn.Body == nil {
+			continue
+		}
+		if IsBench(fn) {
+			m[fn.Name.Name] = fn.Body
+		}
+		names, lits := LiteralsIn(fn)
+		for i, lit := range lits {
+			m[names[i]] = lit.Body
+		}
+	}
+	return m
+}
+
+// LiteralsIn returns the function literals in fn that are passed
+// to testing.Benchmark, with the names the compiler gives them,
+// such as main.func1.
+// Literals inside other literals, or inside generated code,
+// which would be unrolled already, are not included.
+func LiteralsIn(fn *ast.FuncDecl) (names []string, lits []*ast.FuncLit) {
+	if fn.Body == nil {
+		return nil, nil
+	}
+	n := 0
+	var bench *ast.CallExpr // the testing.Benchmark call being visited
+	ast.Inspect(fn.Body, func(node ast.Node) bool {
+		switch node := node.(type) {
+		case ast.Stmt:
+			if _, ok := Rerolled(node); ok {
+				// Keep the count, so that later literals keep their names.
+				n += funcLits(node)
+				return false
+			}
+		case *ast.CallExpr:
+			if isTestingBenchmark(node) {
+				bench = node
+			}
+		case *ast.FuncLit:
+			n++
+			if bench != nil && len(bench.Args) == 1 && bench.Args[0] == node && hasB(node.Type.Params) {
+				names = append(names, fmt.Sprintf("%s.func%d", fn.Name.Name, n))
+				lits = append(lits, node)
+			}
+			return false
+		}
+		return true
+	})
+	return names, lits
+}
+
+// isTestingBenchmark reports whether call calls testing.Benchmark.
+func isTestingBenchmark(call *ast.CallExpr) bool {
+	sel, ok := call.Fun.(*ast.SelectorExpr)
+	if !ok || sel.Sel.Name != "Benchmark" {
+		return false
+	}
+	id, ok := sel.X.(*ast.Ident)
+	return ok && id.Name == "testing"
+}
@@ -0,0 +1,54 @@
+package unroll
+
+import (
+	"go/ast"
+	"go/token"
+)
+
+// Modernize rewrites the benchmark loops in f whose loop variable
+// is not used in their bodies, as recognized by IsBenchForLoop,
+// into the range-over-int form, for range b.N, added in Go 1.22.
+// Unrolled loops are left alone. It does not unroll anything,
+// and the loops it writes are not recognized by IsBenchForLoop.
+// It reports whether any loops were rewritten.
+func Modernize(fset *token.FileSet, f *ast.File) bool {
+	changed := false
+	for _, body := range Bodies(f) {
+		for i, s := range body.List {
+			if r, ok := RangeOverInt(s); ok {
+				body.List[i] = r
+				changed = true
+			}
+		}
+	}
+	return changed
+}
+
+// RangeOverInt returns s, a benchmark loop, as a range over b.N,
+// if its loop variable is not used in its body.
+func RangeOverInt(s ast.Stmt) (ast.Stmt, bool) {
+	ok, id, body := IsBenchForLoop(s)
+	if !ok || HasGenerated(s) || mentions(body, id) {
+		return nil, false
+	}
+	f := s.(*ast.ForStmt)
+	return &ast.RangeStmt{
+		For:   f.For,
+		Range: f.For,
+		Tok:   token.ILLEGAL,
+		X:     f.Cond.(*ast.BinaryExpr).Y,
+		Body:  body,
+	}, true
+}
+
+// mentions reports whether n mentions an identifier named name.
+func mentions(n ast.Node, name string) bool {
+	found := false
+	ast.Inspect(n, func(n ast.Node) bool {
+		if id, ok := n.(*ast.Ident); ok && id.Name == name {
+			found = true
+		}
+		return !found
+	})
+	return found
+}
@@ -0,0 +1,103 @@
+package unroll
+
+import (
+	"go/ast"
+	"go/token"
+)
+
+// split returns how c lays out factor copies of a loop body:
+// a block of copies, run repeat times per iteration of the unrolled loop.
+// It uses the largest block of at most MaxCopies copies that divides factor,
+// so that the factor is exact, and a single block if there is none.
+func (c *Config) split(factor int) (copies, repeat int) {
+	if c.MaxCopies <= 0 || factor <= c.MaxCopies {
+		return factor, 1
+	}
+	for k := c.MaxCopies; k >= 2; k-- {
+		if factor%k == 0 {
+			return k, factor / k
+		}
+	}
+	return factor, 1
+}
+
+// nest rewrites s, a statement generated by Unrolled with factor copies,
+// to hold its copies as laid out by c.split.
+// For factor 64 and MaxCopies 16, the unrolled loop becomes:
+//
+//	for i, bNUnroll := 0, b.N / 64; i < bNUnroll; i++ {
+//		for bNRepeat := 0; bNRepeat < 4; bNRepeat++ {
+//			{
+//				// body
+//			}
+//			// repeat 15 more times
+//		}
+//	}
+func (c *Config) nest(s ast.Stmt, factor int) ast.Stmt {
+	copies, repeat := c.split(factor)
+	if repeat == 1 {
+		return s
+	}
+	loop := s.(*ast.IfStmt).Else.(*ast.BlockStmt).List[0].(*ast.ForStmt)
+	// Unrolled positions the loop's header at the start of the original loop.
+	pos := loop.Init.Pos()
+	loop.Body.List = []ast.Stmt{
+		&ast.ForStmt{
+			For: pos,
+			Init: &ast.AssignStmt{
+				Lhs:    []ast.Expr{ident(pos, "bNRepeat")},
+				TokPos: pos,
+				Tok:    token.DEFINE,
+				Rhs:    []ast.Expr{basicInt(pos, 0)},
+			},
+			Cond: &ast.BinaryExpr{
+				X:     ident(pos, "bNRepeat"),
+				OpPos: pos,
+				Y:     basicInt(pos, repeat),
+				Op:    token.LSS,
+			},
+			Post: &ast.IncDecStmt{X: ident(pos, "bNRepeat"), TokPos: pos, Tok: token.INC},
+			Body: &ast.BlockStmt{Lbrace: pos, List: loop.Body.List[:copies], Rbrace: loop.Body.Rbrace},
+		},
+	}
+	return s
+}
+
+// laidOut reports whether s, a statement generated by Unrolled
+// with factor copies, repeats them as c would.
+// Hand-edited copies are left alone, as they always have been.
+func (c *Config) laidOut(s ast.Stmt, factor int) bool {
+	copies, repeat := layout(s)
+	n, want := c.split(factor)
+	if repeat != want {
+		return false
+	}
+	orig, _ := Rerolled(s)
+	_, _, body := IsBenchForLoop(orig)
+	return interleaved(copies, n) == (c.Interleave && body != nil && interleavable(body))
+}
+
+// layout returns the copies of the loop body in s, a statement generated
+// by Unrolled, and how many times they are repeated per iteration.
+func layout(s ast.Stmt) (copies []ast.Stmt, repeat int) {
+	block, repeat := copiesBlock(s)
+	return block.List, repeat
+}
+
+// copiesBlock returns the block holding the copies of the loop body in s,
+// a statement generated by Unrolled, and how many times it is repeated
+// per iteration.
+func copiesBlock(s ast.Stmt) (block *ast.BlockStmt, repeat int) {
+	loop := s.(*ast.IfStmt).Else.(*ast.BlockStmt).List[0].(*ast.ForStmt)
+	list := loop.Body.List
+	if len(list) == 1 {
+		if inner, ok := list[0].(*ast.ForStmt); ok {
+			init, ok1 := inner.Init.(*ast.AssignStmt)
+			cond, ok2 := inner.Cond.(*ast.BinaryExpr)
+			if ok1 && ok2 && len(init.Lhs) == 1 && isIdent(init.Lhs[0], "bNRepeat") {
+				return inner.Body, intLit(cond.Y)
+			}
+		}
+	}
+	return loop.Body, 1
+}
@@ -0,0 +1,223 @@
+package unroll
+
+import (
+	"go/ast"
+	"go/token"
+	"reflect"
+	"slices"
+	"strconv"
+)
+
+// Normalize rewrites the hand-unrolled benchmark loops in f
+// into the form generated by Unrolled, with the same factor,
+// so that they can be rerolled and unrolled like any other.
+// It also repairs generated loops whose guard and copies disagree.
+// It reports whether any loops were rewritten.
+func Normalize(fset *token.FileSet, f *ast.File) bool {
+	tf := fset.File(f.Pos())
+	changed := false
+	for _, d := range f.Decls {
+		fn, ok := d.(*ast.FuncDecl)
+		if !ok || !IsBench(fn) {
+			continue
+		}
+		for i, s := range fn.Body.List {
+			loop, factor, ok := Manual(s)
+			if !ok || HasGenerated(loop) {
+				continue
+			}
+			if _, generated := Rerolled(s); generated {
+				dropChecksum(tf, f, s)
+				dropCopyComments(f, s)
+				squash(tf, s, loop)
+			} else {
+				// Drop the other copies' comments, and squash their lines
+				// into the body's last line, so that the loop does not print
+				// with a gap where they were.
+				body := loop.(*ast.ForStmt).Body
+				last := rawLine(tf, body.List[len(body.List)-1].End())
+				f.Comments = slices.DeleteFunc(f.Comments, func(g *ast.CommentGroup) bool {
+					return g.Pos() < body.Rbrace && rawLine(tf, g.Pos()) > last
+				})
+				for n := rawLine(tf, body.Rbrace) - last - 1; n > 0; n-- {
+					tf.MergeLine(last)
+				}
+			}
+			_, id, body := IsBenchForLoop(loop)
+			fn.Body.List[i] = Unrolled(loop.(*ast.ForStmt), id, body, factor)
+			changed = true
+		}
+	}
+	return changed
+}
+
+// Manual reports whether s, a top level statement in a benchmark,
+// is a benchmark loop unrolled by hand, and if so, returns
+// the loop rolled back up and the number of copies of its body.
+// It recognizes loops of the forms
+//
+//	for i := 0; i < b.N/k; i++ {
+//		// k copies of body
+//	}
+//
+//	for i := 0; i < b.N; i += k {
+//		// k copies of body
+//	}
+//
+// and loops generated by Unrolled whose guard or count
+// do not match their number of copies.
+func Manual(s ast.Stmt) (loop ast.Stmt, factor int, ok bool) {
+	if orig, ok := Rerolled(s); ok {
+		ifs := s.(*ast.IfStmt)
+		guard := Factor(s)
+		unrolled := ifs.Else.(*ast.BlockStmt).List[0].(*ast.ForStmt)
+		list, repeat := layout(s)
+		copies := len(list) * repeat
+		div := unrolled.Init.(*ast.AssignStmt).Rhs[1].(*ast.BinaryExpr)
+		divisor := intLit(div.Y)
+		if id, ok := div.Y.(*ast.Ident); ok && isIdent(ifs.Cond.(*ast.BinaryExpr).Y, id.Name) {
+			// Both name the constant that Factor takes to be copies.
+			divisor = guard
+		}
+		if copies < 2 || guard == copies && div.Op == token.QUO && divisor == copies {
+			return nil, 0, false
+		}
+		return orig, copies, true
+	}
+
+	f, ok := s.(*ast.ForStmt)
+	if !ok || f.Init == nil || f.Cond == nil || f.Post == nil {
+		return nil, 0, false
+	}
+	init, ok := f.Init.(*ast.AssignStmt)
+	if !ok || init.Tok != token.DEFINE || len(init.Lhs) != 1 || len(init.Rhs) != 1 || intLit(init.Rhs[0]) != 0 {
+		return nil, 0, false
+	}
+	id, ok := init.Lhs[0].(*ast.Ident)
+	if !ok {
+		return nil, 0, false
+	}
+	cond, ok := f.Cond.(*ast.BinaryExpr)
+	if !ok || cond.Op != token.LSS || !isIdent(cond.X, id.Name) {
+		return nil, 0, false
+	}
+	post := &ast.IncDecStmt{X: ident(f.For, id.Name), TokPos: f.For, Tok: token.INC}
+	switch p := f.Post.(type) {
+	case *ast.IncDecStmt:
+		// i < b.N/k; i++
+		div, ok := cond.Y.(*ast.BinaryExpr)
+		if p.Tok != token.INC || !isIdent(p.X, id.Name) || !ok || div.Op != token.QUO || !isBN(div.X) {
+			return nil, 0, false
+		}
+		factor = intLit(div.Y)
+		post = p
+	case *ast.AssignStmt:
+		// i < b.N; i += k
+		if p.Tok != token.ADD_ASSIGN || len(p.Lhs) != 1 || !isIdent(p.Lhs[0], id.Name) || !isBN(cond.Y) {
+			return nil, 0, false
+		}
+		factor = intLit(p.Rhs[0])
+	default:
+		return nil, 0, false
+	}
+	body, ok := copies(f.Body, factor)
+	if !ok {
+		return nil, 0, false
+	}
+	return &ast.ForStmt{
+		For:  f.For,
+		Init: f.Init,
+		Cond: &ast.BinaryExpr{
+			X:     cond.X,
+			OpPos: cond.OpPos,
+			Op:    token.LSS,
+			Y:     &ast.SelectorExpr{X: ident(cond.OpPos, "b"), Sel: ident(cond.OpPos, "N")},
+		},
+		Post: post,
+		Body: body,
+	}, factor, true
+}
+
+// copies returns the body of which block is factor copies, if it is.
+func copies(block *ast.BlockStmt, factor int) (*ast.BlockStmt, bool) {
+	list := block.List
+	if factor < 2 || len(list) == 0 || len(list)%factor != 0 {
+		return nil, false
+	}
+	n := len(list) / factor
+	for i := n; i < len(list); i++ {
+		if !equal(reflect.ValueOf(list[i%n]), reflect.ValueOf(list[i])) {
+			return nil, false
+		}
+	}
+	body := list[:n]
+	if b, ok := list[0].(*ast.BlockStmt); ok && n == 1 {
+		// The copies are blocks, as Unrolled writes them.
+		body = b.List
+	}
+	if len(body) == 0 {
+		return nil, false
+	}
+	return &ast.BlockStmt{Lbrace: block.Lbrace, List: body, Rbrace: block.Rbrace}, true
+}
+
+var posType = reflect.TypeOf(token.NoPos)
+
+// equal reports whether x and y are the same syntax, ignoring positions.
+func equal(x, y reflect.Value) bool {
+	if x.Kind() != y.Kind() {
+		return false
+	}
+	switch x.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if x.IsNil() || y.IsNil() {
+			return x.IsNil() == y.IsNil()
+		}
+		if x.Type() == objectType || x.Type() == scopeType {
+			return true
+		}
+		return x.Elem().Type() == y.Elem().Type() && equal(x.Elem(), y.Elem())
+	case reflect.Slice:
+		if x.Len() != y.Len() {
+			return false
+		}
+		for i := 0; i < x.Len(); i++ {
+			if !equal(x.Index(i), y.Index(i)) {
+				return false
+			}
+		}
+		return true
+	case reflect.Struct:
+		for i := 0; i < x.NumField(); i++ {
+			if x.Field(i).Type() != posType && !equal(x.Field(i), y.Field(i)) {
+				return false
+			}
+		}
+		return true
+	case reflect.String:
+		return x.String() == y.String()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return x.Int() == y.Int()
+	case reflect.Bool:
+		return x.Bool() == y.Bool()
+	}
+	return false
+}
+
+// intLit returns the value of x if it is an integer literal, and -1 otherwise.
+func intLit(x ast.Expr) int {
+	lit, ok := x.(*ast.BasicLit)
+	if !ok || lit.Kind != token.INT {
+		return -1
+	}
+	n, err := strconv.Atoi(lit.Value)
+	if err != nil {
+		return -1
+	}
+	return n
+}
+
+func isIdent(x ast.Expr, name string) bool {
+	id, ok := x.(*ast.Ident)
+	return ok && id.Name == name
+}
@@ -0,0 +1,91 @@
+package unroll
+
+import (
+	"go/ast"
+	"go/token"
+)
+
+// Sequential returns a loop over b.N equivalent to s, if s is
+// a parallel benchmark of the form
+//
+//	b.RunParallel(func(pb *testing.PB) {
+//		for pb.Next() {
+//			// body
+//		}
+//	})
+//
+// in which body does not mention pb. The loop,
+//
+//	for i := 0; i < b.N; i++ {
+//		// body
+//	}
+//
+// runs the same b.N iterations on a single goroutine, so that
+// it measures the cost of each one alone, and can be unrolled.
+// Functions with setup before the pb.Next loop are left alone,
+// since moving the setup would change the scope of its variables.
+func Sequential(s ast.Stmt) (ast.Stmt, bool) {
+	es, ok := s.(*ast.ExprStmt)
+	if !ok {
+		return nil, false
+	}
+	call, ok := es.X.(*ast.CallExpr)
+	if !ok || len(call.Args) != 1 {
+		return nil, false
+	}
+	sel, ok := call.Fun.(*ast.SelectorExpr)
+	if !ok || sel.Sel.Name != "RunParallel" {
+		return nil, false
+	}
+	if x, ok := sel.X.(*ast.Ident); !ok || x.Name != "b" {
+		return nil, false
+	}
+	lit, ok := call.Args[0].(*ast.FuncLit)
+	if !ok || len(lit.Type.Params.List) != 1 || len(lit.Type.Params.List[0].Names) != 1 || len(lit.Body.List) != 1 {
+		return nil, false
+	}
+	pb := lit.Type.Params.List[0].Names[0].Name
+	loop, ok := lit.Body.List[0].(*ast.ForStmt)
+	if !ok || loop.Init != nil || loop.Post != nil {
+		return nil, false
+	}
+	next, ok := loop.Cond.(*ast.CallExpr)
+	if !ok || len(next.Args) != 0 {
+		return nil, false
+	}
+	nsel, ok := next.Fun.(*ast.SelectorExpr)
+	if !ok || nsel.Sel.Name != "Next" {
+		return nil, false
+	}
+	if x, ok := nsel.X.(*ast.Ident); !ok || x.Name != pb {
+		return nil, false
+	}
+	if mentions(loop.Body, pb) {
+		return nil, false
+	}
+	name := unusedName(loop.Body)
+	if name == "" {
+		return nil, false
+	}
+
+	// All new nodes are positioned at the start of the pb.Next loop,
+	// as in Unrolled, so that squashing s's lines onto it prints it in place.
+	pos := loop.For
+	return &ast.ForStmt{
+		For: pos,
+		Init: &ast.AssignStmt{
+			Lhs:    []ast.Expr{ident(pos, name)},
+			TokPos: pos,
+			Tok:    token.DEFINE,
+			Rhs:    []ast.Expr{basicInt(pos, 0)},
+		},
+		Cond: &ast.BinaryExpr{
+			X:     ident(pos, name),
+			OpPos: pos,
+			Op:    token.LSS,
+			Y:     &ast.SelectorExpr{X: ident(pos, "b"), Sel: ident(pos, "N")},
+		},
+		Post: &ast.IncDecStmt{X: ident(pos, name), TokPos: pos, Tok: token.INC},
+		Body: loop.Body,
+	}, true
+}
@@ -0,0 +1,74 @@
+package unroll
+
+import "go/ast"
+
+// A Pattern is a shape of benchmark loop that can be unrolled.
+//
+// The built-in pattern, named "for", matches loops of the form
+//
+//	for i := 0; i < b.N; i++ {
+//		// body
+//	}
+//
+// Additional patterns let File, Func, Applier, and the analyzer
+// recognize project-specific helpers, such as
+//
+//	benchx.Each(b, func() {
+//		// body
+//	})
+type Pattern struct {
+	// Name identifies the pattern.
+	Name string
+
+	// Match reports whether s, a top level statement
+	// in a benchmark, has this shape.
+	Match func(s ast.Stmt) bool
+
+	// Unroll returns the unrolled replacement for s,
+	// which Match has accepted, with factor copies of its body.
+	Unroll func(s ast.Stmt, factor int) ast.Stmt
+}
+
+var patterns []Pattern
+
+// Register adds p to the patterns that are unrolled.
+// Patterns are tried in the order in which they were registered.
+// Register is not safe for concurrent use;
+// it is typically called from an init function.
+func Register(p Pattern) {
+	if p.Match == nil || p.Unroll == nil {
+		panic("unroll: Register of incomplete pattern " + p.Name)
+	}
+	patterns = append(patterns, p)
+}
+
+func init() {
+	Register(Pattern{
+		Name: "for",
+		Match: func(s ast.Stmt) bool {
+			ok, _, _ := IsBenchForLoop(s)
+			return ok
+		},
+		Unroll: func(s ast.Stmt, factor int) ast.Stmt {
+			_, id, body := IsBenchForLoop(s)
+			return Unrolled(s.(*ast.ForStmt), id, body, factor)
+		},
+	})
+}
+
+// Unroll returns the unrolled replacement for s,
+// a top level statement in a benchmark,
+// with factor copies of its body,
+// if s matches a registered pattern.
+// Baseline loops never match.
+func Unroll(s ast.Stmt, factor int) (ast.Stmt, bool) {
+	if Baseline(s) {
+		return nil, false
+	}
+	for _, p := range patterns {
+		if p.Match(s) {
+			return p.Unroll(s, factor), true
+		}
+	}
+	return nil, false
+}
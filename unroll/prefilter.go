@@ -0,0 +1,24 @@
+package unroll
+
+import "bytes"
+
+// DefaultMarkers are strings that files with benchmarks or their loops
+// almost always contain, for use with MayContain.
+var DefaultMarkers = []string{"testing.B", "b.N"}
+
+// MayContain reports whether src, the contents of a file, contains
+// one of markers, and so might hold benchmarks worth parsing it for.
+// It is much cheaper than parsing src, and most test files have no benchmarks.
+// Markers in comments count too, so it errs on the side of parsing.
+// With no markers, every file might hold benchmarks.
+func MayContain(src []byte, markers []string) bool {
+	if len(markers) == 0 {
+		return true
+	}
+	for _, m := range markers {
+		if m != "" && bytes.Contains(src, []byte(m)) {
+			return true
+		}
+	}
+	return false
+}
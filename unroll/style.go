@@ -0,0 +1,208 @@
+package unroll
+
+import (
+	"go/ast"
+	"go/token"
+)
+
+// Benchmark loop styles, as reported by Style.
+const (
+	StyleClassic  = "classic"  // for i := 0; i < b.N; i++
+	StyleRange    = "range"    // for range b.N, or for i := range b.N
+	StyleLoop     = "loop"     // for b.Loop()
+	StyleUnrolled = "unrolled" // generated by Unrolled
+)
+
+// Style reports the style of s, a top level statement in a benchmark,
+// and for StyleUnrolled, its factor. It returns "" if s is not a benchmark loop.
+// Classic loops are those recognized by IsBenchForLoop.
+func Style(s ast.Stmt) (style string, factor int) {
+	if _, ok := Rerolled(s); ok {
+		return StyleUnrolled, Factor(s)
+	}
+	if ok, _, _ := IsBenchForLoop(s); ok {
+		return StyleClassic, 0
+	}
+	switch s := s.(type) {
+	case *ast.RangeStmt:
+		if !isBN(s.X) || s.Value != nil {
+			break
+		}
+		if _, ok := s.Key.(*ast.Ident); s.Key == nil || ok && s.Tok == token.DEFINE {
+			return StyleRange, 0
+		}
+	case *ast.ForStmt:
+		if s.Init != nil || s.Post != nil {
+			break
+		}
+		call, ok := s.Cond.(*ast.CallExpr)
+		if !ok || len(call.Args) != 0 {
+			break
+		}
+		if sel, ok := call.Fun.(*ast.SelectorExpr); ok && sel.Sel.Name == "Loop" {
+			if x, ok := sel.X.(*ast.Ident); ok && x.Name == "b" {
+				return StyleLoop, 0
+			}
+		}
+	}
+	return "", 0
+}
+
+// Restyle rewrites the benchmark loops in f, including those in
+// function literals passed to testing.Benchmark, into style,
+// unrolling them as c configures for StyleUnrolled.
+// Loops that would measure something else in the new style are
+// left alone: b.Loop loops, which time only the loop, and for StyleLoop,
+// loops that use their loop variable or that share their function
+// with other loops, uses of b.N, or timer calls; see onlyLoop.
+// Unrolled loops in other styles are rerolled first.
+// It reports whether any loops were rewritten.
+func (c *Config) Restyle(fset *token.FileSet, f *ast.File, style string) bool {
+	tf := fset.File(f.Pos())
+	changed := false
+	for _, body := range Bodies(f) {
+		if style != StyleUnrolled && reroll(tf, f, body) {
+			changed = true
+		}
+		loop := style == StyleLoop && onlyLoop(body)
+		for i, s := range body.List {
+			from, _ := Style(s)
+			if from == StyleRange && style != StyleRange && (style != StyleLoop || loop) {
+				if n := classicLoop(s.(*ast.RangeStmt)); n != nil {
+					body.List[i], s = n, n
+					from, _ = Style(s)
+					changed = true
+				}
+			}
+			var n ast.Stmt
+			switch {
+			case from == StyleClassic && style == StyleRange:
+				n = rangeLoop(s)
+			case from == StyleClassic && loop:
+				n = bLoop(s)
+			}
+			if n != nil {
+				body.List[i] = n
+				changed = true
+			}
+		}
+	}
+	if style == StyleUnrolled {
+		if c.File(fset, f) {
+			changed = true
+		}
+		if !c.Literals && c.BenchmarkLiterals(fset, f) {
+			changed = true
+		}
+	}
+	return changed
+}
+
+// classicLoop returns the classic form of s, a range over b.N.
+func classicLoop(s *ast.RangeStmt) ast.Stmt {
+	name := ""
+	if s.Key != nil {
+		name = s.Key.(*ast.Ident).Name
+	} else if name = unusedName(s.Body); name == "" {
+		return nil
+	}
+	pos := s.For
+	return &ast.ForStmt{
+		For: pos,
+		Init: &ast.AssignStmt{
+			Lhs:    []ast.Expr{ident(pos, name)},
+			TokPos: pos,
+			Tok:    token.DEFINE,
+			Rhs:    []ast.Expr{basicInt(pos, 0)},
+		},
+		Cond: &ast.BinaryExpr{
+			X:     ident(pos, name),
+			OpPos: pos,
+			Op:    token.LSS,
+			Y:     &ast.SelectorExpr{X: ident(pos, "b"), Sel: ident(pos, "N")},
+		},
+		Post: &ast.IncDecStmt{X: ident(pos, name), TokPos: pos, Tok: token.INC},
+		Body: s.Body,
+	}
+}
+
+// rangeLoop returns s, a classic benchmark loop, as a range over b.N,
+// keeping its loop variable if its body uses it.
+func rangeLoop(s ast.Stmt) ast.Stmt {
+	if r, ok := RangeOverInt(s); ok {
+		return r
+	}
+	_, id, body := IsBenchForLoop(s)
+	f := s.(*ast.ForStmt)
+	return &ast.RangeStmt{
+		For:    f.For,
+		Key:    ident(f.For, id),
+		TokPos: f.For,
+		Tok:    token.DEFINE,
+		Range:  f.For,
+		X:      f.Cond.(*ast.BinaryExpr).Y,
+		Body:   body,
+	}
+}
+
+// bLoop returns s, a classic benchmark loop, as a b.Loop loop,
+// or nil if its body uses its loop variable.
+func bLoop(s ast.Stmt) ast.Stmt {
+	_, id, body := IsBenchForLoop(s)
+	if mentions(body, id) {
+		return nil
+	}
+	pos := s.Pos()
+	return &ast.ForStmt{
+		For: pos,
+		Cond: &ast.CallExpr{
+			Fun:    &ast.SelectorExpr{X: ident(pos, "b"), Sel: ident(pos, "Loop")},
+			Lparen: pos,
+			Rparen: pos,
+		},
+		Body: body,
+	}
+}
+
+// onlyLoop reports whether body has a single benchmark loop and
+// otherwise neither uses b.N nor calls the timer methods.
+// Only then does a b.Loop loop measure the same thing: b.Loop manages
+// the timer itself and may run only once per call, so timer calls and
+// second loops fail, and setup sized by b.N is no longer amortized.
+func onlyLoop(body *ast.BlockStmt) bool {
+	loops := 0
+	for _, s := range body.List {
+		if style, _ := Style(s); style != "" {
+			loops++
+		}
+	}
+	uses, timer := 0, false
+	ast.Inspect(body, func(n ast.Node) bool {
+		sel, ok := n.(*ast.SelectorExpr)
+		if !ok {
+			return true
+		}
+		if isBN(sel) {
+			uses++
+		}
+		if x, ok := sel.X.(*ast.Ident); ok && x.Name == "b" {
+			switch sel.Sel.Name {
+			case "ResetTimer", "StartTimer", "StopTimer":
+				timer = true
+			}
+		}
+		return true
+	})
+	return loops == 1 && uses == 1 && !timer
+}
+
+// unusedName returns a short loop variable name not mentioned in n,
+// or "" if there is none.
+func unusedName(n ast.Node) string {
+	for _, name := range []string{"i", "j", "k", "n"} {
+		if !mentions(n, name) {
+			return name
+		}
+	}
+	return ""
+}
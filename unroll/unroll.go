@@ -0,0 +1,669 @@
+// Package unroll detects benchmark loops and rewrites them
+// so that loop overhead is amortized over several copies of the body.
+package unroll
+
+import (
+	"bytes"
+	"go/ast"
+	"go/printer"
+	"go/token"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// DefaultFactor is the number of copies of the loop body
+// in an unrolled loop, unless configured otherwise.
+const DefaultFactor = 10
+
+// A Config controls how benchmark loops are unrolled.
+// The zero Config is ready to use.
+type Config struct {
+	// Factor is the number of copies of the loop body.
+	// If zero, DefaultFactor is used.
+	Factor int
+
+	// Auto chooses each loop's factor using AutoFactor, instead of Factor.
+	Auto bool
+
+	// FactorConst, if set, names a constant to use in place of
+	// the factor in the guard and divisor of loops unrolled by Factor,
+	// so that the generated code says where its factor came from.
+	// The caller declares the constant; see DeclareConst.
+	// Changing the constant's value requires unrolling again,
+	// to change the number of copies to match.
+	FactorConst string
+
+	// Interleave, in loops whose bodies are simple statements
+	// that do not depend on each other, orders the copies' statements
+	// by statement rather than by copy: each copy's first statement,
+	// then each copy's second, and so on. This is an experiment,
+	// to expose instruction-level parallelism between copies
+	// and see how much results depend on scheduling.
+	Interleave bool
+
+	// MaxCopies, if positive, limits the copies of the body in a row.
+	// Loops with larger factors repeat a block of copies in an inner loop,
+	// so that large factors do not make huge functions.
+	MaxCopies int
+
+	// Literals also unrolls the loops in function literals
+	// passed to testing.Benchmark; see BenchmarkLiterals.
+	Literals bool
+
+	// Sequential turns parallel benchmarks into loops over b.N,
+	// so that they are unrolled too; see Sequential.
+	// Those whose loops are not unrolled are left parallel.
+	Sequential bool
+
+	// KeepOriginal preserves each original loop
+	// as a comment directly above its replacement.
+	// It needs the file set, so it has no effect without one,
+	// and none on the copies added by Duplicate and Variants,
+	// whose originals are left in place.
+	KeepOriginal bool
+
+	// Decide, if non-nil, is called for each loop in fn
+	// before it is rewritten, with the factor that will be used.
+	// It returns the factor to use instead, or 0 to leave s alone.
+	Decide func(fn *ast.FuncDecl, s ast.Stmt, factor int) int
+
+	// Limit, if non-nil, is like Decide, but is called after it,
+	// with the factor Decide returned, so that Decide cannot exceed it.
+	Limit func(fn *ast.FuncDecl, s ast.Stmt, factor int) int
+}
+
+func (c *Config) factor() int {
+	if c.Factor == 0 {
+		return DefaultFactor
+	}
+	return c.Factor
+}
+
+// decide returns the factor to unroll s by, as Decide and Limit decide.
+func (c *Config) decide(fn *ast.FuncDecl, s ast.Stmt, factor int) int {
+	if c.Decide != nil && factor != 0 {
+		factor = c.Decide(fn, s, factor)
+	}
+	if c.Limit != nil && factor != 0 {
+		factor = c.Limit(fn, s, factor)
+	}
+	return factor
+}
+
+// File unrolls the benchmark loops in f with the zero Config.
+// It reports whether any loops were rewritten.
+func File(f *ast.File) bool {
+	return new(Config).File(nil, f)
+}
+
+// Func unrolls the benchmark loops in fn with the zero Config.
+// It reports whether any loops were rewritten.
+func Func(fn *ast.FuncDecl) bool {
+	return new(Config).fn(nil, nil, fn)
+}
+
+// File unrolls the benchmark loops in f, which was parsed using fset.
+// It reports whether any loops were rewritten.
+func (c *Config) File(fset *token.FileSet, f *ast.File) bool {
+	changed := false
+	for _, d := range f.Decls {
+		fn, ok := d.(*ast.FuncDecl)
+		// Find benchmark-like functions.
+		// We are flexible here because we want to detect and rewrite
+		// helper functions like this one from math/big:
+		// 	func benchmarkBitLenN(b *testing.B, nbits uint) {
+		// 		testword := Word((uint64(1) << nbits) - 1)
+		// 		for i := 0; i < b.N; i++ {
+		// 			bitLen(testword)
+		// 		}
+		// 	}
+		if !ok || !IsBench(fn) {
+			continue
+		}
+		if c.fn(fset, f, fn) {
+			changed = true
+		}
+	}
+	if c.Literals && c.BenchmarkLiterals(fset, f) {
+		changed = true
+	}
+	return changed
+}
+
+// fn unrolls the benchmark loops in fn, which is in f.
+// fset and f may be nil if c needs no access to the file.
+func (c *Config) fn(fset *token.FileSet, f *ast.File, fn *ast.FuncDecl) bool {
+	return c.body(fset, f, fn, fn.Body)
+}
+
+// body unrolls the benchmark loops in body, which is fn's
+// or that of a function literal in fn.
+func (c *Config) body(fset *token.FileSet, f *ast.File, fn *ast.FuncDecl, body *ast.BlockStmt) bool {
+	changed := false
+	// Keep it simple: Look for top level loops up to b.N.
+	// Generated code is recognized as such wherever it is,
+	// so that it is unrolled again only with a new factor, never twice.
+	for i, s := range body.List {
+		// A parallel benchmark is converted only if it is then unrolled.
+		parallel := s
+		if c.Sequential {
+			if seq, ok := Sequential(s); ok {
+				s = seq
+			}
+		}
+		factor := c.factor()
+		if orig, ok := Rerolled(s); ok {
+			// Unrolled before; unroll it again if the factor has changed.
+			if c.Auto {
+				factor = AutoFactor(orig)
+			}
+			factor = c.decide(fn, orig, factor)
+			if factor == 0 || Baseline(orig) || factor == Factor(s) && c.laidOut(s, factor) && c.named(s, factor) {
+				continue
+			}
+			if fset != nil {
+				tf := fset.File(f.Pos())
+				dropLineDirectives(tf, f, s)
+				dropChecksum(tf, f, s)
+				dropCopyComments(f, s)
+				squash(tf, s, orig)
+			}
+			_, id, loop := IsBenchForLoop(orig)
+			body.List[i] = c.name(c.interleave(c.nest(Unrolled(orig.(*ast.ForStmt), id, loop, factor), factor), loop), factor)
+			changed = true
+			continue
+		}
+		if HasGenerated(s) {
+			// Unrolling it would unroll the generated code again.
+			continue
+		}
+		if c.Auto {
+			if factor = AutoFactor(s); factor == 0 {
+				continue
+			}
+		}
+		if c.Decide != nil || c.Limit != nil {
+			if _, ok := Unroll(s, factor); !ok {
+				continue
+			}
+			if factor = c.decide(fn, s, factor); factor == 0 {
+				continue
+			}
+		}
+		n, ok := Unroll(s, factor)
+		if !ok {
+			continue
+		}
+		if s != parallel && fset != nil {
+			squash(fset.File(f.Pos()), parallel, s)
+		}
+		if c.KeepOriginal && fset != nil {
+			keepOriginal(fset, f, s)
+		}
+		_, _, loop := IsBenchForLoop(s)
+		body.List[i] = c.name(c.interleave(c.nest(n, factor), loop), factor)
+		changed = true
+	}
+	return changed
+}
+
+// Duplicate adds to f an unrolled copy of each benchmark in f
+// that has loops to unroll, named with suffix appended to the original name.
+// The originals are left unchanged, so that both can be run together.
+// Only Benchmark functions are copied, since helpers' copies would not be called.
+// It reports whether any copies were added.
+func Duplicate(f *ast.File, suffix string) bool {
+	return new(Config).Duplicate(f, suffix)
+}
+
+// Duplicate is like the package-level Duplicate,
+// but unrolls the copies as configured by c.
+func (c *Config) Duplicate(f *ast.File, suffix string) bool {
+	return c.duplicate(f, []string{suffix}, []int{c.factor()})
+}
+
+// Variants adds to f, after each benchmark in f that has loops to unroll,
+// a copy unrolled by each of factors, named with "Unrolled" and the factor
+// appended to the original name, such as BenchmarkXUnrolled8,
+// so that a single go test -bench run measures every factor.
+// Otherwise, it is like Duplicate.
+func (c *Config) Variants(f *ast.File, factors []int) bool {
+	var suffixes []string
+	for _, k := range factors {
+		suffixes = append(suffixes, "Unrolled"+strconv.Itoa(k))
+	}
+	return c.duplicate(f, suffixes, factors)
+}
+
+// duplicate adds to f a copy of each benchmark in f for each suffix,
+// unrolled by the corresponding factor. Benchmarks whose names
+// end in one of suffixes are copies already, and are not copied again.
+func (c *Config) duplicate(f *ast.File, suffixes []string, factors []int) bool {
+	names := make(map[string]bool)
+	for _, d := range f.Decls {
+		if fn, ok := d.(*ast.FuncDecl); ok && fn.Recv == nil {
+			names[fn.Name.Name] = true
+		}
+	}
+	var decls []ast.Decl
+	for _, d := range f.Decls {
+		decls = append(decls, d)
+		fn, ok := d.(*ast.FuncDecl)
+		if !ok || fn.Recv != nil || !strings.HasPrefix(fn.Name.Name, "Benchmark") || !IsBench(fn) {
+			continue
+		}
+		if slices.ContainsFunc(suffixes, func(suffix string) bool { return strings.HasSuffix(fn.Name.Name, suffix) }) {
+			continue
+		}
+		for i, suffix := range suffixes {
+			name := fn.Name.Name + suffix
+			if names[name] {
+				continue
+			}
+			dup := clone(fn).(*ast.FuncDecl)
+			// The copy shares fn's positions, so the printer would put it
+			// right after fn. A non-nil Doc makes it leave a blank line.
+			// The doc comment itself is not printed, as it is not in f.Comments.
+			dup.Doc = &ast.CommentGroup{}
+			dup.Name.Name = name
+			cc := *c
+			cc.Factor = factors[i]
+			cc.KeepOriginal = false // fn itself is the original
+			if !cc.fn(nil, nil, dup) {
+				continue
+			}
+			names[name] = true
+			decls = append(decls, dup)
+		}
+	}
+	changed := len(decls) != len(f.Decls)
+	f.Decls = decls
+	return changed
+}
+
+// Reroll reverts the unrolled benchmark loops in f to their original form,
+// including those in function literals passed to testing.Benchmark.
+// It reports whether any loops were rewritten.
+// It adjusts the line information in fset so that the original
+// loops are printed without the blank lines left by the removed code.
+func Reroll(fset *token.FileSet, f *ast.File) bool {
+	tf := fset.File(f.Pos())
+	changed := false
+	for _, d := range f.Decls {
+		fn, ok := d.(*ast.FuncDecl)
+		if !ok || fn.Body == nil {
+			continue
+		}
+		if IsBench(fn) && reroll(tf, f, fn.Body) {
+			changed = true
+		}
+		_, lits := LiteralsIn(fn)
+		for _, lit := range lits {
+			if reroll(tf, f, lit.Body) {
+				changed = true
+			}
+		}
+	}
+	return changed
+}
+
+// reroll reverts the unrolled loops at the top level of body.
+func reroll(tf *token.File, f *ast.File, body *ast.BlockStmt) bool {
+	changed := false
+	for i, s := range body.List {
+		orig, ok := Rerolled(s)
+		if !ok {
+			continue
+		}
+		dropLineDirectives(tf, f, s)
+		dropChecksum(tf, f, s)
+		dropCopyComments(f, s)
+		dropOriginal(tf, f, s)
+		squash(tf, s, orig)
+		body.List[i] = orig
+		changed = true
+	}
+	return changed
+}
+
+// squash squashes the lines in s before and after orig, which is inside it,
+// into orig's first and last lines, so that orig prints in s's place.
+func squash(tf *token.File, s, orig ast.Node) {
+	for n := rawLine(tf, orig.Pos()) - rawLine(tf, s.Pos()); n > 0; n-- {
+		tf.MergeLine(rawLine(tf, s.Pos()))
+	}
+	for n := rawLine(tf, s.End()) - rawLine(tf, orig.End()); n > 0; n-- {
+		tf.MergeLine(rawLine(tf, orig.End()))
+	}
+}
+
+// IsBench reports whether n is a benchmark.
+// It assumes that the testing package has been imported
+// under its own name.
+func IsBench(n *ast.FuncDecl) bool {
+	if n.Body == nil ||
+		!strings.HasPrefix(strings.ToLower(n.Name.Name), "bench") ||
+		n.Type.Params == nil {
+		return false
+	}
+	return hasB(n.Type.Params)
+}
+
+// hasB reports whether one of params is b *testing.B.
+func hasB(params *ast.FieldList) bool {
+	for _, p := range params.List {
+		if len(p.Names) != 1 || p.Names[0].Name != "b" {
+			continue
+		}
+		star, ok := p.Type.(*ast.StarExpr)
+		if !ok {
+			continue
+		}
+		sel, ok := star.X.(*ast.SelectorExpr)
+		if !ok || sel.Sel.Name != "B" {
+			continue
+		}
+		id, ok := sel.X.(*ast.Ident)
+		if !ok || id.Name != "testing" {
+			continue
+		}
+		return true
+	}
+
+	return false
+}
+
+// IsBenchForLoop reports whether n a statement of the form:
+//
+//	for i := 0; i < b.N; i++ {
+//	  // body
+//	}
+//
+// in which i is any ident?
+// TODO: be more flexible in what we look for. (samesafeexpr)
+// TODO: make sure that i is not read and b.N is not written to in the body. Or elsewhere either?
+func IsBenchForLoop(n ast.Stmt) (is bool, id string, body *ast.BlockStmt) {
+	f, ok := n.(*ast.ForStmt)
+	if !ok {
+		return
+	}
+
+	if f.Init == nil || f.Cond == nil || f.Post == nil {
+		return
+	}
+
+	// condition not of form a < b
+	bin, ok := f.Cond.(*ast.BinaryExpr)
+	if !ok || bin.Op != token.LSS {
+		return
+	}
+
+	// rhs must be b.N
+	sel, ok := bin.Y.(*ast.SelectorExpr)
+	if !ok || sel.Sel.Name != "N" {
+		return
+	}
+	x, ok := sel.X.(*ast.Ident)
+	if !ok || x.Name != "b" {
+		return
+	}
+
+	// i must be an ident
+	i, ok := bin.X.(*ast.Ident)
+	if !ok {
+		return
+	}
+
+	ini, ok := f.Init.(*ast.AssignStmt)
+	if !ok || len(ini.Lhs) != 1 || len(ini.Rhs) != 1 {
+		return
+	}
+
+	inilhs, ok := ini.Lhs[0].(*ast.Ident)
+	if !ok || inilhs.Name != i.Name {
+		return
+	}
+
+	post, ok := f.Post.(*ast.IncDecStmt)
+	if !ok || post.Tok != token.INC {
+		return
+	}
+	postlhs, ok := post.X.(*ast.Ident)
+	if !ok || postlhs.Name != i.Name {
+		return
+	}
+
+	// body must survive being copied
+	if !copyable(f.Body) {
+		return
+	}
+
+	return true, i.Name, f.Body
+}
+
+// Baseline reports whether s is a loop recognized by IsBenchForLoop
+// whose body is empty, or holds only comments.
+// Such loops deliberately measure the loop overhead itself,
+// so they are never unrolled, whatever the configuration.
+func Baseline(s ast.Stmt) bool {
+	ok, _, body := IsBenchForLoop(s)
+	if !ok {
+		return false
+	}
+	for _, s := range body.List {
+		if _, ok := s.(*ast.EmptyStmt); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// copyable reports whether body can be repeated in the unrolled loop.
+// Labels are function scoped, so copies would redeclare them,
+// and mentions of bNUnroll or bNRepeat would refer to the unrolled loop's.
+func copyable(body *ast.BlockStmt) bool {
+	ok := true
+	ast.Inspect(body, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.LabeledStmt:
+			ok = false
+		case *ast.Ident:
+			if n.Name == "bNUnroll" || n.Name == "bNRepeat" {
+				ok = false
+			}
+		}
+		return ok
+	})
+	return ok
+}
+
+func basicInt(pos token.Pos, i int) *ast.BasicLit {
+	return &ast.BasicLit{ValuePos: pos, Kind: token.INT, Value: strconv.Itoa(i)}
+}
+
+func ident(pos token.Pos, name string) *ast.Ident {
+	return &ast.Ident{NamePos: pos, Name: name}
+}
+
+// Unrolled returns the unrolled replacement for the benchmark loop f,
+// whose loop variable is id and whose body is body,
+// containing factor copies of body.
+func Unrolled(f *ast.ForStmt, id string, body *ast.BlockStmt, factor int) ast.Stmt {
+	// Build, for factor 10:
+	// if b.N < 10 {
+	// 	for i := 0; i < b.N; i++ {
+	//		// body
+	// 	}
+	// } else {
+	// 	for i, bNUnroll := 0, b.N / 10; i < bNUnroll; i++ {
+	//   {
+	//     // body
+	//   }
+	//   // repeat 9 more times
+	// }
+	//
+	// All new nodes are positioned at the start of f.
+	// Without positions, the printer guesses where comments go
+	// and drags comments from later in the file into the loop.
+	pos := f.For
+
+	s := &ast.IfStmt{
+		If: pos,
+		Cond: &ast.BinaryExpr{
+			X:     ident(pos, "b.N"), // cheating a little
+			OpPos: pos,
+			Y:     basicInt(pos, factor),
+			Op:    token.LSS,
+		},
+	}
+
+	s.Body = &ast.BlockStmt{
+		Lbrace: pos,
+		List: []ast.Stmt{
+			&ast.ForStmt{
+				For:  f.For,
+				Init: f.Init,
+				Cond: f.Cond,
+				Post: f.Post,
+				Body: body,
+			},
+		},
+		Rbrace: f.End(),
+	}
+
+	var copies []ast.Stmt
+	for i := 0; i < factor; i++ {
+		copies = append(copies, body)
+	}
+
+	s.Else = &ast.BlockStmt{
+		Lbrace: f.End(),
+		List: []ast.Stmt{
+			&ast.ForStmt{
+				For: f.End(),
+				Init: &ast.AssignStmt{
+					Lhs: []ast.Expr{
+						ident(pos, id),
+						ident(pos, "bNUnroll"),
+					},
+					TokPos: pos,
+					Tok:    token.DEFINE,
+					Rhs: []ast.Expr{
+						basicInt(pos, 0),
+						&ast.BinaryExpr{
+							X:     ident(pos, "b.N"), // cheat
+							OpPos: pos,
+							Y:     basicInt(pos, factor),
+							Op:    token.QUO,
+						},
+					},
+				},
+				Cond: &ast.BinaryExpr{
+					X:     ident(pos, id),
+					OpPos: pos,
+					Y:     ident(pos, "bNUnroll"),
+					Op:    token.LSS,
+				},
+				Post: f.Post,
+				Body: &ast.BlockStmt{Lbrace: pos, List: copies, Rbrace: f.End()},
+			},
+		},
+		Rbrace: f.End(),
+	}
+
+	return s
+}
+
+// Rerolled reports whether s is a statement generated by Unrolled,
+// and if so, returns the original benchmark loop.
+func Rerolled(s ast.Stmt) (ast.Stmt, bool) {
+	ifs, ok := s.(*ast.IfStmt)
+	if !ok || ifs.Init != nil || len(ifs.Body.List) != 1 {
+		return nil, false
+	}
+
+	// condition must be b.N < k
+	bin, ok := ifs.Cond.(*ast.BinaryExpr)
+	if !ok || bin.Op != token.LSS || !isBN(bin.X) {
+		return nil, false
+	}
+	switch y := bin.Y.(type) {
+	case *ast.BasicLit:
+		if y.Kind != token.INT {
+			return nil, false
+		}
+	case *ast.Ident:
+		// A constant; see Config.FactorConst.
+	default:
+		return nil, false
+	}
+
+	// then branch must be the original loop
+	if ok, _, _ := IsBenchForLoop(ifs.Body.List[0]); !ok {
+		return nil, false
+	}
+
+	// else branch must be the unrolled loop
+	els, ok := ifs.Else.(*ast.BlockStmt)
+	if !ok || len(els.List) != 1 {
+		return nil, false
+	}
+	f, ok := els.List[0].(*ast.ForStmt)
+	if !ok {
+		return nil, false
+	}
+	ini, ok := f.Init.(*ast.AssignStmt)
+	if !ok || len(ini.Lhs) != 2 {
+		return nil, false
+	}
+	if id, ok := ini.Lhs[1].(*ast.Ident); !ok || id.Name != "bNUnroll" {
+		return nil, false
+	}
+
+	return ifs.Body.List[0], true
+}
+
+// Factor returns the factor of s, a statement generated by Unrolled,
+// as given by its guard, or if the guard names a constant,
+// by its number of copies.
+func Factor(s ast.Stmt) int {
+	if _, ok := s.(*ast.IfStmt).Cond.(*ast.BinaryExpr).Y.(*ast.Ident); ok {
+		copies, repeat := layout(s)
+		return len(copies) * repeat
+	}
+	return intLit(s.(*ast.IfStmt).Cond.(*ast.BinaryExpr).Y)
+}
+
+// isBN reports whether x is b.N.
+// It accepts both a selector and the ident that Unrolled cheats with.
+func isBN(x ast.Expr) bool {
+	switch x := x.(type) {
+	case *ast.Ident:
+		return x.Name == "b.N"
+	case *ast.SelectorExpr:
+		id, ok := x.X.(*ast.Ident)
+		return ok && id.Name == "b" && x.Sel.Name == "N"
+	}
+	return false
+}
+
+// Format prints n, which is to replace old in f, indented to match old
+// and laid out as gofmt would, since it goes into source as an edit.
+// The result does not include old's leading indentation.
+func Format(fset *token.FileSet, f *ast.File, old, n ast.Node) ([]byte, error) {
+	var buf bytes.Buffer
+	gofmt := &printer.Config{Mode: printer.UseSpaces | printer.TabIndent, Tabwidth: 8}
+	if err := gofmt.Fprint(&buf, fset, &printer.CommentedNode{Node: n, Comments: f.Comments}); err != nil {
+		return nil, err
+	}
+	// gofmt'd source is indented with tabs, one per column.
+	indent := []byte(strings.Repeat("\t", fset.Position(old.Pos()).Column-1))
+	lines := bytes.Split(buf.Bytes(), []byte("\n"))
+	for i := 1; i < len(lines); i++ {
+		if len(lines[i]) > 0 {
+			lines[i] = append(indent[:len(indent):len(indent)], lines[i]...)
+		}
+	}
+	return bytes.Join(lines, []byte("\n")), nil
+}
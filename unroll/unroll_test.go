@@ -0,0 +1,1266 @@
+package unroll
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+// rewrite unrolls the benchmark loops in src and prints the result
+// the same way the unrollbench command does.
+func rewrite(t *testing.T, filename string, src []byte) []byte {
+	return apply(t, func(_ *token.FileSet, f *ast.File) bool { return File(f) }, filename, src)
+}
+
+// apply applies fn to src and prints the result.
+func apply(t *testing.T, fn func(*token.FileSet, *ast.File) bool, filename string, src []byte) []byte {
+	t.Helper()
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, filename, src, parser.ParseComments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fn(fset, f)
+	var buf bytes.Buffer
+	if err := printer.Fprint(&buf, fset, f); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestGolden(t *testing.T) {
+	inputs, err := filepath.Glob(filepath.Join("testdata", "*.input"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, input := range inputs {
+		name := strings.TrimSuffix(filepath.Base(input), ".input")
+		t.Run(name, func(t *testing.T) {
+			src, err := os.ReadFile(input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := rewrite(t, input, src)
+
+			golden := strings.TrimSuffix(input, ".input") + ".golden"
+			if *update {
+				if err := os.WriteFile(golden, got, 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("output does not match %s; got:\n%s", golden, got)
+			}
+
+			// A second pass must not change anything.
+			if again := rewrite(t, input, got); !bytes.Equal(again, got) {
+				t.Errorf("rewrite is not idempotent; second pass:\n%s", again)
+			}
+		})
+	}
+}
+
+func TestReroll(t *testing.T) {
+	inputs, err := filepath.Glob(filepath.Join("testdata", "*.input"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, input := range inputs {
+		name := strings.TrimSuffix(filepath.Base(input), ".input")
+		t.Run(name, func(t *testing.T) {
+			src, err := os.ReadFile(input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := apply(t, func(*token.FileSet, *ast.File) bool { return false }, input, src)
+			// The unrolled file in testdata is already unrolled,
+			// so reroll it first to get the original.
+			if name == "unrolled" {
+				want = apply(t, Reroll, input, src)
+			}
+			if got := apply(t, Reroll, input, rewrite(t, input, src)); !bytes.Equal(got, want) {
+				t.Errorf("reroll of unrolled %s; got:\n%s\nwant:\n%s", input, got, want)
+			}
+		})
+	}
+}
+
+func TestRegister(t *testing.T) {
+	defer func(saved []Pattern) { patterns = saved }(patterns)
+
+	// benchx.Each(b, f) becomes benchx.Each10(b, f).
+	isEach := func(s ast.Stmt) (*ast.SelectorExpr, bool) {
+		es, ok := s.(*ast.ExprStmt)
+		if !ok {
+			return nil, false
+		}
+		call, ok := es.X.(*ast.CallExpr)
+		if !ok {
+			return nil, false
+		}
+		sel, ok := call.Fun.(*ast.SelectorExpr)
+		if !ok || sel.Sel.Name != "Each" {
+			return nil, false
+		}
+		pkg, ok := sel.X.(*ast.Ident)
+		return sel, ok && pkg.Name == "benchx"
+	}
+	Register(Pattern{
+		Name: "benchx.Each",
+		Match: func(s ast.Stmt) bool {
+			_, ok := isEach(s)
+			return ok
+		},
+		Unroll: func(s ast.Stmt, factor int) ast.Stmt {
+			sel, _ := isEach(s)
+			sel.Sel = ast.NewIdent("Each10")
+			return s
+		},
+	})
+
+	src := `package p
+
+func BenchmarkEach(b *testing.B) {
+	benchx.Each(b, func() {})
+	for i := 0; i < b.N; i++ {
+		println()
+	}
+}
+`
+	got := string(rewrite(t, "each_test.go", []byte(src)))
+	if !strings.Contains(got, "benchx.Each10(b, func() {})") {
+		t.Errorf("benchx.Each not unrolled:\n%s", got)
+	}
+	if !strings.Contains(got, "bNUnroll") {
+		t.Errorf("for loop not unrolled:\n%s", got)
+	}
+}
+
+func TestDuplicate(t *testing.T) {
+	src := `package p
+
+import "testing"
+
+func BenchmarkA(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		println()
+	}
+}
+
+func BenchmarkB(b *testing.B) {
+	println()
+}
+
+func benchmarkHelper(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		println()
+	}
+}
+`
+	dup := func(_ *token.FileSet, f *ast.File) bool { return Duplicate(f, "Unrolled") }
+	got := apply(t, dup, "dup_test.go", []byte(src))
+	for _, want := range []string{
+		"func BenchmarkA(b *testing.B) {\n\tfor i := 0; i < b.N; i++ {",
+		"func BenchmarkAUnrolled(b *testing.B) {\n\tif b.N < 10 {",
+	} {
+		if !bytes.Contains(got, []byte(want)) {
+			t.Errorf("output does not contain %q:\n%s", want, got)
+		}
+	}
+	for _, bad := range []string{"BenchmarkBUnrolled", "benchmarkHelperUnrolled"} {
+		if bytes.Contains(got, []byte(bad)) {
+			t.Errorf("output contains %s:\n%s", bad, got)
+		}
+	}
+	if again := apply(t, dup, "dup_test.go", got); !bytes.Equal(again, got) {
+		t.Errorf("Duplicate is not idempotent; second pass:\n%s", again)
+	}
+}
+
+// Copies need no original loops kept, since the originals are left as they are.
+func TestDuplicateKeepOriginal(t *testing.T) {
+	src := `package p
+
+import "testing"
+
+func BenchmarkA(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		println()
+	}
+}
+`
+	dup := func(fset *token.FileSet, f *ast.File) bool {
+		c := Config{KeepOriginal: true}
+		return c.Duplicate(f, "Unrolled")
+	}
+	got := apply(t, dup, "dup_test.go", []byte(src))
+	if !bytes.Contains(got, []byte("func BenchmarkAUnrolled(b *testing.B) {\n\tif b.N < 10 {")) {
+		t.Errorf("benchmark not duplicated:\n%s", got)
+	}
+	if bytes.Contains(got, []byte(KeepHeader)) {
+		t.Errorf("original loop kept in copy:\n%s", got)
+	}
+}
+
+func TestVariants(t *testing.T) {
+	src := `package p
+
+import "testing"
+
+func BenchmarkA(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		println()
+	}
+}
+`
+	variants := func(_ *token.FileSet, f *ast.File) bool { return new(Config).Variants(f, []int{2, 4}) }
+	got := apply(t, variants, "variants_test.go", []byte(src))
+	for _, want := range []string{
+		"func BenchmarkA(b *testing.B) {\n\tfor i := 0; i < b.N; i++ {",
+		"func BenchmarkAUnrolled2(b *testing.B) {\n\tif b.N < 2 {",
+		"func BenchmarkAUnrolled4(b *testing.B) {\n\tif b.N < 4 {",
+	} {
+		if !bytes.Contains(got, []byte(want)) {
+			t.Errorf("output does not contain %q:\n%s", want, got)
+		}
+	}
+	if again := apply(t, variants, "variants_test.go", got); !bytes.Equal(again, got) {
+		t.Errorf("Variants is not idempotent; second pass:\n%s", again)
+	}
+
+	// As with Duplicate, the originals need no loops kept.
+	keep := func(_ *token.FileSet, f *ast.File) bool {
+		return (&Config{KeepOriginal: true}).Variants(f, []int{2, 4})
+	}
+	if kept := apply(t, keep, "variants_test.go", []byte(src)); !bytes.Equal(kept, got) {
+		t.Errorf("Variants with KeepOriginal differs; got:\n%s", kept)
+	}
+}
+
+func TestKeepOriginal(t *testing.T) {
+	src, err := os.ReadFile(filepath.Join("testdata", "comments.input"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	keep := func(fset *token.FileSet, f *ast.File) bool {
+		c := Config{KeepOriginal: true}
+		return c.File(fset, f)
+	}
+	got := apply(t, keep, "comments.input", src)
+	want := KeepHeader + "\n\t//\tfor i := 0; i < b.N; i++ {\n\t//\t\t// Inside the loop.\n"
+	if !bytes.Contains(got, []byte(want)) {
+		t.Errorf("original loop not kept; got:\n%s", got)
+	}
+	orig := apply(t, func(*token.FileSet, *ast.File) bool { return false }, "comments.input", src)
+	if rerolled := apply(t, Reroll, "comments.input", got); !bytes.Equal(rerolled, orig) {
+		t.Errorf("reroll did not remove original loop; got:\n%s", rerolled)
+	}
+}
+
+// Replacing an unrolled loop from GeneratedStart on with the original,
+// as editors revert it, leaves nothing generated behind.
+func TestGeneratedStart(t *testing.T) {
+	src := []byte(`package p
+
+import "testing"
+
+func BenchmarkA(b *testing.B) {
+	x := 0
+	// Count.
+	for i := 0; i < b.N; i++ {
+		x++ // inside
+	}
+	_ = x
+}
+`)
+	keep := func(fset *token.FileSet, f *ast.File) bool {
+		c := Config{KeepOriginal: true}
+		return c.File(fset, f)
+	}
+	out, err := Checksums(apply(t, keep, "a_test.go", src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "a_test.go", out, parser.ParseComments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tf := fset.File(f.Pos())
+	s := f.Decls[1].(*ast.FuncDecl).Body.List[1]
+	orig, ok := Rerolled(s)
+	if !ok {
+		t.Fatalf("loop not unrolled:\n%s", out)
+	}
+	text, err := Format(fset, f, s, orig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start, end := tf.Offset(GeneratedStart(tf, f, s)), tf.Offset(s.End())
+	reverted := append(append(append([]byte(nil), out[:start]...), text...), out[end:]...)
+	if !bytes.Equal(reverted, src) {
+		t.Errorf("reverted:\n%s\nwant:\n%s", reverted, src)
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	src := `package p
+
+import "testing"
+
+func BenchmarkDiv(b *testing.B) {
+	for i := 0; i < b.N/4; i++ {
+		f(i) // call
+		f(i) // call
+		f(i) // call
+		f(i) // call
+	}
+}
+
+func BenchmarkStep(b *testing.B) {
+	for i := 0; i < b.N; i += 2 {
+		x := 1
+		f(x)
+		x := 1
+		f(x)
+	}
+}
+
+func BenchmarkDifferent(b *testing.B) {
+	for i := 0; i < b.N/2; i++ {
+		f(1)
+		f(2)
+	}
+}
+
+func BenchmarkMismatched(b *testing.B) {
+	if b.N < 10 {
+		for i := 0; i < b.N; i++ {
+			f(i)
+		}
+	} else {
+		for i, bNUnroll := 0, b.N/10; i < bNUnroll; i++ {
+			{
+				f(i)
+			}
+			{
+				f(i)
+			}
+		}
+	}
+}
+`
+	got := apply(t, Normalize, "norm_test.go", []byte(src))
+	for _, want := range []string{
+		"func BenchmarkDiv(b *testing.B) {\n\tif b.N < 4 {\n\t\tfor i := 0; i < b.N; i++ {\n\t\t\tf(i)\t// call\n\t\t}",
+		"func BenchmarkStep(b *testing.B) {\n\tif b.N < 2 {\n\t\tfor i := 0; i < b.N; i++ {\n\t\t\tx := 1\n\t\t\tf(x)\n\t\t}",
+		"for i := 0; i < b.N/2; i++ {\n\t\tf(1)\n\t\tf(2)\n\t}",
+		"func BenchmarkMismatched(b *testing.B) {\n\tif b.N < 2 {",
+	} {
+		if !bytes.Contains(got, []byte(want)) {
+			t.Errorf("output does not contain %q:\n%s", want, got)
+		}
+	}
+	if again := apply(t, Normalize, "norm_test.go", got); !bytes.Equal(again, got) {
+		t.Errorf("Normalize is not idempotent; second pass:\n%s", again)
+	}
+}
+
+func TestRefactor(t *testing.T) {
+	src, err := os.ReadFile(filepath.Join("testdata", "basic.input"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	by := func(factor int) func(*token.FileSet, *ast.File) bool {
+		return func(fset *token.FileSet, f *ast.File) bool {
+			c := Config{Factor: factor}
+			return c.File(fset, f)
+		}
+	}
+	got := apply(t, by(20), "basic.input", apply(t, by(10), "basic.input", src))
+	want := apply(t, by(20), "basic.input", src)
+	if !bytes.Equal(got, want) {
+		t.Errorf("unrolling again with a new factor; got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestLiterals(t *testing.T) {
+	src := `package main
+
+import "testing"
+
+func main() {
+	go func() {}()
+	r := testing.Benchmark(func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			println()
+		}
+	})
+	println(r.String())
+}
+
+func benchHelper(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		println()
+	}
+}
+`
+	f, err := parser.ParseFile(token.NewFileSet(), "main.go", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names, _ := LiteralsIn(f.Decls[1].(*ast.FuncDecl)); len(names) != 1 || names[0] != "main.func2" {
+		t.Errorf("LiteralsIn = %q, want [main.func2]", names)
+	}
+
+	lits := func(fset *token.FileSet, f *ast.File) bool { return new(Config).BenchmarkLiterals(fset, f) }
+	got := apply(t, lits, "main.go", []byte(src))
+	if !bytes.Contains(got, []byte("r := testing.Benchmark(func(b *testing.B) {\n\t\tif b.N < 10 {")) {
+		t.Errorf("literal not unrolled:\n%s", got)
+	}
+	if !bytes.Contains(got, []byte("func benchHelper(b *testing.B) {\n\tfor i := 0; i < b.N; i++ {")) {
+		t.Errorf("benchmark function unrolled:\n%s", got)
+	}
+	if again := apply(t, lits, "main.go", got); !bytes.Equal(again, got) {
+		t.Errorf("BenchmarkLiterals is not idempotent; second pass:\n%s", again)
+	}
+	orig := apply(t, func(*token.FileSet, *ast.File) bool { return false }, "main.go", []byte(src))
+	if rerolled := apply(t, Reroll, "main.go", got); !bytes.Equal(rerolled, orig) {
+		t.Errorf("reroll of literal; got:\n%s", rerolled)
+	}
+}
+
+func TestLineDirectives(t *testing.T) {
+	src := []byte(`package p
+
+import "testing"
+
+func BenchmarkA(b *testing.B) {
+	x := 0
+	for i := 0; i < b.N; i++ {
+		x++
+	}
+	_ = x
+}
+`)
+	out, err := LineDirectives("a_test.go", src, rewrite(t, "a_test.go", src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "a_test.go", out, parser.ParseComments)
+	if err != nil {
+		t.Fatalf("%v:\n%s", err, out)
+	}
+	body := f.Decls[1].(*ast.FuncDecl).Body
+	loop := body.List[1].(*ast.IfStmt).Else.(*ast.BlockStmt).List[0].(*ast.ForStmt)
+	for _, c := range loop.Body.List {
+		if p := fset.Position(c.(*ast.BlockStmt).List[0].Pos()); p.Filename != "a_test.go" || p.Line != 8 {
+			t.Errorf("copy of loop body at %v, want a_test.go:8", p)
+		}
+	}
+	if p := fset.Position(body.List[2].Pos()); p.Line != 10 {
+		t.Errorf("statement after loop at %v, want a_test.go:10", p)
+	}
+	if again, err := LineDirectives("a_test.go", src, out); err != nil || !bytes.Equal(again, out) {
+		t.Errorf("LineDirectives is not idempotent; second pass:\n%s", again)
+	}
+	orig := apply(t, func(*token.FileSet, *ast.File) bool { return false }, "a_test.go", src)
+	if rerolled := apply(t, Reroll, "a_test.go", out); !bytes.Equal(rerolled, orig) {
+		t.Errorf("reroll did not remove directives; got:\n%s", rerolled)
+	}
+}
+
+// Code after benchmarks copied or converted keeps its lines.
+func TestLineDirectivesAdded(t *testing.T) {
+	src := []byte(`package p
+
+import "testing"
+
+func BenchmarkA(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		println()
+	}
+}
+
+func BenchmarkP(b *testing.B) {
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			println()
+		}
+	})
+}
+
+func TestX(t *testing.T) {}
+`)
+	seq := Config{Sequential: true}
+	for _, tt := range []struct {
+		name string
+		fn   func(*token.FileSet, *ast.File) bool
+	}{
+		{"dup", func(_ *token.FileSet, f *ast.File) bool { return Duplicate(f, "Unrolled") }},
+		{"variants", func(_ *token.FileSet, f *ast.File) bool { return new(Config).Variants(f, []int{2, 4}) }},
+		{"sequential", seq.File},
+	} {
+		out, err := LineDirectives("a_test.go", src, apply(t, tt.fn, "a_test.go", src))
+		if err != nil {
+			t.Fatal(err)
+		}
+		fset := token.NewFileSet()
+		f, err := parser.ParseFile(fset, "a_test.go", out, parser.ParseComments)
+		if err != nil {
+			t.Fatalf("%s: %v:\n%s", tt.name, err, out)
+		}
+		for _, d := range f.Decls {
+			fn, ok := d.(*ast.FuncDecl)
+			if !ok {
+				continue
+			}
+			want := map[string]int{"BenchmarkA": 5, "BenchmarkP": 11, "TestX": 19}[fn.Name.Name]
+			if want == 0 {
+				// A copy of BenchmarkA: its loop body is attributed to BenchmarkA's.
+				want = 5
+				loop := fn.Body.List[0].(*ast.IfStmt).Else.(*ast.BlockStmt).List[0].(*ast.ForStmt)
+				if p := fset.Position(loop.Body.List[0].(*ast.BlockStmt).List[0].Pos()); p.Line != 7 {
+					t.Errorf("%s: copy of loop body in %s at %v, want a_test.go:7", tt.name, fn.Name.Name, p)
+				}
+			}
+			if p := fset.Position(fn.Pos()); p.Line != want {
+				t.Errorf("%s: %s at %v, want a_test.go:%d", tt.name, fn.Name.Name, p, want)
+			}
+		}
+		if tt.name == "sequential" {
+			loop := f.Decls[2].(*ast.FuncDecl).Body.List[0].(*ast.IfStmt).Else.(*ast.BlockStmt).List[0].(*ast.ForStmt)
+			if p := fset.Position(loop.Body.List[0].(*ast.BlockStmt).List[0].Pos()); p.Line != 14 {
+				t.Errorf("converted loop body at %v, want a_test.go:14", p)
+			}
+		}
+	}
+}
+
+// memWriter is a FileWriter that keeps files in memory.
+type memWriter map[string][]byte
+
+func (m memWriter) WriteFile(name string, data []byte) error {
+	m[name] = data
+	return nil
+}
+
+func TestFS(t *testing.T) {
+	src, err := os.ReadFile(filepath.Join("testdata", "basic.input"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsys := fstest.MapFS{
+		"a_test.go":          {Data: src},
+		"a.go":               {Data: src},
+		"sub/b_test.go":      {Data: src},
+		"sub/done_test.go":   {Data: rewrite(t, "done_test.go", src)},
+		"testdata/c_test.go": {Data: src},
+		"_skip/d_test.go":    {Data: src},
+	}
+	w := make(memWriter)
+	written, err := new(Config).FS(context.Background(), fsys, w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a_test.go", "sub/b_test.go"}; !slices.Equal(written, want) {
+		t.Errorf("FS wrote %q, want %q", written, want)
+	}
+	want := rewrite(t, "basic.input", src)
+	for _, name := range written {
+		if !bytes.Equal(w[name], want) {
+			t.Errorf("%s does not match basic.golden; got:\n%s", name, w[name])
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	w = make(memWriter)
+	if _, err := new(Config).FS(ctx, fsys, w); err != context.Canceled || len(w) != 0 {
+		t.Errorf("FS after cancel = %v, wrote %d files; want context.Canceled, none", err, len(w))
+	}
+}
+
+func TestAutoFactor(t *testing.T) {
+	src := `package p
+
+import "testing"
+
+func BenchmarkSmall(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		x++
+	}
+}
+
+func BenchmarkLarge(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+` + strings.Repeat("\t\tx = f(x, y, z) + g(x, y, z)\n", 20) + `	}
+}
+`
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "auto.go", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := Config{Auto: true}
+	if !c.File(fset, f) {
+		t.Fatal("File with Auto unrolled nothing")
+	}
+	small := f.Decls[1].(*ast.FuncDecl).Body.List[0]
+	if got := Factor(small); got != 16 {
+		t.Errorf("BenchmarkSmall unrolled %d times, want 16", got)
+	}
+	large := f.Decls[2].(*ast.FuncDecl).Body.List[0]
+	if _, ok := large.(*ast.ForStmt); !ok {
+		t.Errorf("BenchmarkLarge unrolled, want it left alone")
+	}
+}
+
+func TestMaxCopies(t *testing.T) {
+	src := []byte(`package p
+
+import "testing"
+
+func BenchmarkX(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		x++
+	}
+}
+`)
+	c := Config{Factor: 64, MaxCopies: 16}
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "nest.go", src, parser.ParseComments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.File(fset, f) {
+		t.Fatal("File unrolled nothing")
+	}
+	s := f.Decls[1].(*ast.FuncDecl).Body.List[0]
+	if copies, repeat := layout(s); len(copies) != 16 || repeat != 4 {
+		t.Errorf("unrolled into %d copies repeated %d times, want 16 repeated 4 times", len(copies), repeat)
+	}
+	if got := Factor(s); got != 64 {
+		t.Errorf("Factor = %d, want 64", got)
+	}
+	if c.File(fset, f) {
+		t.Errorf("File unrolled a nested loop again")
+	}
+	if _, _, ok := Manual(s); ok {
+		t.Errorf("Manual accepted a nested loop")
+	}
+	c.MaxCopies = 0
+	if !c.File(fset, f) {
+		t.Errorf("File did not flatten a nested loop without MaxCopies")
+	}
+	if !Reroll(fset, f) {
+		t.Fatal("Reroll rerolled nothing")
+	}
+	var buf bytes.Buffer
+	if err := printer.Fprint(&buf, fset, f); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), src) {
+		t.Errorf("unroll, reroll round trip:\n%s\nwant:\n%s", buf.Bytes(), src)
+	}
+
+	for _, tt := range []struct{ factor, max, copies, repeat int }{
+		{10, 16, 10, 1},
+		{64, 16, 16, 4},
+		{100, 16, 10, 10},
+		{101, 16, 101, 1},
+	} {
+		c := Config{MaxCopies: tt.max}
+		if copies, repeat := c.split(tt.factor); copies != tt.copies || repeat != tt.repeat {
+			t.Errorf("split(%d) with MaxCopies %d = %d, %d, want %d, %d", tt.factor, tt.max, copies, repeat, tt.copies, tt.repeat)
+		}
+	}
+}
+
+func TestFactorConst(t *testing.T) {
+	src := []byte(`package p
+
+import "testing"
+
+func BenchmarkX(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		x++
+	}
+}
+`)
+	c := Config{Factor: 4, FactorConst: "benchUnrollFactor"}
+	unroll := func(fset *token.FileSet, f *ast.File) bool {
+		changed := c.File(fset, f)
+		return DeclareConst(f, c.FactorConst, 4) || changed
+	}
+	got := apply(t, unroll, "const.go", src)
+	want := `package p
+
+import "testing"
+
+const benchUnrollFactor = 4
+
+func BenchmarkX(b *testing.B) {
+	if b.N < benchUnrollFactor {
+		for i := 0; i < b.N; i++ {
+			x++
+		}
+	} else {
+		for i, bNUnroll := 0, b.N/benchUnrollFactor; i < bNUnroll; i++ {
+			{
+				x++
+			}
+			{
+				x++
+			}
+			{
+				x++
+			}
+			{
+				x++
+			}
+		}
+	}
+}
+`
+	if string(got) != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "const.go", got, parser.ParseComments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unroll(fset, f) {
+		t.Errorf("unrolling with a constant again changed the file")
+	}
+	if Normalize(fset, f) {
+		t.Errorf("Normalize rewrote a loop unrolled with a constant")
+	}
+	c.FactorConst = ""
+	if !c.File(fset, f) {
+		t.Errorf("File without FactorConst did not restore the literal factor")
+	}
+}
+
+func TestCopyComments(t *testing.T) {
+	src := []byte(`package p
+
+import "testing"
+
+func BenchmarkX(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		x++
+	}
+}
+`)
+	c := Config{Factor: 2}
+	out := apply(t, c.File, "copy.go", src)
+	got, err := CopyComments(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `package p
+
+import "testing"
+
+func BenchmarkX(b *testing.B) {
+	if b.N < 2 {
+		for i := 0; i < b.N; i++ {
+			x++
+		}
+	} else {
+		for i, bNUnroll := 0, b.N/2; i < bNUnroll; i++ {
+			// unroll copy 1/2
+			{
+				x++
+			}
+			// unroll copy 2/2
+			{
+				x++
+			}
+		}
+	}
+}
+`
+	if string(got) != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", got, want)
+	}
+	if again, err := CopyComments(got); err != nil || !bytes.Equal(again, got) {
+		t.Errorf("CopyComments is not idempotent; got:\n%s", again)
+	}
+	if rerolled := apply(t, Reroll, "copy.go", got); !bytes.Equal(rerolled, src) {
+		t.Errorf("reroll of annotated loop; got:\n%s\nwant:\n%s", rerolled, src)
+	}
+	c.Factor = 3
+	if again, err := CopyComments(apply(t, c.File, "copy.go", got)); err != nil || !bytes.Contains(again, []byte("// unroll copy 3/3")) || bytes.Contains(again, []byte("/2\n")) {
+		t.Errorf("unrolling annotated loop again; got:\n%s", again)
+	}
+}
+
+func TestChecksums(t *testing.T) {
+	src := []byte(`package p
+
+import "testing"
+
+func BenchmarkX(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		x++
+	}
+}
+`)
+	c := Config{Factor: 2}
+	got, err := Checksums(apply(t, c.File, "sum.go", src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(got, []byte("\n\t"+SumPrefix)) {
+		t.Fatalf("no checksum comment; got:\n%s", got)
+	}
+	if again, err := Checksums(got); err != nil || !bytes.Equal(again, got) {
+		t.Errorf("Checksums is not idempotent; got:\n%s", again)
+	}
+	regions, err := Regions(got)
+	if err != nil || len(regions) != 1 || regions[0].Func != "BenchmarkX" || regions[0].Tampered() {
+		t.Fatalf("Regions = %+v, %v; want one untampered loop in BenchmarkX", regions, err)
+	}
+	edited := bytes.Replace(got, []byte("x++"), []byte("x += 2"), 1)
+	if regions, err := Regions(edited); err != nil || len(regions) != 1 || !regions[0].Tampered() {
+		t.Errorf("edited Regions = %+v, %v; want one tampered loop", regions, err)
+	}
+	if rerolled := apply(t, Reroll, "sum.go", got); !bytes.Equal(rerolled, src) {
+		t.Errorf("reroll of marked loop; got:\n%s\nwant:\n%s", rerolled, src)
+	}
+	c.Factor = 3
+	if again := apply(t, c.File, "sum.go", got); bytes.Contains(again, []byte(SumPrefix)) {
+		t.Errorf("unrolling marked loop again kept its checksum; got:\n%s", again)
+	}
+}
+
+func TestNestedGenerated(t *testing.T) {
+	src := []byte(`package p
+
+import "testing"
+
+func BenchmarkX(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		testing.Benchmark(func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				x++
+			}
+		})
+	}
+}
+
+func main() {
+	testing.Benchmark(func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			x++
+		}
+	})
+}
+`)
+	c := Config{Factor: 2, Literals: true}
+	once := apply(t, c.File, "nested.go", src)
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "nested.go", once, parser.ParseComments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names, _ := LiteralsIn(f.Decls[2].(*ast.FuncDecl))
+	if !slices.Equal(names, []string{"main.func1"}) {
+		t.Errorf("LiteralsIn(main) = %v, want [main.func1]", names)
+	}
+	if names, _ := LiteralsIn(f.Decls[1].(*ast.FuncDecl)); len(names) != 0 {
+		t.Errorf("LiteralsIn(BenchmarkX) = %v, want none inside generated code", names)
+	}
+	if c.File(fset, f) {
+		t.Errorf("unrolling again changed the file")
+	}
+
+	// A loop around generated code is left alone.
+	wrapped := bytes.Replace(once, []byte("func BenchmarkX(b *testing.B) {\n"), []byte("func BenchmarkX(b *testing.B) {\n\tfor j := 0; j < b.N; j++ {\n"), 1)
+	wrapped = bytes.Replace(wrapped, []byte("\n}\n\nfunc main"), []byte("\n}\n}\n\nfunc main"), 1)
+	if got := apply(t, c.File, "nested.go", wrapped); bytes.Count(got, []byte("bNUnroll :=")) != bytes.Count(wrapped, []byte("bNUnroll :=")) {
+		t.Errorf("unrolled a loop around generated code:\n%s", got)
+	}
+}
+
+func TestInterleave(t *testing.T) {
+	src := []byte(`package p
+
+import "testing"
+
+func BenchmarkIndependent(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		x = f(x)
+		y = g(y)
+	}
+}
+
+func BenchmarkDependent(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		x++
+		y = x
+	}
+}
+`)
+	c := Config{Factor: 2, Interleave: true}
+	got := apply(t, c.File, "interleave.go", src)
+	want := `package p
+
+import "testing"
+
+func BenchmarkIndependent(b *testing.B) {
+	if b.N < 2 {
+		for i := 0; i < b.N; i++ {
+			x = f(x)
+			y = g(y)
+		}
+	} else {
+		for i, bNUnroll := 0, b.N/2; i < bNUnroll; i++ {
+			{
+				x = f(x)
+				x = f(x)
+				y = g(y)
+				y = g(y)
+			}
+		}
+	}
+}
+
+func BenchmarkDependent(b *testing.B) {
+	if b.N < 2 {
+		for i := 0; i < b.N; i++ {
+			x++
+			y = x
+		}
+	} else {
+		for i, bNUnroll := 0, b.N/2; i < bNUnroll; i++ {
+			{
+				x++
+				y = x
+			}
+			{
+				x++
+				y = x
+			}
+		}
+	}
+}
+`
+	if string(got) != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", got, want)
+	}
+	if again := apply(t, c.File, "interleave.go", got); !bytes.Equal(again, got) {
+		t.Errorf("unrolling interleaved loops again changed them:\n%s", again)
+	}
+	if rerolled := apply(t, Reroll, "interleave.go", got); !bytes.Equal(rerolled, src) {
+		t.Errorf("reroll of interleaved loops; got:\n%s\nwant:\n%s", rerolled, src)
+	}
+	c.Interleave = false
+	if flat := apply(t, c.File, "interleave.go", got); !bytes.Equal(flat, apply(t, c.File, "interleave.go", src)) {
+		t.Errorf("unrolling without Interleave did not undo it:\n%s", flat)
+	}
+}
+
+func TestSequential(t *testing.T) {
+	src := []byte(`package p
+
+import "testing"
+
+func BenchmarkParallel(b *testing.B) {
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			x.Add(i)
+		}
+	})
+}
+
+func BenchmarkSetup(b *testing.B) {
+	b.RunParallel(func(pb *testing.PB) {
+		var buf []byte
+		for pb.Next() {
+			buf = f(buf)
+		}
+	})
+}
+`)
+	c := Config{Factor: 2, Sequential: true}
+	got := apply(t, c.File, "parallel.go", src)
+	want := `package p
+
+import "testing"
+
+func BenchmarkParallel(b *testing.B) {
+	if b.N < 2 {
+		for j := 0; j < b.N; j++ {
+			x.Add(i)
+		}
+	} else {
+		for j, bNUnroll := 0, b.N/2; j < bNUnroll; j++ {
+			{
+				x.Add(i)
+			}
+			{
+				x.Add(i)
+			}
+		}
+	}
+}
+
+func BenchmarkSetup(b *testing.B) {
+	b.RunParallel(func(pb *testing.PB) {
+		var buf []byte
+		for pb.Next() {
+			buf = f(buf)
+		}
+	})
+}
+`
+	if string(got) != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", got, want)
+	}
+	if again := apply(t, (&Config{Factor: 2}).File, "parallel.go", src); !bytes.Equal(again, src) {
+		t.Errorf("without Sequential, got:\n%s", again)
+	}
+	// Benchmarks that are not unrolled stay parallel.
+	c.Decide = func(*ast.FuncDecl, ast.Stmt, int) int { return 0 }
+	if again := apply(t, c.File, "parallel.go", src); !bytes.Equal(again, src) {
+		t.Errorf("Decide returned 0, got:\n%s", again)
+	}
+}
+
+// Limit has the last word, as a policy's max-factor does over -factors.
+func TestLimit(t *testing.T) {
+	src := []byte(`package p
+
+import "testing"
+
+func BenchmarkAdd(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		x++
+	}
+}
+`)
+	c := Config{
+		Decide: func(*ast.FuncDecl, ast.Stmt, int) int { return 12 },
+		Limit:  func(_ *ast.FuncDecl, _ ast.Stmt, factor int) int { return min(factor, 4) },
+	}
+	got := apply(t, c.File, "limit_test.go", src)
+	if !bytes.Contains(got, []byte("if b.N < 4 {")) {
+		t.Errorf("not unrolled 4 times:\n%s", got)
+	}
+	// Already unrolled 12 times, as without the limit.
+	limit := c.Limit
+	c.Limit = nil
+	twelve := apply(t, c.File, "limit_test.go", src)
+	c.Limit = limit
+	if again := apply(t, c.File, "limit_test.go", twelve); !bytes.Equal(again, got) {
+		t.Errorf("unrolled again, got:\n%s\nwant:\n%s", again, got)
+	}
+	c.Limit = func(*ast.FuncDecl, ast.Stmt, int) int { return 0 }
+	if again := apply(t, c.File, "limit_test.go", src); !bytes.Equal(again, src) {
+		t.Errorf("Limit returned 0, got:\n%s", again)
+	}
+	c.Decide = nil
+	if again := apply(t, c.File, "limit_test.go", src); !bytes.Equal(again, src) {
+		t.Errorf("Limit returned 0 without Decide, got:\n%s", again)
+	}
+}
+
+func TestModernize(t *testing.T) {
+	src := []byte(`package p
+
+import "testing"
+
+func BenchmarkUnused(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		x++
+	}
+}
+
+func BenchmarkUsed(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		x += i
+	}
+}
+`)
+	got := apply(t, Modernize, "modernize.go", src)
+	want := `package p
+
+import "testing"
+
+func BenchmarkUnused(b *testing.B) {
+	for range b.N {
+		x++
+	}
+}
+
+func BenchmarkUsed(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		x += i
+	}
+}
+`
+	if string(got) != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", got, want)
+	}
+	unrolled := apply(t, (&Config{Factor: 2}).File, "modernize.go", src)
+	if again := apply(t, Modernize, "modernize.go", unrolled); !bytes.Equal(again, unrolled) {
+		t.Errorf("Modernize changed unrolled loops; got:\n%s", again)
+	}
+}
+
+func TestRestyle(t *testing.T) {
+	src := []byte(`package p
+
+import "testing"
+
+func BenchmarkClassic(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		x++
+	}
+}
+
+func BenchmarkIndex(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		x += i
+	}
+}
+
+func BenchmarkRange(b *testing.B) {
+	for range b.N {
+		x++
+	}
+}
+
+func BenchmarkLoop(b *testing.B) {
+	for b.Loop() {
+		x++
+	}
+}
+`)
+	styles := func(src []byte) []string {
+		f, err := parser.ParseFile(token.NewFileSet(), "style.go", src, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var list []string
+		for _, d := range f.Decls {
+			if fn, ok := d.(*ast.FuncDecl); ok {
+				style, factor := Style(fn.Body.List[0])
+				if factor != 0 {
+					style += "=" + strconv.Itoa(factor)
+				}
+				list = append(list, style)
+			}
+		}
+		return list
+	}
+	if got, want := styles(src), []string{"classic", "classic", "range", "loop"}; !slices.Equal(got, want) {
+		t.Fatalf("Style = %v, want %v", got, want)
+	}
+	tests := []struct {
+		style string
+		want  []string
+	}{
+		{StyleClassic, []string{"classic", "classic", "classic", "loop"}},
+		{StyleRange, []string{"range", "range", "range", "loop"}},
+		{StyleLoop, []string{"loop", "classic", "loop", "loop"}},
+		{StyleUnrolled, []string{"unrolled=2", "unrolled=2", "unrolled=2", "loop"}},
+	}
+	for _, tt := range tests {
+		c := &Config{Factor: 2}
+		got := apply(t, func(fset *token.FileSet, f *ast.File) bool { return c.Restyle(fset, f, tt.style) }, "style.go", src)
+		if styles := styles(got); !slices.Equal(styles, tt.want) {
+			t.Errorf("Restyle %s: styles %v, want %v; got:\n%s", tt.style, styles, tt.want, got)
+		}
+		if tt.style == StyleLoop {
+			continue // b.Loop loops stay as they are
+		}
+		back := apply(t, func(fset *token.FileSet, f *ast.File) bool { return c.Restyle(fset, f, StyleClassic) }, "style.go", got)
+		if styles := styles(back); !slices.Equal(styles, []string{"classic", "classic", "classic", "loop"}) {
+			t.Errorf("Restyle %s then classic: styles %v; got:\n%s", tt.style, styles, back)
+		}
+	}
+}
+
+// Loops whose functions rely on b.N otherwise stay as they are in StyleLoop.
+func TestRestyleLoopAlone(t *testing.T) {
+	src := []byte(`package p
+
+import "testing"
+
+func BenchmarkTwo(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		x++
+	}
+	for range b.N {
+		y++
+	}
+}
+
+func BenchmarkTimer(b *testing.B) {
+	b.StopTimer()
+	setup()
+	b.StartTimer()
+	for i := 0; i < b.N; i++ {
+		x++
+	}
+}
+
+func BenchmarkSized(b *testing.B) {
+	buf := make([]byte, b.N)
+	for i := 0; i < b.N; i++ {
+		x += buf[0]
+	}
+}
+`)
+	c := new(Config)
+	got := apply(t, func(fset *token.FileSet, f *ast.File) bool { return c.Restyle(fset, f, StyleLoop) }, "style.go", src)
+	if !bytes.Equal(got, src) {
+		t.Errorf("Restyle %s rewrote loops:\n%s", StyleLoop, got)
+	}
+}
+
+func TestMayContain(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		src  string
+		want bool
+	}{
+		{"benchmark", "package p\n\nimport \"testing\"\n\nfunc BenchmarkA(b *testing.B) {\n\tfor i := 0; i < b.N; i++ {\n\t}\n}\n", true},
+		{"helper", "package p\n\nfunc run(n int) {\n\tfor i := 0; i < b.N; i++ {\n\t}\n}\n", true},
+		{"comment", "package p\n\n// BenchmarkA was here:\n//\n//\tfunc BenchmarkA(b *testing.B) {}\n", true},
+		{"test", "package p\n\nimport \"testing\"\n\nfunc TestA(t *testing.T) {}\n", false},
+		{"empty", "package p\n", false},
+	} {
+		if got := MayContain([]byte(tt.src), DefaultMarkers); got != tt.want {
+			t.Errorf("%s: MayContain = %v, want %v", tt.name, got, tt.want)
+		}
+		if !MayContain([]byte(tt.src), nil) {
+			t.Errorf("%s: MayContain with no markers = false, want true", tt.name)
+		}
+	}
+}
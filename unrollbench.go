@@ -1,271 +1,545 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"go/ast"
 	"go/build"
-	"go/parser"
-	"go/printer"
 	"go/token"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"runtime"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
+
+	"github.com/josharian/unrollbench/bench"
+	"github.com/josharian/unrollbench/unroll"
+)
+
+// A command is an unrollbench subcommand.
+type command struct {
+	name  string
+	args  string // argument synopsis, for usage
+	short string // one line description, for usage
+	flags *flag.FlagSet
+	run   func(c *command, args []string)
+}
+
+func newCommand(name, args, short string, run func(c *command, args []string)) *command {
+	c := &command{name: name, args: args, short: short, run: run}
+	c.flags = flag.NewFlagSet(name, flag.ExitOnError)
+	c.flags.Usage = func() {
+		fmt.Printf("usage: unrollbench %s %s\n", c.name, c.args)
+		c.flags.PrintDefaults()
+		os.Exit(2)
+	}
+	return c
+}
+
+var commands = []*command{
+	unrollCmd,
+	rerollCmd,
+	normalizeCmd,
+	modernizeCmd,
+	revertCmd,
+	checkCmd,
+	checkStyleCmd,
+	verifyCmd,
+	estimateCmd,
+	lintCmd,
+	runCmd,
+	rankCmd,
+	testCmd,
+	factorsCmd,
+	tuneCmd,
+	bisectCmd,
+	asmCmd,
+	inlineCmd,
+	sizeCmd,
+	calibrateCmd,
+	reportCmd,
+	serveCmd,
+	surveyCmd,
+	exportRulesCmd,
+}
+
+var (
+	unrollCmd    = newCommand("unroll", "[packages]", "unroll benchmark loops in place", runUnroll)
+	rerollCmd    = newCommand("reroll", "[packages]", "revert unrolled benchmark loops in place", runReroll)
+	normalizeCmd = newCommand("normalize", "[packages]", "rewrite hand-unrolled benchmark loops into unrollbench's form, in place", runNormalize)
+	modernizeCmd = newCommand("modernize", "[packages]", "rewrite benchmark loops that do not use their loop variable into for range b.N, in place", runModernize)
 )
 
+func usage() {
+	fmt.Println("usage: unrollbench <command> [arguments]")
+	fmt.Println()
+	fmt.Println("commands:")
+	for _, c := range commands {
+		fmt.Printf("\t%-8s %s\n", c.name, c.short)
+	}
+	os.Exit(2)
+}
+
+// ctx is canceled when unrollbench is interrupted,
+// so that it stops between files instead of in the middle of writing one.
+var ctx = context.Background()
+
 func main() {
-	wd, err := os.Getwd()
 	if len(os.Args) < 2 {
-		fmt.Println("usage: unrollbench [packages]")
-		os.Exit(2)
+		usage()
 	}
+	var stop context.CancelFunc
+	ctx, stop = signal.NotifyContext(context.Background(), os.Interrupt)
+	go func() {
+		// A second interrupt kills unrollbench right away.
+		<-ctx.Done()
+		stop()
+	}()
+	for _, c := range commands {
+		if c.name == os.Args[1] {
+			c.flags.Parse(os.Args[2:])
+			c.run(c, c.flags.Args())
+			exit(0)
+		}
+	}
+	fmt.Printf("unrollbench: unknown command %q\n", os.Args[1])
+	usage()
+}
+
+// Flags for loading packages.
+var (
+	includeAsmCoupled bool
+	followSymlinks    bool
+	relPaths          bool
+	benchLiterals     bool
+	nonTestFiles      bool
+)
+
+func init() {
+	for _, c := range []*command{unrollCmd, rerollCmd, normalizeCmd, modernizeCmd, revertCmd, checkCmd, checkStyleCmd, estimateCmd, lintCmd, runCmd, rankCmd, testCmd, factorsCmd, tuneCmd, bisectCmd, asmCmd, inlineCmd, sizeCmd} {
+		c.flags.BoolVar(&includeAsmCoupled, "asm-coupled", false, "include packages whose tests look coupled to assembly or generated tables, which are skipped by default")
+		c.flags.BoolVar(&followSymlinks, "follow-symlinks", false, "include test files that are symlinks to files outside their module, which are skipped by default")
+		c.flags.BoolVar(&relPaths, "rel", false, "print file paths relative to the current directory, or failing that their module root, instead of as absolute paths")
+	}
+	for _, c := range []*command{unrollCmd, rerollCmd, normalizeCmd, modernizeCmd, checkCmd, checkStyleCmd, estimateCmd, lintCmd, testCmd, surveyCmd} {
+		c.flags.BoolVar(&nonTestFiles, "non-test", false, "also look for benchmark functions, such as shared benchmark suites, in the packages' non-test files")
+	}
+	for _, c := range []*command{unrollCmd, rerollCmd, testCmd} {
+		c.flags.BoolVar(&benchLiterals, "literals", false, "also rewrite loops in func literals passed to testing.Benchmark, looking in the packages' non-test files too")
+	}
+}
+
+// skipped records the packages and files already reported as skipped,
+// and why.
+var skipped = make(map[string]string)
+
+// skip reports skipping path, once.
+func skip(path, why string) {
+	if _, ok := skipped[path]; !ok {
+		fmt.Fprintf(os.Stderr, "Skipping %s: %s\n", path, why)
+		skipped[path] = why
+	}
+}
+
+// loadPackages returns the packages with import paths paths,
+// except those whose tests look coupled to assembly.
+// A package reached by more than one path, such as through a symlink,
+// is loaded once.
+func loadPackages(paths []string) []*build.Package {
+	wd, err := os.Getwd()
 	if err != nil {
 		fatal(err)
 	}
-	var files []string
-	for _, path := range os.Args[1:] {
-		if path == "syscall" {
-			// syscall is a snowflake. Leave it alone.
-			continue
+	var pkgs []*build.Package
+	dirs := make(map[string]bool) // canonical
+	for _, path := range paths {
+		if ctx.Err() != nil {
+			fatal("interrupted")
 		}
 		pkg, err := build.Import(path, wd, 0)
+		if err != nil && strings.HasPrefix(err.Error(), "use of cgo in test") {
+			// The package is otherwise complete;
+			// rewrite decides what to do with such files.
+			err = nil
+		}
 		if err != nil {
-			fatal(err)
+			fail(err)
+			continue
 		}
-		for _, file := range pkg.TestGoFiles {
-			files = append(files, filepath.Join(pkg.Dir, file))
+		if !includeAsmCoupled {
+			if why := asmCoupled(pkg); why != "" {
+				skip(pkg.ImportPath, "tests look coupled to assembly ("+why+"); use -asm-coupled to include it")
+				continue
+			}
 		}
-		for _, file := range pkg.XTestGoFiles {
-			files = append(files, filepath.Join(pkg.Dir, file))
+		dir := canonical(pkg.Dir)
+		if dirs[dir] {
+			continue
 		}
+		dirs[dir] = true
+		pkgs = append(pkgs, pkg)
 	}
+	// Process packages in the same order however they were named.
+	sort.Slice(pkgs, func(i, j int) bool { return pkgs[i].ImportPath < pkgs[j].ImportPath })
+	return pkgs
+}
 
-	for _, file := range files {
-		fmt.Println("Processing", file)
-		fset := token.NewFileSet()
-		// TODO: avoid stripping build tags
-		f, err := parser.ParseFile(fset, file, nil, parser.ParseComments)
-		if err != nil {
-			fatal(err)
-		}
-		fi, err := os.Stat(file)
-		if err != nil {
-			fatal(err)
+// testFiles returns the test files in pkgs,
+// and with -literals or -non-test, their other Go files too.
+// Each file appears once, even if reached by more than one path,
+// and symlinks out of a package's module are left out unless -follow-symlinks.
+func testFiles(pkgs ...*build.Package) []string {
+	var files []string
+	seen := make(map[string]bool) // canonical
+	for _, pkg := range pkgs {
+		root := moduleRoot(canonical(pkg.Dir))
+		names := slices.Concat(pkg.TestGoFiles, pkg.XTestGoFiles)
+		if benchLiterals || nonTestFiles {
+			names = append(names, pkg.GoFiles...)
 		}
-
-		for _, d := range f.Decls {
-			fn, ok := d.(*ast.FuncDecl)
-			// Find benchmark-like functions.
-			// We are flexible here because we want to detect and rewrite
-			// helper functions like this one from math/big:
-			// 	func benchmarkBitLenN(b *testing.B, nbits uint) {
-			// 		testword := Word((uint64(1) << nbits) - 1)
-			// 		for i := 0; i < b.N; i++ {
-			// 			bitLen(testword)
-			// 		}
-			// 	}
-			if !ok || !isBench(fn) {
+		sort.Strings(names)
+		for _, name := range names {
+			file := filepath.Join(pkg.Dir, name)
+			c := canonical(file)
+			if seen[c] {
 				continue
 			}
-
-			// Keep it simple: Look for top level for loops up to b.N.
-			// This also makes this operation idempotent, since the
-			// rewrite moves the loops inside an if/then/else statement.
-			for i, s := range fn.Body.List {
-				ok, id, body := isBenchForLoop(s)
-				if !ok {
-					continue
-				}
-				newfor := unrolled(s.(*ast.ForStmt), id, body)
-				fn.Body.List[i] = newfor
+			seen[c] = true
+			if !followSymlinks && !within(c, root) {
+				skip(rel(file), "links to "+c+", outside its module; use -follow-symlinks to include it")
+				continue
 			}
+			files = append(files, file)
 		}
-
-		c, err := os.OpenFile(file, os.O_WRONLY|os.O_TRUNC, fi.Mode())
-		if err != nil {
-			fatal(err)
-		}
-		if err := printer.Fprint(c, fset, f); err != nil {
-			fatal(err)
-		}
-		c.Close()
 	}
+	return files
 }
 
-func fatal(msg interface{}) {
-	fmt.Println(msg)
-	os.Exit(1)
+// Flags shared by the commands that rewrite files.
+var (
+	outDir       string
+	overlayFile  string
+	backup       bool
+	backupSuffix string
+	backupDir    string
+	jobs         = runtime.GOMAXPROCS(0)
+)
+
+func init() {
+	for _, c := range []*command{unrollCmd, rerollCmd, normalizeCmd, modernizeCmd, lintCmd} {
+		c.flags.StringVar(&outDir, "o", "", "write rewritten packages under `dir`, at their import paths, instead of in place")
+		c.flags.StringVar(&overlayFile, "overlay", "", "write rewritten files to the cache directory and a go build -overlay description of them to `file`, instead of in place")
+		c.flags.BoolVar(&backup, "backup", false, "save the contents of each file before overwriting it")
+		c.flags.StringVar(&backupSuffix, "backup-suffix", ".orig", "append `suffix` to the names of backup files")
+		c.flags.StringVar(&backupDir, "backup-dir", "", "write backup files under `dir`, at their absolute paths, instead of next to the originals")
+		c.flags.BoolVar(&noProgress, "no-progress", false, "do not report progress through packages on standard error")
+		c.flags.IntVar(&jobs, "j", runtime.GOMAXPROCS(0), "rewrite up to `n` files at once")
+	}
 }
 
-// isBench reports whether n is a benchmark.
-// It assumes that the testing package has been imported
-// under its own name.
-func isBench(n *ast.FuncDecl) bool {
-	if !strings.HasPrefix(strings.ToLower(n.Name.Name), "bench") ||
-		n.Type.Params == nil ||
-		len(n.Type.Params.List) == 0 {
-		return false
+var (
+	factor       int
+	autoFactor   bool
+	maxCopies    int
+	interleave   bool
+	factorsFile  string
+	maxNsPerOp   float64
+	resultsFile  string
+	archList     string
+	buildTag     string
+	duplicate    bool
+	variantList  string
+	variants     []int
+	keepOriginal bool
+	sequential   bool
+	lineDirs     bool
+	copyComments bool
+	interactive  bool
+	watch        bool
+)
+
+func init() {
+	factor = unroll.DefaultFactor
+	for _, c := range []*command{unrollCmd, runCmd, rankCmd, testCmd, asmCmd, inlineCmd, sizeCmd} {
+		c.flags.Var(&factorFlag{&factor, &autoFactor}, "factor", "unroll loops into `n` copies of their body, or with auto, into more copies of smaller bodies, leaving large ones alone")
+		c.flags.IntVar(&maxCopies, "max-copies", 0, "write at most `n` copies of a body in a row, repeating them in an inner loop for larger factors")
+		c.flags.BoolVar(&interleave, "interleave", false, "experimental: in bodies of independent simple statements, order the copies' statements by statement rather than by copy, to study the effect of scheduling")
 	}
+	tuneCmd.flags.IntVar(&factor, "factor", unroll.DefaultFactor, "unroll loops into `n` copies of their body")
+	unrollCmd.flags.StringVar(&factorsFile, "factors", "", "read per-benchmark factors from `file`, as written by factors -o")
+	unrollCmd.flags.Float64Var(&maxNsPerOp, "max-ns-per-op", 0, "unroll only benchmarks faster than `n` ns/op, running them once to find out unless -results is set")
+	unrollCmd.flags.StringVar(&resultsFile, "results", "", "pick benchmarks and factors using the go test -bench output in `file`, instead of running the benchmarks")
+	unrollCmd.flags.BoolVar(&interactive, "i", false, "show each rewrite and ask whether to make it")
+	unrollCmd.flags.StringVar(&buildTag, "tag", "", "leave benchmarks in place, and write unrolled copies to _unrolled_test.go files selected by build tag `tag`")
+	unrollCmd.flags.StringVar(&archList, "arch", "", "leave benchmarks in place, and write copies unrolled by per-architecture factors to _arch_test.go files, for a `list` like amd64=16,arm64=4")
+	unrollCmd.flags.BoolVar(&duplicate, "dup", false, "leave benchmarks in place, and add unrolled copies named BenchmarkXxxUnrolled")
+	unrollCmd.flags.StringVar(&variantList, "variants", "", "leave benchmarks in place, and add copies unrolled by each factor in comma-separated `list`, such as 2,4,8,16, named like BenchmarkXxxUnrolled8, to measure them all in one run")
+	unrollCmd.flags.BoolVar(&keepOriginal, "keep-original", false, "keep each original loop as a comment above its replacement")
+	unrollCmd.flags.BoolVar(&sequential, "sequential", false, "first turn b.RunParallel benchmarks whose function is just a pb.Next loop into sequential loops over b.N, to measure single-threaded costs")
+	unrollCmd.flags.BoolVar(&copyComments, "copy-comments", false, "label each copy of an unrolled loop's body with a comment like // unroll copy 3/10")
+	unrollCmd.flags.BoolVar(&lineDirs, "line-directives", false, "add //line directives so that profiles and panics attribute each unrolled loop, and the code after it, to the original lines")
+	unrollCmd.flags.BoolVar(&watch, "watch", false, "keep running, and unroll again whenever the packages' test files change")
+}
 
-	// Check that one of the params is b *testing.B.
-	for _, p := range n.Type.Params.List {
-		if len(p.Names) != 1 || p.Names[0].Name != "b" {
-			continue
+func runUnroll(c *command, args []string) {
+	if len(args) < 1 {
+		c.flags.Usage()
+	}
+	pkgs := loadPackages(args)
+	prepareGit(pkgs)
+	if factor < 2 {
+		fatal("-factor must be at least 2")
+	}
+	if variantList != "" {
+		if duplicate || archList != "" || buildTag != "" {
+			fatal("-variants cannot be used with -dup, -arch, or -tag")
 		}
-		star, ok := p.Type.(*ast.StarExpr)
-		if !ok {
-			continue
+		for _, s := range strings.Split(variantList, ",") {
+			n, err := strconv.Atoi(strings.TrimSpace(s))
+			if err != nil || n < 2 {
+				fatal(fmt.Sprintf("bad -variants factor %q; want numbers of at least 2", s))
+			}
+			variants = append(variants, n)
 		}
-		sel, ok := star.X.(*ast.SelectorExpr)
-		if !ok || sel.Sel.Name != "B" {
-			continue
+	}
+	if archList != "" {
+		if buildTag != "" {
+			fatal("-arch and -tag are mutually exclusive")
 		}
-		id, ok := sel.X.(*ast.Ident)
-		if !ok || id.Name != "testing" {
-			continue
+		var err error
+		if archFactors, err = parseArchFactors(archList); err != nil {
+			fatal(err)
+		}
+	}
+	if factorsFile != "" {
+		t, err := readFactors(factorsFile)
+		if err != nil {
+			fatal(err)
 		}
-		return true
+		benchFactors = t.byDir(pkgs)
 	}
+	switch {
+	case resultsFile != "":
+		f, err := os.Open(resultsFile)
+		if err != nil {
+			fatal(err)
+		}
+		results, err := bench.Parse(f)
+		f.Close()
+		if err != nil {
+			fatal(err)
+		}
+		nsPerOp = fastest(results, pkgs)
+	case maxNsPerOp > 0:
+		nsPerOp = measure(args, pkgs)
+	}
+	if profileFile != "" {
+		p, err := readProfile(profileFile)
+		if err != nil {
+			fatal(err)
+		}
+		profiledLoops = pickProfiled(p, pkgs)
+	}
+	if watch {
+		if commitBranch != "" {
+			fatal("-watch cannot be used with -commit")
+		}
+		watchPackages(args, func(pkgs []*build.Package) {
+			pickFactorConstFiles(pkgs)
+			rewrite(pkgs, true, unrollFile)
+		})
+	}
+	pickFactorConstFiles(pkgs)
+	changes := rewrite(pkgs, true, unrollFile)
+	reportHelpers(pkgs, changes)
+	commitGit(changes, "unroll benchmark loops")
+	writeMarkdown(changes, nil, nil)
+}
 
-	return false
+// A factorFlag is the value of -factor: a number, or auto.
+type factorFlag struct {
+	n    *int
+	auto *bool
 }
 
-// isBenchForLoop reports whether n a statement of the form:
-//
-// for i := 0; i < b.N; i++ {
-//   // body
-// }
-//
-// in which i is any ident?
-// TODO: be more flexible in what we look for. (samesafeexpr)
-// TODO: make sure that i is not read and b.N is not written to in the body. Or elsewhere either?
-func isBenchForLoop(n ast.Stmt) (is bool, id string, body *ast.BlockStmt) {
-	f, ok := n.(*ast.ForStmt)
-	if !ok {
-		return
+func (f *factorFlag) String() string {
+	switch {
+	case f.n == nil:
+		return ""
+	case *f.auto:
+		return "auto"
 	}
+	return strconv.Itoa(*f.n)
+}
 
-	if f.Init == nil || f.Cond == nil || f.Post == nil {
-		return
+func (f *factorFlag) Set(s string) error {
+	if s == "auto" {
+		*f.auto = true
+		return nil
 	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return errors.New("want a number or auto")
+	}
+	if n < 2 {
+		// A single copy would only add churn.
+		return fmt.Errorf("factor %d would not unroll anything; want at least 2", n)
+	}
+	*f.n, *f.auto = n, false
+	return nil
+}
 
-	// condition not of form a < b
-	bin, ok := f.Cond.(*ast.BinaryExpr)
-	if !ok || bin.Op != token.LSS {
-		return
+// factorDesc describes -factor for messages.
+func factorDesc() string {
+	if autoFactor {
+		return "by -factor=auto"
 	}
+	return fmt.Sprintf("%d times", factor)
+}
 
-	// rhs must be b.N
-	sel, ok := bin.Y.(*ast.SelectorExpr)
-	if !ok || sel.Sel.Name != "N" {
-		return
+// unrollFile unrolls the loops in f as configured by the command line flags.
+func unrollFile(fset *token.FileSet, f *ast.File) bool {
+	return unrollFileBy(fset, f, factor, autoFactor)
+}
+
+// unrollFileBy is like unrollFile, but unrolls by k, or if auto is set,
+// by unroll.AutoFactor, in place of -factor.
+func unrollFileBy(fset *token.FileSet, f *ast.File, k int, auto bool) bool {
+	c := unroll.Config{Factor: k, Auto: auto, MaxCopies: maxCopies, Interleave: interleave, FactorConst: factorConst, KeepOriginal: keepOriginal, Sequential: sequential, Literals: benchLiterals}
+	var decide []func(*ast.FuncDecl, ast.Stmt, int) int
+	if d := filePolicy(fset, f).decide(); d != nil {
+		// First, so that nothing else considers loops the policy rules out,
+		// and last, so that nothing overrides it.
+		decide = append(decide, d)
+		c.Limit = d
 	}
-	x, ok := sel.X.(*ast.Ident)
-	if !ok || x.Name != "b" {
-		return
+	if nsPerOp != nil {
+		decide = append(decide, decideMeasured(fset, f))
 	}
-
-	// i must be an ident
-	i, ok := bin.X.(*ast.Ident)
-	if !ok {
-		return
+	if benchFactors != nil {
+		decide = append(decide, benchFactors.decide(fset, f))
+	}
+	if profiledLoops != nil {
+		decide = append(decide, decideProfiled(fset, f))
 	}
+	if interactive {
+		decide = append(decide, prompt.decide(fset, f))
+	}
+	if len(decide) > 0 {
+		c.Decide = func(fn *ast.FuncDecl, s ast.Stmt, factor int) int {
+			for _, d := range decide {
+				if factor = d(fn, s, factor); factor == 0 {
+					break
+				}
+			}
+			return factor
+		}
+	}
+	if !nonTestFiles && !strings.HasSuffix(fset.File(f.Pos()).Name(), "_test.go") {
+		// Only -literals reads non-test files.
+		return c.BenchmarkLiterals(fset, f)
+	}
+	var changed bool
+	switch {
+	case variants != nil:
+		changed = c.Variants(f, variants)
+	case duplicate:
+		changed = c.Duplicate(f, "Unrolled")
+	default:
+		changed = c.File(fset, f)
+	}
+	return declareFactorConst(fset, f, k) || changed
+}
 
-	ini, ok := f.Init.(*ast.AssignStmt)
-	if !ok || len(ini.Lhs) != 1 || len(ini.Rhs) != 1 {
-		return
+func runReroll(c *command, args []string) {
+	if len(args) < 1 {
+		c.flags.Usage()
 	}
+	pkgs := loadPackages(args)
+	prepareGit(pkgs)
+	changes := rewrite(pkgs, false, unroll.Reroll)
+	commitGit(changes, "revert unrolled benchmark loops")
+	writeMarkdown(changes, nil, nil)
+}
 
-	inilhs, ok := ini.Lhs[0].(*ast.Ident)
-	if !ok || inilhs.Name != i.Name {
-		return
+func runNormalize(c *command, args []string) {
+	if len(args) < 1 {
+		c.flags.Usage()
 	}
+	pkgs := loadPackages(args)
+	prepareGit(pkgs)
+	changes := rewrite(pkgs, true, unroll.Normalize)
+	commitGit(changes, "normalize hand-unrolled benchmark loops")
+	writeMarkdown(changes, nil, nil)
+}
 
-	post, ok := f.Post.(*ast.IncDecStmt)
-	if !ok || post.Tok != token.INC {
-		return
+func runModernize(c *command, args []string) {
+	if len(args) < 1 {
+		c.flags.Usage()
 	}
-	postlhs, ok := post.X.(*ast.Ident)
-	if !ok || postlhs.Name != i.Name {
-		return
+	var pkgs []*build.Package
+	for _, pkg := range loadPackages(args) {
+		if !langAllows(pkg.Dir, langRangeInt) {
+			skip(rel(pkg.Dir), "language version "+langFor(pkg.Dir)+" is below "+langRangeInt+", which added range over ints")
+			continue
+		}
+		pkgs = append(pkgs, pkg)
 	}
+	prepareGit(pkgs)
+	changes := rewrite(pkgs, false, unroll.Modernize)
+	commitGit(changes, "range over b.N in benchmark loops")
+	writeMarkdown(changes, nil, nil)
+}
 
-	return true, i.Name, f.Body
+func fatal(msg interface{}) {
+	fmt.Println(msg)
+	os.Exit(1)
 }
 
-func basicInt(i int) *ast.BasicLit {
-	return &ast.BasicLit{Kind: token.INT, Value: strconv.Itoa(i)}
+// failures are the errors in files and packages
+// that were skipped so that the others could be processed.
+var failures []error
+
+// unparsable are the errors from the files skipped because they do not parse.
+// They are summarized on exit, but do not fail the run.
+var unparsable []error
+
+// fail reports err, and records it for exit.
+// If err is an unparsableError, fail instead records the file as skipped, once.
+func fail(err error) {
+	if u, ok := err.(unparsableError); ok {
+		if _, ok := skipped[u.file]; !ok {
+			fmt.Println(err)
+			skipped[u.file] = "does not parse"
+			unparsable = append(unparsable, err)
+		}
+		return
+	}
+	fmt.Println(err)
+	failures = append(failures, err)
 }
 
-func unrolled(f *ast.ForStmt, id string, body *ast.BlockStmt) ast.Stmt {
-	// Build:
-	// if b.N < 10 {
-	// 	for i := 0; i < b.N; i++ {
-	//		// body
-	// 	}
-	// } else {
-	// 	for i, bNUnroll := 0, b.N / 10; i < bNUnroll; i++ {
-	//   {
-	//     // body
-	//   }
-	//   // repeat 9 more times
-	// }
-
-	s := &ast.IfStmt{
-		Cond: &ast.BinaryExpr{
-			X: ast.NewIdent("b.N"), // cheating a little
-			Y: &ast.BasicLit{
-				Kind:  token.INT,
-				Value: "10",
-			},
-			Op: token.LSS,
-		},
-	}
-
-	s.Body = &ast.BlockStmt{
-		List: []ast.Stmt{
-			&ast.ForStmt{
-				Init: f.Init,
-				Cond: f.Cond,
-				Post: f.Post,
-				Body: body,
-			},
-		},
-	}
-
-	var ten []ast.Stmt
-	for i := 0; i < 10; i++ {
-		ten = append(ten, body)
-	}
-
-	s.Else = &ast.BlockStmt{
-		List: []ast.Stmt{
-			&ast.ForStmt{
-				Init: &ast.AssignStmt{
-					Lhs: []ast.Expr{
-						ast.NewIdent(id),
-						ast.NewIdent("bNUnroll"),
-					},
-					Tok: token.DEFINE,
-					Rhs: []ast.Expr{
-						basicInt(0),
-						&ast.BinaryExpr{
-							X:  ast.NewIdent("b.N"), // cheat
-							Y:  basicInt(10),
-							Op: token.QUO,
-						},
-					},
-				},
-				Cond: &ast.BinaryExpr{
-					X:  ast.NewIdent(id),
-					Y:  ast.NewIdent("bNUnroll"),
-					Op: token.LSS,
-				},
-				Post: f.Post,
-				Body: &ast.BlockStmt{List: ten},
-			},
-		},
-	}
-
-	return s
+// exit exits with code, or if there were failures,
+// summarizes them and exits with a non-zero code.
+func exit(code int) {
+	if len(unparsable) > 0 {
+		fmt.Printf("\nSkipped files that do not parse (%d):\n", len(unparsable))
+		for _, err := range unparsable {
+			fmt.Printf("\t%s\n", strings.ReplaceAll(err.Error(), "\n", "\n\t"))
+		}
+	}
+	if len(failures) > 0 {
+		fmt.Printf("\nFailures (%d):\n", len(failures))
+		for _, err := range failures {
+			// Diagnostics may span several lines.
+			fmt.Printf("\t%s\n", strings.ReplaceAll(err.Error(), "\n", "\n\t"))
+		}
+		code = max(code, 1)
+	}
+	os.Exit(code)
 }
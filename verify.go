@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"slices"
+
+	"github.com/josharian/unrollbench/unroll"
+)
+
+var verifyCmd = newCommand("verify", "[packages]", "report unrolled loops that were edited by hand after they were generated", runVerify)
+
+func runVerify(c *command, args []string) {
+	if len(args) < 1 {
+		c.flags.Usage()
+	}
+	found := false
+	for _, pkg := range loadPackages(args) {
+		for _, file := range testFiles(pkg) {
+			src, err := os.ReadFile(file)
+			if err != nil {
+				fail(err)
+				continue
+			}
+			regions, err := unroll.Regions(src)
+			if err != nil {
+				fail(fmt.Errorf("%s: %v", rel(file), err))
+				continue
+			}
+			for _, r := range regions {
+				if r.Tampered() {
+					fmt.Printf("%s:%d: unrolled loop in %s was edited by hand\n", rel(file), r.Line, r.Func)
+					found = true
+				}
+			}
+		}
+	}
+	if found {
+		fmt.Printf("To let unrollbench rewrite such a loop anyway, delete the %q comment above it.\n", unroll.SumPrefix+"...")
+		exit(1)
+	}
+}
+
+// keepsEdits returns an error if rewriting file from src to out
+// would change an unrolled loop that was edited by hand,
+// so that rerolling or unrolling with a new factor does not undo the edit.
+func keepsEdits(file string, src, out []byte) error {
+	before, err := unroll.Regions(src)
+	if err != nil {
+		return nil // reported by the rewrite
+	}
+	after, err := unroll.Regions(out)
+	if err != nil {
+		return nil
+	}
+	for _, r := range before {
+		if r.Tampered() && !slices.ContainsFunc(after, r.Same) {
+			return fmt.Errorf("%s:%d: unrolled loop in %s was edited by hand; delete the %q comment above it to rewrite it anyway", rel(file), r.Line, r.Func, unroll.SumPrefix+"...")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"go/build"
+	"go/token"
+	"os"
+	"time"
+)
+
+// watchInterval is how often -watch checks for changes.
+const watchInterval = time.Second
+
+// watchPackages calls fn with the packages with import paths paths,
+// and then again with those packages whose test files change, forever.
+// It polls, rather than using OS notifications, to stay portable.
+// Because fn leaves unchanged files alone, its own writes
+// trigger at most one more, idempotent, call.
+func watchPackages(paths []string, fn func([]*build.Package)) {
+	seen := make(map[string]time.Time)
+	first := true
+	for {
+		var changed []*build.Package
+	Packages:
+		for _, pkg := range loadPackages(paths) {
+			modified := false
+			for _, file := range testFiles(pkg) {
+				fi, err := os.Stat(file)
+				if err != nil {
+					// Removed since the package was loaded.
+					continue
+				}
+				if time.Since(fi.ModTime()) < watchInterval/2 {
+					// Possibly still being written; look again next time.
+					continue Packages
+				}
+				if !fi.ModTime().Equal(seen[file]) {
+					seen[file] = fi.ModTime()
+					modified = true
+				}
+			}
+			if !modified {
+				continue
+			}
+			// Don't die on a file saved mid-edit; wait for the next save.
+			for _, file := range testFiles(pkg) {
+				if _, err := parseFile(token.NewFileSet(), file, nil); err != nil {
+					fmt.Println(err)
+					continue Packages
+				}
+			}
+			changed = append(changed, pkg)
+		}
+		if len(changed) > 0 {
+			if !first {
+				fmt.Println("Change detected at", time.Now().Format(time.TimeOnly))
+			}
+			fn(changed)
+		}
+		first = false
+		time.Sleep(watchInterval)
+	}
+}